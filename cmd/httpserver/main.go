@@ -1,22 +1,27 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-	"fmt"
-	"io"
+	"crypto/subtle"
 	"log"
-	"net/http"
+	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
-	"github.com/MichalGul/http_server_go/internal/headers"
+	"github.com/MichalGul/http_server_go/internal/accesslog"
+	"github.com/MichalGul/http_server_go/internal/cache"
+	"github.com/MichalGul/http_server_go/internal/metrics"
+	"github.com/MichalGul/http_server_go/internal/proxy"
 	"github.com/MichalGul/http_server_go/internal/request"
 	"github.com/MichalGul/http_server_go/internal/response"
 	"github.com/MichalGul/http_server_go/internal/server"
+	"github.com/MichalGul/http_server_go/internal/sse"
+	"github.com/MichalGul/http_server_go/internal/tunnel"
+	"github.com/MichalGul/http_server_go/internal/websocket"
 )
 
 const port = 42069
@@ -53,19 +58,220 @@ const OK = `<html>
 
 const PROXY_TARGET = "https://httpbin.org"
 
-func basicHandler(w *response.Writer, req *request.Request) {
+// Server events are logged to stderr at level set with LOG_LEVEL (debug, info, warn, error), info by default
+var logger = newLogger()
 
-	path := req.RequestLine.RequestTarget
+func newLogger() *slog.Logger {
+	var level slog.Level
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		err := level.UnmarshalText([]byte(value))
+		if err != nil {
+			log.Fatalf("Invalid LOG_LEVEL: %v", err)
+		}
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+}
 
-	if strings.HasPrefix(path, "/httpbin/") {
-		proxyHandler(w, req)
-		return
+var httpbinProxy = newHttpbinProxy()
+
+func newHttpbinProxy() *proxy.Proxy {
+	p, err := proxy.New(PROXY_TARGET, proxy.Options{StripPrefix: "/httpbin"})
+	if err != nil {
+		log.Fatalf("Error creating proxy: %v", err)
 	}
+	return p
+}
 
-	if path == "/video" {
-		videoHandler(w, req)
-		return
+// Cache in front of httpbin proxy, responses are kept on disk when CACHE_DIR is set
+var httpbinCache = newHttpbinCache()
+
+func newHttpbinCache() *cache.Cache {
+	options := cache.Options{}
+	if dir := os.Getenv("CACHE_DIR"); dir != "" {
+		store, err := cache.NewDiskStore(dir, 256<<20)
+		if err != nil {
+			log.Fatalf("Error opening cache directory: %v", err)
+		}
+		options.Store = store
+	}
+	return cache.New(httpbinProxy.Serve, options)
+}
+
+// Pool of upstreams serving /api/, enabled when UPSTREAMS lists their base urls (comma separated).
+// Its status is served on /admin/upstreams only when ADMIN_TOKEN is set, see requireToken
+var upstreamPool = newUpstreamPool()
+
+func newUpstreamPool() *proxy.Pool {
+	upstreams := os.Getenv("UPSTREAMS")
+	if upstreams == "" {
+		return nil
+	}
+	pool, err := proxy.NewPool(strings.Split(upstreams, ","), proxy.PoolOptions{
+		Balancing:   proxy.LeastConnections,
+		HealthCheck: proxy.HealthCheck{Path: "/healthz"},
+		Retries:     1,
+	})
+	if err != nil {
+		log.Fatalf("Error creating upstream pool: %v", err)
+	}
+	return pool
+}
+
+var okETag = response.StrongETag([]byte(OK))
+
+// Directory with static files, can be overridden with ASSETS_DIR environment variable
+const defaultAssetsDir = "assets"
+
+var assetsDir = getAssetsDir()
+
+var assetsHandler = server.NewFileServer(assetsDir, server.FileServerOptions{
+	ListDirectories: true,
+	StripPrefix:     "/assets",
+})
+
+func getAssetsDir() string {
+	if dir := os.Getenv("ASSETS_DIR"); dir != "" {
+		return dir
+	}
+	return defaultAssetsDir
+}
+
+// Forward proxy for CONNECT requests, enabled when TUNNEL_ALLOW lists permitted host:port targets (comma separated)
+var tunnelProxy = newTunnelProxy()
+
+func newTunnelProxy() *tunnel.Proxy {
+	allow := os.Getenv("TUNNEL_ALLOW")
+	if allow == "" {
+		return nil
+	}
+	return &tunnel.Proxy{
+		Allow: strings.Split(allow, ","),
+		OnClose: func(stats tunnel.Stats) {
+			logger.Info("tunnel closed", "target", stats.Target, "duration", stats.Duration,
+				"bytes_up", stats.BytesUpstream, "bytes_down", stats.BytesDownstream)
+		},
+	}
+}
+
+// Server metrics in Prometheus format, served on METRICS_PATH (/metrics by default)
+var metricsRegistry = metrics.NewRegistry()
+
+func getMetricsPath() string {
+	if path := os.Getenv("METRICS_PATH"); path != "" {
+		return path
+	}
+	return "/metrics"
+}
+
+// requireToken lets through only requests with "Authorization: Bearer <token>". Admin endpoints
+// are mounted only when ADMIN_TOKEN is set, they expose internal addresses of upstreams.
+func requireToken(token string, handler server.Handler) server.Handler {
+	expected := []byte("Bearer " + token)
+	return func(w *response.Writer, req *request.Request) {
+		authorization, _ := req.Headers.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(authorization), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			server.HandlerError{StatusCode: response.UnauthorizedStatusCode, Message: "Unauthorized"}.Write(w)
+			return
+		}
+		handler(w, req)
+	}
+}
+
+// withConnectionLimits sets limits from MAX_CONNECTIONS and MAX_CONNECTIONS_PER_IP, OVERLOAD_POLICY
+// selects what happens over MAX_CONNECTIONS: block (default) or reject with 503
+func withConnectionLimits(config server.Config) server.Config {
+	config.MaxConnections = getIntEnv("MAX_CONNECTIONS")
+	config.MaxConnectionsPerIP = getIntEnv("MAX_CONNECTIONS_PER_IP")
+	switch policy := os.Getenv("OVERLOAD_POLICY"); policy {
+	case "", "block":
+		config.OverloadPolicy = server.OverloadBlock
+	case "reject":
+		config.OverloadPolicy = server.OverloadReject
+	default:
+		log.Fatalf("Invalid OVERLOAD_POLICY: %s", policy)
+	}
+	return config
+}
+
+func getIntEnv(name string) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		log.Fatalf("Invalid %s: %s", name, value)
+	}
+	return number
+}
+
+// Access log is written when ACCESS_LOG is set to file path or "-" for stdout, ACCESS_LOG_FORMAT
+// selects common (default), combined or json format. Log file is rotated at 100 MiB.
+func withAccessLog(handler server.Handler) server.Handler {
+	path := os.Getenv("ACCESS_LOG")
+	if path == "" {
+		return handler
+	}
+	options := accesslog.Options{}
+	switch format := os.Getenv("ACCESS_LOG_FORMAT"); format {
+	case "", "common":
+		options.Format = accesslog.FormatCommon
+	case "combined":
+		options.Format = accesslog.FormatCombined
+	case "json":
+		options.Format = accesslog.FormatJSON
+	default:
+		log.Fatalf("Invalid ACCESS_LOG_FORMAT: %s", format)
+	}
+	if path != "-" {
+		file, err := accesslog.NewRotatingFile(path, 100<<20, 5)
+		if err != nil {
+			log.Fatalf("Error opening access log: %v", err)
+		}
+		options.Output = file
+	}
+	return accesslog.New(handler, options).Serve
+}
+
+// newHandler sends CONNECT requests to tunnel proxy and everything else to router
+func newHandler() server.Handler {
+	router := newRouter()
+	return func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method == "CONNECT" && tunnelProxy != nil {
+			tunnelProxy.Serve(w, req)
+			return
+		}
+		router.Serve(w, req)
+	}
+}
+
+func newRouter() *server.Router {
+	router := server.NewRouter()
+	router.Handle("GET", "/httpbin/", httpbinCache.Serve)
+	router.Handle("POST", "/httpbin/", httpbinCache.Serve)
+	router.Handle("GET", "/video", videoHandler)
+	router.Handle("GET", getMetricsPath(), metricsRegistry.Serve)
+	if upstreamPool != nil {
+		apiProxy := proxy.NewPoolProxy(upstreamPool, proxy.Options{StripPrefix: "/api"})
+		for _, method := range []string{"GET", "POST", "PUT", "PATCH", "DELETE"} {
+			router.Handle(method, "/api/", apiProxy.Serve)
+		}
+		if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+			router.Handle("GET", "/admin/upstreams", requireToken(token, upstreamPool.ServeStatus))
+		}
 	}
+	router.Handle("GET", "/events", eventsHandler)
+	router.Handle("GET", "/ws", echoHandler)
+	router.Handle("GET", "/assets", assetsHandler)
+	router.Handle("GET", "/assets/", assetsHandler)
+	router.Handle("GET", "/", basicHandler)
+	return router
+}
+
+func basicHandler(w *response.Writer, req *request.Request) {
+
+	path := req.RequestLine.RequestTarget
 
 	if path == "/yourproblem" {
 		w.WriteStatusLine(response.BadRequestStatusCode)
@@ -81,9 +287,14 @@ func basicHandler(w *response.Writer, req *request.Request) {
 		w.WriteHeaders(headers)
 		w.WriteBody([]byte(SERVER_ERROR))
 	} else {
+		// Static page, clients can revalidate cached copy with If-None-Match
+		if response.CheckPreconditions(w, req, okETag, time.Time{}) {
+			return
+		}
 		w.WriteStatusLine(response.OkStatusCode)
 		headers := response.GetDefaultHeaders(len(OK))
 		headers.Set("Content-Type", "text/html")
+		headers.Set("ETag", okETag)
 		w.WriteHeaders(headers)
 		w.WriteBody([]byte(OK))
 	}
@@ -91,126 +302,61 @@ func basicHandler(w *response.Writer, req *request.Request) {
 }
 
 func videoHandler(w *response.Writer, req *request.Request) {
-
-	w.WriteStatusLine(response.OkStatusCode)
-	file, err := os.ReadFile("/home/michal/workspace/httpfromtcp/http_server_go/assets/vim.mp4")
-
-	if err != nil {
-		handler500(w, req)
-	}
-
-	h := response.GetDefaultHeaders(len(file))
-	h.Set("Content-Type", "video/mp4")
-
-	w.WriteHeaders(h)
-	w.WriteBody(file)
-	return
-
+	server.ServeFile(w, req, filepath.Join(assetsDir, "vim.mp4"))
 }
 
-func proxyHandler(w *response.Writer, req *request.Request) {
-
-	path := req.RequestLine.RequestTarget
-
-	trimmedPath := strings.TrimPrefix(path, "/httpbin/")
-	proxyPath := fmt.Sprintf("%s/%s", PROXY_TARGET, trimmedPath)
-
-	w.WriteStatusLine(response.OkStatusCode)
-	h := response.GetDefaultHeaders(0)
-	h.Set("Transfer-Encoding", "chunked")
-	h.Set("Trailer", "X-Content-SHA256, X-Content-Length")
-	delete(h, "Content-Length")
-
-	trailers := headers.NewHeaders()
-	delete(trailers, "Content-Length")
-
-	// Write headers
-	err := w.WriteHeaders(h)
+// eventsHandler pushes current server time every second until client disconnects
+func eventsHandler(w *response.Writer, req *request.Request) {
+	stream, err := sse.NewStream(w, req, sse.Options{})
 	if err != nil {
-		fmt.Printf("error: error calling proxy server %s", proxyPath)
 		return
 	}
+	defer stream.Close()
 
-	// Request to proxy
-	proxyResponse, err := http.Get(proxyPath)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	id, _ := strconv.Atoi(stream.LastEventID())
+	for {
+		select {
+		case now := <-ticker.C:
+			id++
+			err := stream.Send(sse.Event{ID: strconv.Itoa(id), Event: "time", Data: now.Format(time.RFC3339)})
+			if err != nil {
+				return
+			}
+		case <-stream.Done():
+			return
+		}
+	}
+}
+
+// echoHandler sends every websocket message back to the client
+func echoHandler(w *response.Writer, req *request.Request) {
+	conn, err := websocket.Upgrader{}.Upgrade(w, req)
 	if err != nil {
-		fmt.Printf("error: error calling proxy server %s", proxyPath)
 		return
 	}
-	defer proxyResponse.Body.Close()
-
-	//Handle response from proxy
-	buf := make([]byte, 256)
-
-	// Store whole message
-	var fullMessage []byte
-
-	// handling reading streaming data
+	defer conn.Close()
 	for {
-		// Read data from proxy info buffor
-		n, err := proxyResponse.Body.Read(buf)
+		messageType, data, err := conn.ReadMessage()
 		if err != nil {
-			if err == io.EOF {
-				// Write done of the chunked body
-				break
-			}
-			fmt.Printf("error: proxing data error %v", err)
-			panic(err)
+			return
 		}
-
-		fmt.Printf("chunk (%d bytes): %s\n", n, buf[:n])
-
-		fullMessage = append(fullMessage, buf[:n]...)
-		_, err = w.WriteChunkedBody(buf[:n]) // Write read :n bytes to response
-		if err != nil {
-			fmt.Printf("error: writting data error %v", err)
+		if conn.WriteMessage(messageType, data) != nil {
+			return
 		}
 	}
-	// Mark finishing writting body
-	_, err = w.WriteChunkedBodyDone()
-	if err != nil {
-		fmt.Printf("error: writting chunked done data error %v", err)
-	}
-
-	// Calculate hex32 of whole message
-	messageSha := sha256.Sum256(fullMessage)
-	hashHex := hex.EncodeToString(messageSha[:])
-
-	msgLength := len(fullMessage)
-
-	// Add trailers after body
-	trailers.Set("X-Content-SHA256", hashHex)
-	trailers.Set("X-Content-Length", strconv.Itoa(msgLength))
-
-	// Write trailers to client
-	err = w.WriteTrailers(trailers)
-	if err != nil {
-		fmt.Printf("error: writting trailers %v", err)
-	}
-
-}
-
-func handler500(w *response.Writer, _ *request.Request) {
-	w.WriteStatusLine(response.InternalServerErrorStatusCode)
-	body := []byte(`<html>
-<head>
-<title>500 Internal Server Error</title>
-</head>
-<body>
-<h1>Internal Server Error</h1>
-<p>Okay, you know what? This one is on me.</p>
-</body>
-</html>
-`)
-	h := response.GetDefaultHeaders(len(body))
-	h.Set("Content-Type", "text/html")
-	w.WriteHeaders(h)
-	w.WriteBody(body)
 }
 
 func main() {
 
-	serv, err := server.Serve(port, basicHandler)
+	serv, err := server.ServeConfig(withConnectionLimits(server.Config{
+		Port:       port,
+		Handler:    withAccessLog(newHandler()),
+		ServerName: "http_server_go",
+		Logger:     logger,
+		Metrics:    metricsRegistry,
+	}))
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
@@ -0,0 +1,196 @@
+// Package accesslog records every request served by wrapped handler in Apache Common or
+// Combined Log Format or as JSON lines.
+package accesslog
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/response"
+	"github.com/MichalGul/http_server_go/internal/server"
+)
+
+type Format int
+
+const (
+	// FormatCommon is Apache Common Log Format:
+	// host ident authuser [date] "request line" status bytes
+	FormatCommon Format = iota
+	// FormatCombined is Common Log Format followed by "referer" "user agent"
+	FormatCombined
+	// FormatJSON writes one JSON object per request
+	FormatJSON
+)
+
+// Options configures access log
+type Options struct {
+	// Output receives log lines, os.Stdout when nil. Use RotatingFile to log to file.
+	Output io.Writer
+	Format Format
+	// SampleRate is fraction of requests logged (e.g. 0.1 logs every tenth request on average),
+	// all requests are logged when it is 0 or 1 and above. Server errors (5xx) are logged always.
+	SampleRate float64
+}
+
+// Entry describes served request
+type Entry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	Target     string    `json:"target"`
+	Protocol   string    `json:"protocol"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	// Duration is how long handler took, JSON has it in milliseconds
+	Duration  time.Duration `json:"-"`
+	Referer   string        `json:"referer,omitempty"`
+	UserAgent string        `json:"user_agent,omitempty"`
+	RequestID string        `json:"request_id,omitempty"`
+}
+
+// AccessLog is middleware logging requests served by wrapped handler
+type AccessLog struct {
+	handler server.Handler
+	output  io.Writer
+	format  Format
+	sample  float64
+	now     func() time.Time
+	random  func() float64
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func New(handler server.Handler, options Options) *AccessLog {
+	output := options.Output
+	if output == nil {
+		output = os.Stdout
+	}
+	return &AccessLog{
+		handler: handler,
+		output:  output,
+		format:  options.Format,
+		sample:  options.SampleRate,
+		now:     time.Now,
+		random:  rand.Float64,
+	}
+}
+
+// Serve calls wrapped handler and logs request once it returns
+func (a *AccessLog) Serve(w *response.Writer, req *request.Request) {
+	start := a.now()
+	a.handler(w, req)
+
+	status := int(w.StatusCode())
+	if !a.sampled(status) {
+		return
+	}
+	referer, _ := req.Headers.Get("Referer")
+	userAgent, _ := req.Headers.Get("User-Agent")
+	a.write(Entry{
+		Time:       start,
+		RemoteAddr: req.RemoteAddr,
+		Method:     req.RequestLine.Method,
+		Target:     req.RequestLine.RequestTarget,
+		Protocol:   "HTTP/" + req.RequestLine.HttpVersion,
+		Status:     status,
+		Bytes:      w.BodyBytes(),
+		Duration:   a.now().Sub(start),
+		Referer:    referer,
+		UserAgent:  userAgent,
+		RequestID:  req.ID,
+	})
+}
+
+func (a *AccessLog) sampled(status int) bool {
+	if a.sample <= 0 || a.sample >= 1 || status >= 500 {
+		return true
+	}
+	return a.random() < a.sample
+}
+
+// write formats entry and writes it with single Write call
+func (a *AccessLog) write(entry Entry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch a.format {
+	case FormatJSON:
+		a.buf = appendJSON(a.buf[:0], entry)
+	case FormatCombined:
+		a.buf = appendCommon(a.buf[:0], entry)
+		a.buf = append(a.buf, ' ')
+		a.buf = appendQuoted(a.buf, entry.Referer)
+		a.buf = append(a.buf, ' ')
+		a.buf = appendQuoted(a.buf, entry.UserAgent)
+	default:
+		a.buf = appendCommon(a.buf[:0], entry)
+	}
+	a.buf = append(a.buf, '\n')
+	a.output.Write(a.buf)
+}
+
+// Time layout of Common Log Format
+const commonTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+func appendCommon(dst []byte, entry Entry) []byte {
+	host := entry.RemoteAddr
+	if ip, _, err := net.SplitHostPort(host); err == nil {
+		host = ip
+	}
+	if host == "" {
+		host = "-"
+	}
+	dst = append(dst, host...)
+	dst = append(dst, " - - ["...)
+	dst = entry.Time.AppendFormat(dst, commonTimeLayout)
+	dst = append(dst, "] "...)
+	dst = appendQuoted(dst, entry.Method+" "+entry.Target+" "+entry.Protocol)
+	dst = append(dst, ' ')
+	dst = strconv.AppendInt(dst, int64(entry.Status), 10)
+	dst = append(dst, ' ')
+	if entry.Bytes == 0 {
+		return append(dst, '-')
+	}
+	return strconv.AppendInt(dst, entry.Bytes, 10)
+}
+
+// appendQuoted writes value in double quotes, empty value is written as "-".
+// Quotes, backslashes and non printable bytes are escaped so client can't forge log lines.
+func appendQuoted(dst []byte, value string) []byte {
+	if value == "" {
+		return append(dst, `"-"`...)
+	}
+	const hex = "0123456789abcdef"
+	dst = append(dst, '"')
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"' || c == '\\':
+			dst = append(dst, '\\', c)
+		case c < ' ' || c >= 127:
+			dst = append(dst, '\\', 'x', hex[c>>4], hex[c&0xf])
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return append(dst, '"')
+}
+
+func appendJSON(dst []byte, entry Entry) []byte {
+	type jsonEntry struct {
+		Entry
+		DurationMs float64 `json:"duration_ms"`
+	}
+	data, err := json.Marshal(jsonEntry{Entry: entry, DurationMs: float64(entry.Duration.Microseconds()) / 1000})
+	if err != nil {
+		return dst
+	}
+	return append(dst, data...)
+}
@@ -0,0 +1,108 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(target string, h headers.Headers) *request.Request {
+	return &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: target, HttpVersion: "1.1"},
+		Headers:     h,
+		RemoteAddr:  "127.0.0.1:54321",
+		ID:          "1-1",
+	}
+}
+
+func textHandler(status response.StatusCode, body string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(status)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}
+}
+
+// newTestLog returns access log with fixed clock, every call of now moves it by 1.5ms
+func newTestLog(handler func(w *response.Writer, req *request.Request), options Options) *AccessLog {
+	a := New(handler, options)
+	current := time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600))
+	a.now = func() time.Time {
+		now := current
+		current = current.Add(1500 * time.Microsecond)
+		return now
+	}
+	return a
+}
+
+func TestCommonFormats(t *testing.T) {
+	var out bytes.Buffer
+	a := newTestLog(textHandler(response.OkStatusCode, "hello"), Options{Output: &out})
+	a.Serve(response.NewWritter(io.Discard), newRequest("/apache_pb.gif", headers.NewHeaders()))
+	assert.Equal(t, "127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] \"GET /apache_pb.gif HTTP/1.1\" 200 5\n", out.String())
+
+	// Test: Combined format, empty body and escaping of client values
+	out.Reset()
+	a = newTestLog(textHandler(response.NotFoundStatusCode, ""), Options{Output: &out, Format: FormatCombined})
+	h := headers.Headers{"referer": "http://example.com/", "user-agent": "evil\"agent\n127.0.0.1 - - fake"}
+	a.Serve(response.NewWritter(io.Discard), newRequest("/missing", h))
+	assert.Equal(t, "127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] \"GET /missing HTTP/1.1\" 404 - "+
+		"\"http://example.com/\" \"evil\\\"agent\\x0a127.0.0.1 - - fake\"\n", out.String())
+}
+
+func TestJSONFormat(t *testing.T) {
+	var out bytes.Buffer
+	a := newTestLog(textHandler(response.OkStatusCode, "hello"), Options{Output: &out, Format: FormatJSON})
+	a.Serve(response.NewWritter(io.Discard), newRequest("/json", headers.Headers{"user-agent": "curl/8.0"}))
+
+	require.True(t, strings.HasSuffix(out.String(), "}\n"))
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &entry))
+	assert.Equal(t, "2000-10-10T13:55:36-07:00", entry["time"])
+	assert.Equal(t, "127.0.0.1:54321", entry["remote_addr"])
+	assert.Equal(t, "GET", entry["method"])
+	assert.Equal(t, "/json", entry["target"])
+	assert.Equal(t, "HTTP/1.1", entry["protocol"])
+	assert.Equal(t, float64(200), entry["status"])
+	assert.Equal(t, float64(5), entry["bytes"])
+	assert.Equal(t, 1.5, entry["duration_ms"])
+	assert.Equal(t, "curl/8.0", entry["user_agent"])
+	assert.Equal(t, "1-1", entry["request_id"])
+	assert.NotContains(t, entry, "referer")
+}
+
+func TestSampling(t *testing.T) {
+	var out bytes.Buffer
+	status := response.OkStatusCode
+	handler := func(w *response.Writer, req *request.Request) {
+		textHandler(status, "")(w, req)
+	}
+	a := newTestLog(handler, Options{Output: &out, SampleRate: 0.25})
+	draws := []float64{0.1, 0.5, 0.9, 0.2}
+	a.random = func() float64 {
+		draw := draws[0]
+		draws = draws[1:]
+		return draw
+	}
+
+	for i := 0; i < 4; i++ {
+		a.Serve(response.NewWritter(io.Discard), newRequest("/sampled", headers.NewHeaders()))
+	}
+	assert.Equal(t, 2, strings.Count(out.String(), "\n"))
+
+	// Test: Server errors are logged always
+	out.Reset()
+	status = response.InternalServerErrorStatusCode
+	a.random = func() float64 { return 0.99 }
+	a.Serve(response.NewWritter(io.Discard), newRequest("/error", headers.NewHeaders()))
+	assert.Contains(t, out.String(), "\" 500 -\n")
+}
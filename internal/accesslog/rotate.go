@@ -0,0 +1,107 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// RotatingFile is log file rotated when it grows over MaxSize. Current file is renamed
+// to path.1, older ones are shifted to path.2 ... up to MaxBackups, the oldest is removed.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRotatingFile opens file at path for appending. File is rotated before write which would
+// make it larger than maxSize bytes, maxBackups rotated files are kept (at least one).
+func NewRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("max size must be positive: %d", maxSize)
+	}
+	r := &RotatingFile{path: path, maxSize: maxSize, maxBackups: max(maxBackups, 1)}
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error opening log file: %v", err)
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// Write appends p to the file, log line is never split between files
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		err := r.rotate()
+		if r.file == nil {
+			return 0, err
+		}
+		// Rotation failed but file is still open, line goes to it
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts backups, moves current file to path.1 and opens new one
+func (r *RotatingFile) rotate() error {
+	err := r.file.Close()
+	r.file = nil
+	if err != nil {
+		return err
+	}
+	os.Remove(r.backup(r.maxBackups))
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		os.Rename(r.backup(i), r.backup(i+1))
+	}
+	renameErr := os.Rename(r.path, r.backup(1))
+	// File is reopened even when rename failed so logging goes on in the same file
+	err = r.open()
+	if err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return fmt.Errorf("error rotating log file: %v", renameErr)
+	}
+	return nil
+}
+
+func (r *RotatingFile) backup(index int) string {
+	return r.path + "." + strconv.Itoa(index)
+}
+
+// Close closes current file
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
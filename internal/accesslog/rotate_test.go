@@ -0,0 +1,50 @@
+package accesslog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	require.NoError(t, os.WriteFile(path, []byte("old\n"), 0o644))
+
+	file, err := NewRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	defer file.Close()
+
+	// Test: Existing content counts towards size
+	file.Write([]byte("first\n"))
+	assert.Equal(t, "old\nfirst\n", readFile(t, path))
+
+	// Test: Line which doesn't fit rotates file, lines are never split
+	file.Write([]byte("second\n"))
+	assert.Equal(t, "second\n", readFile(t, path))
+	assert.Equal(t, "old\nfirst\n", readFile(t, path+".1"))
+
+	file.Write([]byte("third\n"))
+	file.Write([]byte("fourth line longer than limit\n"))
+	assert.Equal(t, "fourth line longer than limit\n", readFile(t, path))
+	assert.Equal(t, "third\n", readFile(t, path+".1"))
+	assert.Equal(t, "second\n", readFile(t, path+".2"))
+	// Test: Only MaxBackups files are kept
+	assert.NoFileExists(t, path+".3")
+
+	require.NoError(t, file.Close())
+	_, err = file.Write([]byte("closed\n"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+
+	_, err = NewRotatingFile(path, 0, 1)
+	assert.Error(t, err)
+}
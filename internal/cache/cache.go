@@ -0,0 +1,590 @@
+// Package cache implements shared HTTP cache (RFC 9111) in front of server handlers
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/response"
+	"github.com/MichalGul/http_server_go/internal/server"
+)
+
+const (
+	defaultMaxSize      = 64 << 20
+	defaultMaxEntrySize = 8 << 20
+)
+
+// Size of reads of response body passed from handler to client
+const copyBufferSize = 32 << 10
+
+// Header telling whether response came from cache: HIT, STALE, REVALIDATED, MISS or BYPASS
+const cacheStatusHeader = "X-Cache"
+
+// Statuses cacheable when response defines its freshness (RFC 9110 15.1)
+var cacheableStatus = map[response.StatusCode]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true, 404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// Headers describing connection or framing of recorded message, they are not stored
+var unstoredHeaders = []string{"Connection", "Keep-Alive", "Transfer-Encoding", "Trailer", "Content-Length", cacheStatusHeader}
+
+// Request headers removed when response is fetched for cache, stored response must be complete
+// and client conditions are evaluated against it
+var conditionalHeaders = []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range", "Range"}
+
+type Options struct {
+	// Store keeps cached responses, memory store bounded by MaxSize when nil
+	Store Store
+	// MaxSize of default memory store in bytes, 64 MiB when 0
+	MaxSize int64
+	// MaxEntrySize is largest body stored, 8 MiB when 0. Larger responses are passed to client
+	// without being stored.
+	MaxEntrySize int64
+}
+
+// Entry is cached response
+type Entry struct {
+	StatusCode response.StatusCode
+	Header     headers.Headers
+	Body       []byte
+	// Stored is time response was received
+	Stored time.Time
+	// InitialAge is age response already had when it was received, from its Age and Date headers
+	InitialAge time.Duration
+	// Lifetime is how long response stays fresh, 0 means it has to be revalidated before use
+	Lifetime time.Duration
+	// StaleWhileRevalidate is how long stale response can still be served while it is revalidated in background
+	StaleWhileRevalidate time.Duration
+}
+
+func (e *Entry) size() int64 {
+	size := int64(len(e.Body))
+	for name, value := range e.Header {
+		size += int64(len(name) + len(value))
+	}
+	return size
+}
+
+// age is current age of response (RFC 9111 section 4.2.3)
+func (e *Entry) age(now time.Time) time.Duration {
+	return e.InitialAge + now.Sub(e.Stored)
+}
+
+// initialAge is larger of age which response brought in Age header and age apparent from its Date,
+// response could have spent time in caches which don't update Date
+func initialAge(h headers.Headers, received time.Time) time.Duration {
+	var apparentAge, ageValue time.Duration
+	if date, exists := h.Get("Date"); exists {
+		if dateValue, err := headers.ParseTime(date); err == nil {
+			apparentAge = max(received.Sub(dateValue), 0)
+		}
+	}
+	if age, exists := h.Get("Age"); exists {
+		ageValue, _ = headers.ParseDeltaSeconds(strings.TrimSpace(age))
+	}
+	return max(apparentAge, ageValue)
+}
+
+// Cache serves GET and HEAD requests of wrapped handler from stored responses
+type Cache struct {
+	handler      server.Handler
+	store        Store
+	maxEntrySize int64
+	now          func() time.Time
+
+	mu sync.Mutex
+	// vary holds header names response for URL varies on
+	vary     map[string][]string
+	inflight map[string]*flight
+}
+
+// flight is response fetch shared by concurrent requests for the same key
+type flight struct {
+	done   chan struct{}
+	entry  *Entry
+	shared bool
+}
+
+// New wraps handler with cache. Response is passed to client as handler writes it and is recorded
+// only while it can be stored, so streamed responses keep streaming. Handler gets writer without
+// network connection, handlers hijacking connection or waiting for CloseNotify must not be wrapped.
+func New(handler server.Handler, options Options) *Cache {
+	store := options.Store
+	if store == nil {
+		maxSize := options.MaxSize
+		if maxSize <= 0 {
+			maxSize = defaultMaxSize
+		}
+		store = NewMemoryStore(maxSize)
+	}
+	maxEntrySize := options.MaxEntrySize
+	if maxEntrySize <= 0 {
+		maxEntrySize = defaultMaxEntrySize
+	}
+	return &Cache{
+		handler:      handler,
+		store:        store,
+		maxEntrySize: maxEntrySize,
+		now:          time.Now,
+		vary:         map[string][]string{},
+		inflight:     map[string]*flight{},
+	}
+}
+
+// Serve is server.Handler answering from cache or wrapped handler
+func (c *Cache) Serve(w *response.Writer, req *request.Request) {
+	method := req.RequestLine.Method
+	if method != "GET" && method != "HEAD" {
+		// Unsafe methods change resource, stored response is not valid anymore
+		if method != "OPTIONS" && method != "TRACE" {
+			c.invalidate(req)
+		}
+		c.handler(w, req)
+		return
+	}
+
+	requestControl := requestCacheControl(req)
+	_, hasRange := req.Headers.Get("Range")
+	if requestControl.Has("no-store") || hasRange {
+		w.Header().Set(cacheStatusHeader, "BYPASS")
+		c.handler(w, req)
+		return
+	}
+
+	key := c.entryKey(req)
+	now := c.now()
+	entry, found := c.store.Get(key)
+	if found && !requestControl.Has("no-cache") {
+		age := entry.age(now)
+		maxAge, hasMaxAge := requestControl.Seconds("max-age")
+		acceptable := !hasMaxAge || age <= maxAge
+		if age < entry.Lifetime && acceptable {
+			c.writeEntry(w, req, entry, "HIT")
+			return
+		}
+		if age < entry.Lifetime+entry.StaleWhileRevalidate && acceptable {
+			c.writeEntry(w, req, entry, "STALE")
+			go c.fetch(key, cloneRequest(req), entry, nil)
+			return
+		}
+	}
+
+	if !found && method == "HEAD" {
+		// Body of HEAD response is discarded, there is nothing to store
+		w.Header().Set(cacheStatusHeader, "MISS")
+		c.handler(w, req)
+		return
+	}
+
+	var cached *Entry
+	if found {
+		cached = entry
+	}
+	c.fetch(key, req, cached, w)
+}
+
+// fetch gets response from handler and stores it when it is storable. Response is passed on to w
+// as handler writes it, w is nil for background revalidation. Concurrent fetches of the same key
+// wait for the first one and are answered from its entry, or by handler when it can't be shared.
+// Cached entry is revalidated with conditional request.
+func (c *Cache) fetch(key string, req *request.Request, cached *Entry, w *response.Writer) {
+	c.mu.Lock()
+	if call, exists := c.inflight[key]; exists {
+		c.mu.Unlock()
+		if w == nil {
+			return
+		}
+		<-call.done
+		if !call.shared {
+			w.Header().Set(cacheStatusHeader, "MISS")
+			c.handler(w, req)
+			return
+		}
+		c.writeEntry(w, req, call.entry, "HIT")
+		return
+	}
+	call := &flight{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.mu.Unlock()
+	defer c.finish(key, call, nil)
+
+	fetchReq := cloneRequest(req)
+	fetchReq.RequestLine.Method = "GET"
+	for _, name := range conditionalHeaders {
+		fetchReq.Headers.Del(name)
+	}
+	if cached != nil {
+		if etag, exists := cached.Header.Get("ETag"); exists {
+			fetchReq.Headers.Set("If-None-Match", etag)
+		}
+		if lastModified, exists := cached.Header.Get("Last-Modified"); exists {
+			fetchReq.Headers.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	out, err := c.start(fetchReq)
+	if err != nil {
+		if w != nil {
+			w.Header().Set(cacheStatusHeader, "MISS")
+			c.handler(w, req)
+		}
+		return
+	}
+	defer out.close()
+	entry := out.entry
+
+	if cached != nil && entry.StatusCode == response.NotModifiedStatusCode {
+		// Stored response is still valid, update its metadata from 304
+		updated := *cached
+		updated.Header = maps.Clone(cached.Header)
+		for name, value := range entry.Header {
+			if !containsFold([]string{"Content-Type", "Content-Encoding", "Content-Range"}, name) {
+				updated.Header.Set(name, value)
+			}
+		}
+		updated.Stored = entry.Stored
+		updated.InitialAge = entry.InitialAge
+		if c.storable(req, &updated) {
+			setFreshness(&updated)
+			c.save(req, &updated)
+			c.finish(key, call, &updated)
+		}
+		if w != nil {
+			c.writeEntry(w, req, &updated, "REVALIDATED")
+		}
+		return
+	}
+
+	// Everything needed to decide is in the head, response which won't be stored isn't recorded
+	storable := c.storable(req, entry) && out.head.ContentLength <= c.maxEntrySize
+	if !storable {
+		c.finish(key, call, nil)
+	}
+
+	var body *response.BodyWriter
+	if w != nil && c.writeHead(w, req, entry, "MISS", out.head.ContentLength) {
+		if !out.hasBody() {
+			w.WriteHeaders(nil)
+		} else {
+			body, err = w.Body()
+			if err != nil {
+				body = nil
+			}
+		}
+	}
+
+	var recorded bytes.Buffer
+	buf := make([]byte, copyBufferSize)
+	complete := false
+	for body != nil || storable {
+		n, readErr := out.reader.Read(buf)
+		if n > 0 && storable {
+			if int64(recorded.Len()+n) > c.maxEntrySize {
+				storable = false
+				recorded = bytes.Buffer{}
+				c.finish(key, call, nil)
+			} else {
+				recorded.Write(buf[:n])
+			}
+		}
+		if n > 0 && body != nil {
+			_, err = body.Write(buf[:n])
+			if err == nil {
+				// Streamed responses reach client as soon as handler writes them
+				err = w.Flush()
+			}
+			if err != nil {
+				body = nil
+			}
+		}
+		if errors.Is(readErr, io.EOF) {
+			complete = true
+			break
+		}
+		if readErr != nil {
+			// Handler left response incomplete, unfinished body tells client so
+			return
+		}
+	}
+	if !complete {
+		return
+	}
+	if body != nil {
+		body.Close()
+	}
+	if storable {
+		entry.Body = recorded.Bytes()
+		setFreshness(entry)
+		c.save(req, entry)
+		c.finish(key, call, entry)
+	}
+}
+
+// finish releases requests waiting for call, entry is nil when response can't be shared with them
+func (c *Cache) finish(key string, call *flight, entry *Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inflight[key] != call {
+		return
+	}
+	delete(c.inflight, key)
+	call.entry, call.shared = entry, entry != nil
+	close(call.done)
+}
+
+// handlerOutput is response handler writes, it is parsed while handler is still running
+// so it can be passed on before handler returns
+type handlerOutput struct {
+	head   *response.Response
+	entry  *Entry
+	reader *response.Reader
+	pipe   *io.PipeReader
+	done   chan struct{}
+}
+
+// start runs handler in background and reads head of response it writes, entry has no body yet
+func (c *Cache) start(req *request.Request) (*handlerOutput, error) {
+	pipeReader, pipeWriter := io.Pipe()
+	out := &handlerOutput{reader: response.NewReader(pipeReader), pipe: pipeReader, done: make(chan struct{})}
+	go func() {
+		defer close(out.done)
+		recorder := response.NewWritter(pipeWriter)
+		c.handler(recorder, req)
+		pipeWriter.CloseWithError(recorder.Finish())
+	}()
+
+	head, err := out.reader.ReadHead("GET")
+	if err != nil {
+		out.close()
+		return nil, fmt.Errorf("malformed recorded response: %v", err)
+	}
+	out.head = head
+	out.entry = &Entry{StatusCode: head.StatusLine.StatusCode, Header: maps.Clone(head.Headers), Stored: c.now()}
+	out.entry.InitialAge = initialAge(out.entry.Header, out.entry.Stored)
+	for _, name := range unstoredHeaders {
+		out.entry.Header.Del(name)
+	}
+	if _, exists := out.entry.Header.Get("Date"); !exists {
+		out.entry.Header.Set("Date", headers.FormatTime(out.entry.Stored))
+	}
+	return out, nil
+}
+
+// hasBody reports whether response has body, head can be parsed together with whole body
+// so parsing state doesn't tell
+func (o *handlerOutput) hasBody() bool {
+	statusCode := o.head.StatusLine.StatusCode
+	return statusCode >= 200 && statusCode != response.NoContentStatusCode &&
+		statusCode != response.NotModifiedStatusCode && o.head.ContentLength != 0
+}
+
+// close stops reading response, handler gets error on next write, and waits until handler returns
+func (o *handlerOutput) close() {
+	o.pipe.Close()
+	<-o.done
+}
+
+// storable decides if shared cache may store response (RFC 9111 3)
+func (c *Cache) storable(req *request.Request, entry *Entry) bool {
+	if !cacheableStatus[entry.StatusCode] {
+		return false
+	}
+	control := responseCacheControl(entry)
+	if control.Has("no-store") || control.Has("private") || requestCacheControl(req).Has("no-store") {
+		return false
+	}
+	if vary, _ := entry.Header.Get("Vary"); strings.TrimSpace(vary) == "*" {
+		return false
+	}
+	if _, exists := entry.Header.Get("Set-Cookie"); exists {
+		return false
+	}
+	if _, exists := req.Headers.Get("Authorization"); exists && !control.Has("public") && !control.Has("s-maxage") {
+		return false
+	}
+
+	// Response has to define freshness or carry validator so it can be revalidated
+	_, hasETag := entry.Header.Get("ETag")
+	_, hasLastModified := entry.Header.Get("Last-Modified")
+	_, hasExpires := entry.Header.Get("Expires")
+	return control.Has("max-age") || control.Has("s-maxage") || hasExpires || control.Has("public") || hasETag || hasLastModified
+}
+
+// setFreshness computes freshness lifetime, s-maxage takes precedence over max-age and Expires
+func setFreshness(entry *Entry) {
+	control := responseCacheControl(entry)
+	entry.Lifetime = 0
+	entry.StaleWhileRevalidate = 0
+	if control.Has("no-cache") {
+		return
+	}
+
+	if sMaxAge, ok := control.Seconds("s-maxage"); ok {
+		entry.Lifetime = sMaxAge
+	} else if maxAge, ok := control.Seconds("max-age"); ok {
+		entry.Lifetime = maxAge
+	} else if expiresValue, exists := entry.Header.Get("Expires"); exists {
+		// Invalid Expires means already expired
+		expires, err := headers.ParseTime(expiresValue)
+		dateValue, _ := entry.Header.Get("Date")
+		date, dateErr := headers.ParseTime(dateValue)
+		if err == nil && dateErr == nil && expires.After(date) {
+			entry.Lifetime = expires.Sub(date)
+		}
+	}
+
+	// Shared cache can't serve stale responses which must be revalidated
+	if control.Has("must-revalidate") || control.Has("proxy-revalidate") || control.Has("s-maxage") {
+		return
+	}
+	if stale, ok := control.Seconds("stale-while-revalidate"); ok {
+		entry.StaleWhileRevalidate = stale
+	}
+}
+
+// save stores entry under key matching request values of headers listed in Vary
+func (c *Cache) save(req *request.Request, entry *Entry) {
+	primary := primaryKey(req)
+	varyValue, _ := entry.Header.Get("Vary")
+	var varyNames []string
+	for _, name := range strings.Split(varyValue, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			varyNames = append(varyNames, name)
+		}
+	}
+	sort.Strings(varyNames)
+
+	c.mu.Lock()
+	c.vary[primary] = varyNames
+	c.mu.Unlock()
+	c.store.Set(variantKey(primary, varyNames, req), entry)
+}
+
+// entryKey returns key of stored response matching request
+func (c *Cache) entryKey(req *request.Request) string {
+	primary := primaryKey(req)
+	c.mu.Lock()
+	varyNames := c.vary[primary]
+	c.mu.Unlock()
+	return variantKey(primary, varyNames, req)
+}
+
+func (c *Cache) invalidate(req *request.Request) {
+	primary := primaryKey(req)
+	c.mu.Lock()
+	varyNames, exists := c.vary[primary]
+	delete(c.vary, primary)
+	c.mu.Unlock()
+	if exists {
+		c.store.Delete(variantKey(primary, varyNames, req))
+	}
+	c.store.Delete(primary)
+}
+
+func primaryKey(req *request.Request) string {
+	host, _ := req.Headers.Get("Host")
+	return host + " " + req.RequestLine.RequestTarget
+}
+
+func variantKey(primary string, varyNames []string, req *request.Request) string {
+	if len(varyNames) == 0 {
+		return primary
+	}
+	var key strings.Builder
+	key.WriteString(primary)
+	for _, name := range varyNames {
+		value, _ := req.Headers.Get(name)
+		key.WriteString("\x00" + name + "=" + value)
+	}
+	return key.String()
+}
+
+// writeEntry answers request with stored response, conditional requests are evaluated against it
+func (c *Cache) writeEntry(w *response.Writer, req *request.Request, entry *Entry, status string) {
+	if !c.writeHead(w, req, entry, status, int64(len(entry.Body))) {
+		return
+	}
+	w.WriteHeaders(nil)
+	w.WriteBody(entry.Body)
+}
+
+// writeHead writes status line of entry and prepares its headers, contentLength is -1 when body
+// length is unknown. Conditional requests are evaluated against entry, when they were answered
+// false is returned and nothing more can be written.
+func (c *Cache) writeHead(w *response.Writer, req *request.Request, entry *Entry, status string, contentLength int64) bool {
+	h := w.Header()
+	for name, value := range entry.Header {
+		h.Set(name, value)
+	}
+	h.Set("Age", strconv.FormatInt(int64(entry.age(c.now())/time.Second), 10))
+	h.Set(cacheStatusHeader, status)
+
+	if entry.StatusCode == response.OkStatusCode {
+		etag, _ := entry.Header.Get("ETag")
+		var lastModified time.Time
+		if value, exists := entry.Header.Get("Last-Modified"); exists {
+			lastModified, _ = headers.ParseTime(value)
+		}
+		switch response.EvaluatePreconditions(req, etag, lastModified) {
+		case response.NotModifiedStatusCode:
+			h.Del("Content-Type")
+			h.Del("Content-Length")
+			w.WriteStatusLine(response.NotModifiedStatusCode)
+			w.WriteHeaders(nil)
+			return false
+		case response.PreconditionFailedStatusCode:
+			for name := range entry.Header {
+				h.Del(name)
+			}
+			server.HandlerError{StatusCode: response.PreconditionFailedStatusCode, Message: "Precondition Failed"}.Write(w)
+			return false
+		}
+	}
+
+	w.WriteStatusLine(entry.StatusCode)
+	if contentLength >= 0 {
+		h.Set("Content-Length", strconv.FormatInt(contentLength, 10))
+	}
+	return true
+}
+
+func requestCacheControl(req *request.Request) headers.CacheControl {
+	value, _ := req.Headers.Get("Cache-Control")
+	control := headers.ParseCacheControl(value)
+	// Pragma: no-cache is HTTP/1.0 equivalent used when Cache-Control is missing
+	if pragma, exists := req.Headers.Get("Pragma"); exists && value == "" && strings.Contains(strings.ToLower(pragma), "no-cache") {
+		control["no-cache"] = ""
+	}
+	return control
+}
+
+func responseCacheControl(entry *Entry) headers.CacheControl {
+	value, _ := entry.Header.Get("Cache-Control")
+	return headers.ParseCacheControl(value)
+}
+
+func cloneRequest(req *request.Request) *request.Request {
+	clone := *req
+	clone.Headers = maps.Clone(req.Headers)
+	return &clone
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,459 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clock is settable time source shared with background revalidation
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestCache(handler func(w *response.Writer, req *request.Request), options Options) (*Cache, *clock) {
+	c := New(handler, options)
+	testClock := &clock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	c.now = testClock.Now
+	return c, testClock
+}
+
+// countingHandler answers with body containing call number and given response headers
+func countingHandler(calls *atomic.Int32, responseHeaders map[string]string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		body := []byte("response " + strconv.Itoa(int(calls.Add(1))))
+		w.WriteStatusLine(response.OkStatusCode)
+		h := response.GetDefaultHeaders(len(body))
+		for name, value := range responseHeaders {
+			h.Set(name, value)
+		}
+		w.WriteHeaders(h)
+		w.WriteBody(body)
+	}
+}
+
+func serve(t *testing.T, c *Cache, method, target string, requestHeaders map[string]string) (*http.Response, string) {
+	t.Helper()
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: target, HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+	}
+	req.Headers.Set("Host", "localhost")
+	for name, value := range requestHeaders {
+		req.Headers.Set(name, value)
+	}
+
+	var buf bytes.Buffer
+	w := response.NewWritter(&buf)
+	c.Serve(w, req)
+	require.NoError(t, w.Finish())
+
+	resp, err := http.ReadResponse(bufio.NewReader(&buf), &http.Request{Method: method})
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestCacheFreshness(t *testing.T) {
+	var calls atomic.Int32
+	c, clock := newTestCache(countingHandler(&calls, map[string]string{"Cache-Control": "max-age=60"}), Options{})
+
+	resp, body := serve(t, c, "GET", "/page", nil)
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "response 1", body)
+
+	// Test: Fresh response is served from cache
+	clock.Advance(30 * time.Second)
+	resp, body = serve(t, c, "GET", "/page", nil)
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "30", resp.Header.Get("Age"))
+	assert.Equal(t, "response 1", body)
+	assert.Equal(t, int64(len(body)), resp.ContentLength)
+
+	// Test: HEAD is answered from stored GET response
+	resp, body = serve(t, c, "HEAD", "/page", nil)
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	assert.Empty(t, body)
+
+	// Test: Request max-age limits accepted age
+	_, body = serve(t, c, "GET", "/page", map[string]string{"Cache-Control": "max-age=10"})
+	assert.Equal(t, "response 2", body)
+
+	// Test: Expired response is fetched again
+	clock.Advance(61 * time.Second)
+	resp, body = serve(t, c, "GET", "/page", nil)
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "response 3", body)
+
+	// Test: Different URL is separate entry
+	_, body = serve(t, c, "GET", "/page?other", nil)
+	assert.Equal(t, "response 4", body)
+
+	// Test: Unsafe method invalidates stored response
+	serve(t, c, "POST", "/page", nil)
+	_, body = serve(t, c, "GET", "/page", nil)
+	assert.Equal(t, "response 6", body)
+}
+
+func TestCacheExpires(t *testing.T) {
+	var calls atomic.Int32
+	date := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	c, clock := newTestCache(countingHandler(&calls, map[string]string{
+		"Date":    headers.FormatTime(date),
+		"Expires": headers.FormatTime(date.Add(time.Minute)),
+	}), Options{})
+
+	serve(t, c, "GET", "/", nil)
+	clock.Advance(59 * time.Second)
+	resp, _ := serve(t, c, "GET", "/", nil)
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+
+	clock.Advance(2 * time.Second)
+	resp, _ = serve(t, c, "GET", "/", nil)
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+
+	// Test: s-maxage overrides Expires
+	c, clock = newTestCache(countingHandler(&calls, map[string]string{
+		"Cache-Control": "s-maxage=300",
+		"Expires":       headers.FormatTime(date.Add(time.Minute)),
+	}), Options{})
+	serve(t, c, "GET", "/", nil)
+	clock.Advance(2 * time.Minute)
+	resp, _ = serve(t, c, "GET", "/", nil)
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+}
+
+func TestCacheInitialAge(t *testing.T) {
+	var calls atomic.Int32
+	date := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	c, clock := newTestCache(countingHandler(&calls, map[string]string{
+		"Date":          headers.FormatTime(date),
+		"Age":           "40",
+		"Cache-Control": "max-age=60",
+	}), Options{})
+	serve(t, c, "GET", "/page", nil)
+
+	// Test: Age received from upstream counts into age of stored response
+	clock.Advance(10 * time.Second)
+	resp, body := serve(t, c, "GET", "/page", nil)
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "50", resp.Header.Get("Age"))
+	assert.Equal(t, "response 1", body)
+
+	// Test: Response is stale once upstream age and time in cache exceed lifetime
+	clock.Advance(15 * time.Second)
+	resp, body = serve(t, c, "GET", "/page", nil)
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "response 2", body)
+
+	// Test: Apparent age from Date is used when it is larger than Age header
+	c, _ = newTestCache(countingHandler(&calls, map[string]string{
+		"Date":          headers.FormatTime(date.Add(-90 * time.Second)),
+		"Age":           "10",
+		"Cache-Control": "max-age=120",
+	}), Options{})
+	serve(t, c, "GET", "/page", nil)
+	resp, _ = serve(t, c, "GET", "/page", nil)
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "90", resp.Header.Get("Age"))
+}
+
+func TestCacheNotStored(t *testing.T) {
+	tests := []struct {
+		name            string
+		responseHeaders map[string]string
+		requestHeaders  map[string]string
+	}{
+		{name: "no-store", responseHeaders: map[string]string{"Cache-Control": "no-store, max-age=60"}},
+		{name: "private", responseHeaders: map[string]string{"Cache-Control": "private, max-age=60"}},
+		{name: "no freshness", responseHeaders: map[string]string{}},
+		{name: "vary all", responseHeaders: map[string]string{"Cache-Control": "max-age=60", "Vary": "*"}},
+		{name: "set cookie", responseHeaders: map[string]string{"Cache-Control": "max-age=60", "Set-Cookie": "id=1"}},
+		{name: "authorization", responseHeaders: map[string]string{"Cache-Control": "max-age=60"}, requestHeaders: map[string]string{"Authorization": "Basic dTpw"}},
+		{name: "request no-store", responseHeaders: map[string]string{"Cache-Control": "max-age=60"}, requestHeaders: map[string]string{"Cache-Control": "no-store"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			c, _ := newTestCache(countingHandler(&calls, tt.responseHeaders), Options{})
+			_, first := serve(t, c, "GET", "/", tt.requestHeaders)
+			_, second := serve(t, c, "GET", "/", tt.requestHeaders)
+			assert.Equal(t, "response 1", first)
+			assert.Equal(t, "response 2", second)
+		})
+	}
+
+	// Test: Authorized request can use response marked public
+	var calls atomic.Int32
+	c, _ := newTestCache(countingHandler(&calls, map[string]string{"Cache-Control": "public, max-age=60"}), Options{})
+	serve(t, c, "GET", "/", map[string]string{"Authorization": "Basic dTpw"})
+	resp, _ := serve(t, c, "GET", "/", map[string]string{"Authorization": "Basic dTpw"})
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+}
+
+func TestCacheVary(t *testing.T) {
+	var calls atomic.Int32
+	handler := func(w *response.Writer, req *request.Request) {
+		calls.Add(1)
+		language, _ := req.Headers.Get("Accept-Language")
+		body := []byte("language " + language)
+		w.WriteStatusLine(response.OkStatusCode)
+		h := response.GetDefaultHeaders(len(body))
+		h.Set("Cache-Control", "max-age=60")
+		h.Set("Vary", "Accept-Language")
+		w.WriteHeaders(h)
+		w.WriteBody(body)
+	}
+	c, _ := newTestCache(handler, Options{})
+
+	_, body := serve(t, c, "GET", "/", map[string]string{"Accept-Language": "en"})
+	assert.Equal(t, "language en", body)
+	_, body = serve(t, c, "GET", "/", map[string]string{"Accept-Language": "pl"})
+	assert.Equal(t, "language pl", body)
+
+	// Test: Each variant is served from cache
+	resp, body := serve(t, c, "GET", "/", map[string]string{"Accept-Language": "en"})
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "language en", body)
+	resp, body = serve(t, c, "GET", "/", map[string]string{"Accept-Language": "pl"})
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "language pl", body)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestCacheValidators(t *testing.T) {
+	var calls, notModified atomic.Int32
+	etag := `"v1"`
+	handler := func(w *response.Writer, req *request.Request) {
+		calls.Add(1)
+		if ifNoneMatch, _ := req.Headers.Get("If-None-Match"); ifNoneMatch == etag {
+			notModified.Add(1)
+			w.WriteStatusLine(response.NotModifiedStatusCode)
+			w.WriteHeaders(headers.Headers{"ETag": etag, "Cache-Control": "max-age=60"})
+			return
+		}
+		body := []byte("content")
+		w.WriteStatusLine(response.OkStatusCode)
+		h := response.GetDefaultHeaders(len(body))
+		h.Set("ETag", etag)
+		h.Set("Cache-Control", "no-cache")
+		w.WriteHeaders(h)
+		w.WriteBody(body)
+	}
+	c, _ := newTestCache(handler, Options{})
+
+	resp, body := serve(t, c, "GET", "/", nil)
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "content", body)
+
+	// Test: no-cache response is revalidated and 304 refreshes stored response
+	resp, body = serve(t, c, "GET", "/", nil)
+	assert.Equal(t, "REVALIDATED", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "content", body)
+	assert.Equal(t, "max-age=60", resp.Header.Get("Cache-Control"))
+	assert.Equal(t, int32(1), notModified.Load())
+
+	resp, _ = serve(t, c, "GET", "/", nil)
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+
+	// Test: Client conditional request is answered from cache
+	resp, body = serve(t, c, "GET", "/", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+	assert.Empty(t, body)
+
+	resp, _ = serve(t, c, "GET", "/", map[string]string{"If-Match": `"v0"`})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// Test: Range requests bypass cache
+	resp, _ = serve(t, c, "GET", "/", map[string]string{"Range": "bytes=0-1"})
+	assert.Equal(t, "BYPASS", resp.Header.Get("X-Cache"))
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	var calls atomic.Int32
+	c, clock := newTestCache(countingHandler(&calls, map[string]string{"Cache-Control": "max-age=10, stale-while-revalidate=30"}), Options{})
+
+	serve(t, c, "GET", "/", nil)
+	clock.Advance(20 * time.Second)
+
+	// Test: Stale response is served while it is revalidated in background
+	resp, body := serve(t, c, "GET", "/", nil)
+	assert.Equal(t, "STALE", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "response 1", body)
+	// Background fetch is over once it is not in flight anymore, revalidated entry is saved before that
+	assert.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return calls.Load() == 2 && len(c.inflight) == 0
+	}, time.Second, 5*time.Millisecond)
+	resp, body = serve(t, c, "GET", "/", nil)
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "response 2", body)
+
+	// Test: Response past stale window is fetched synchronously
+	clock.Advance(time.Minute)
+	resp, body = serve(t, c, "GET", "/", nil)
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "response 3", body)
+}
+
+func TestCacheCoalescing(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	handler := countingHandler(&calls, map[string]string{"Cache-Control": "max-age=60"})
+	c, _ := newTestCache(func(w *response.Writer, req *request.Request) {
+		<-release
+		handler(w, req)
+	}, Options{})
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 10)
+	for i := range bodies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, bodies[i] = serve(t, c, "GET", "/slow", nil)
+		}()
+	}
+	// Give requests time to join the first fetch
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls.Load())
+	for _, body := range bodies {
+		assert.Equal(t, "response 1", body)
+	}
+}
+
+func TestCacheChunkedResponse(t *testing.T) {
+	var calls atomic.Int32
+	c, _ := newTestCache(func(w *response.Writer, req *request.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteStatusLine(response.OkStatusCode)
+		body, err := w.Body()
+		require.NoError(t, err)
+		body.Write([]byte("first "))
+		body.Write([]byte("second"))
+		body.Close()
+	}, Options{})
+
+	serve(t, c, "GET", "/", nil)
+	resp, body := serve(t, c, "GET", "/", nil)
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "first second", body)
+	assert.Empty(t, resp.TransferEncoding)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestCacheStreamsMiss(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	c, _ := newTestCache(func(w *response.Writer, req *request.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.WriteStatusLine(response.OkStatusCode)
+		body, err := w.Body()
+		require.NoError(t, err)
+		body.Write([]byte("first "))
+		w.Flush()
+		<-release
+		body.Write([]byte("second"))
+		body.Close()
+	}, Options{})
+
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/stream", HttpVersion: "1.1"},
+		Headers:     headers.Headers{"host": "localhost"},
+	}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		defer serverConn.Close()
+		w := response.NewWritter(serverConn)
+		c.Serve(w, req)
+		w.Finish()
+	}()
+
+	// Test: First chunk reaches client while handler is still running
+	clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(clientConn), &http.Request{Method: "GET"})
+	require.NoError(t, err)
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+	first := make([]byte, len("first "))
+	_, err = io.ReadFull(resp.Body, first)
+	require.NoError(t, err)
+	assert.Equal(t, "first ", string(first))
+
+	close(release)
+	rest, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(rest))
+
+	// Test: Streamed response was stored as well
+	resp, body := serve(t, c, "GET", "/stream", nil)
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "first second", body)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestCacheMaxEntrySize(t *testing.T) {
+	var calls atomic.Int32
+	large := bytes.Repeat([]byte("x"), 100)
+	c, _ := newTestCache(func(w *response.Writer, req *request.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.WriteStatusLine(response.OkStatusCode)
+		if req.RequestLine.RequestTarget == "/chunked" {
+			body, _ := w.Body()
+			body.Write(large[:60])
+			body.Write(large[60:])
+			body.Close()
+			return
+		}
+		w.WriteHeaders(response.GetDefaultHeaders(len(large)))
+		w.WriteBody(large)
+	}, Options{MaxEntrySize: 50})
+
+	// Test: Responses over the limit are passed whole to client but not stored
+	for _, target := range []string{"/length", "/chunked"} {
+		_, body := serve(t, c, "GET", target, nil)
+		assert.Equal(t, string(large), body)
+		resp, body := serve(t, c, "GET", target, nil)
+		assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+		assert.Equal(t, string(large), body)
+	}
+	assert.Equal(t, int32(4), calls.Load())
+}
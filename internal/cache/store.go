@@ -0,0 +1,235 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store keeps cached responses. Implementations evict entries on their own to stay within size limit
+// and must be safe for concurrent use.
+type Store interface {
+	Get(key string) (*Entry, bool)
+	Set(key string, entry *Entry)
+	Delete(key string)
+}
+
+// lru tracks sizes of stored keys and returns keys which have to be evicted to stay within maxSize
+type lru struct {
+	maxSize int64
+	size    int64
+	order   *list.List
+	items   map[string]*list.Element
+}
+
+type lruItem struct {
+	key  string
+	size int64
+}
+
+func newLRU(maxSize int64) *lru {
+	return &lru{maxSize: maxSize, order: list.New(), items: map[string]*list.Element{}}
+}
+
+func (l *lru) touch(key string) bool {
+	element, exists := l.items[key]
+	if exists {
+		l.order.MoveToFront(element)
+	}
+	return exists
+}
+
+// add stores key as most recently used and returns evicted keys
+func (l *lru) add(key string, size int64) []string {
+	l.remove(key)
+	l.items[key] = l.order.PushFront(&lruItem{key: key, size: size})
+	l.size += size
+
+	var evicted []string
+	for l.size > l.maxSize && l.order.Len() > 0 {
+		oldest := l.order.Back().Value.(*lruItem)
+		l.remove(oldest.key)
+		evicted = append(evicted, oldest.key)
+	}
+	return evicted
+}
+
+func (l *lru) remove(key string) {
+	element, exists := l.items[key]
+	if !exists {
+		return
+	}
+	l.size -= element.Value.(*lruItem).size
+	l.order.Remove(element)
+	delete(l.items, key)
+}
+
+// MemoryStore keeps entries in memory evicting least recently used ones above maxSize bytes
+type MemoryStore struct {
+	mu      sync.Mutex
+	lru     *lru
+	entries map[string]*Entry
+}
+
+func NewMemoryStore(maxSize int64) *MemoryStore {
+	return &MemoryStore{lru: newLRU(maxSize), entries: map[string]*Entry{}}
+}
+
+func (s *MemoryStore) Get(key string) (*Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.lru.touch(key) {
+		return nil, false
+	}
+	return s.entries[key], true
+}
+
+func (s *MemoryStore) Set(key string, entry *Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = entry
+	for _, evicted := range s.lru.add(key, entry.size()) {
+		delete(s.entries, evicted)
+	}
+}
+
+func (s *MemoryStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lru.remove(key)
+	delete(s.entries, key)
+}
+
+// Size returns bytes taken by stored entries
+func (s *MemoryStore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.size
+}
+
+const diskEntryExtension = ".cache"
+
+// DiskStore keeps entries as files in directory evicting least recently used ones above maxSize bytes.
+// Entries left by previous run are picked up, oldest modified first to be evicted.
+type DiskStore struct {
+	dir string
+	mu  sync.Mutex
+	lru *lru
+}
+
+func NewDiskStore(dir string, maxSize int64) (*DiskStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	store := &DiskStore{dir: dir, lru: newLRU(maxSize)}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type existingFile struct {
+		name string
+		info os.FileInfo
+	}
+	var existing []existingFile
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), diskEntryExtension) {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		existing = append(existing, existingFile{name: strings.TrimSuffix(file.Name(), diskEntryExtension), info: info})
+	}
+	sort.Slice(existing, func(i, j int) bool { return existing[i].info.ModTime().Before(existing[j].info.ModTime()) })
+	for _, file := range existing {
+		store.evict(store.lru.add(file.name, file.info.Size()))
+	}
+	return store, nil
+}
+
+// fileName maps key to file name, keys contain characters which can't be used in paths
+func fileName(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func (s *DiskStore) path(name string) string {
+	return filepath.Join(s.dir, name+diskEntryExtension)
+}
+
+func (s *DiskStore) Get(key string) (*Entry, bool) {
+	name := fileName(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.lru.touch(name) {
+		return nil, false
+	}
+
+	file, err := os.Open(s.path(name))
+	if err != nil {
+		s.lru.remove(name)
+		return nil, false
+	}
+	defer file.Close()
+	var stored diskEntry
+	err = gob.NewDecoder(file).Decode(&stored)
+	// Hash collision or entry written by older version
+	if err != nil || stored.Key != key {
+		return nil, false
+	}
+	return stored.Entry, true
+}
+
+// diskEntry keeps key with entry so it can be verified on read
+type diskEntry struct {
+	Key   string
+	Entry *Entry
+}
+
+func (s *DiskStore) Set(key string, entry *Entry) {
+	name := fileName(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Written to temporary file first so readers never see partial entry
+	file, err := os.CreateTemp(s.dir, "tmp-*")
+	if err != nil {
+		return
+	}
+	err = gob.NewEncoder(file).Encode(diskEntry{Key: key, Entry: entry})
+	info, statErr := file.Stat()
+	closeErr := file.Close()
+	if err != nil || statErr != nil || closeErr != nil {
+		os.Remove(file.Name())
+		return
+	}
+	err = os.Rename(file.Name(), s.path(name))
+	if err != nil {
+		os.Remove(file.Name())
+		return
+	}
+	s.evict(s.lru.add(name, info.Size()))
+}
+
+func (s *DiskStore) Delete(key string) {
+	name := fileName(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lru.remove(name)
+	os.Remove(s.path(name))
+}
+
+func (s *DiskStore) evict(names []string) {
+	for _, name := range names {
+		os.Remove(s.path(name))
+	}
+}
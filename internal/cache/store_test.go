@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEntry(body string) *Entry {
+	return &Entry{
+		StatusCode: 200,
+		Header:     headers.Headers{"Content-Type": "text/plain"},
+		Body:       []byte(body),
+		Stored:     time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+		Lifetime:   time.Minute,
+	}
+}
+
+func TestMemoryStoreEviction(t *testing.T) {
+	entrySize := testEntry(strings.Repeat("a", 100)).size()
+	store := NewMemoryStore(3 * entrySize)
+
+	store.Set("a", testEntry(strings.Repeat("a", 100)))
+	store.Set("b", testEntry(strings.Repeat("b", 100)))
+	store.Set("c", testEntry(strings.Repeat("c", 100)))
+	assert.Equal(t, 3*entrySize, store.Size())
+
+	// Test: Least recently used entry is evicted
+	_, found := store.Get("a")
+	assert.True(t, found)
+	store.Set("d", testEntry(strings.Repeat("d", 100)))
+	_, found = store.Get("b")
+	assert.False(t, found)
+	for _, key := range []string{"a", "c", "d"} {
+		_, found = store.Get(key)
+		assert.True(t, found, key)
+	}
+
+	// Test: Replacing entry updates size
+	store.Set("a", testEntry("small"))
+	assert.Equal(t, 2*entrySize+testEntry("small").size(), store.Size())
+
+	// Test: Entry larger than limit is not kept
+	store.Set("huge", testEntry(strings.Repeat("h", 1000)))
+	_, found = store.Get("huge")
+	assert.False(t, found)
+
+	store.Delete("c")
+	_, found = store.Get("c")
+	assert.False(t, found)
+}
+
+func TestDiskStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewDiskStore(dir, 1<<20)
+	require.NoError(t, err)
+
+	store.Set("localhost /page", testEntry("content"))
+	entry, found := store.Get("localhost /page")
+	require.True(t, found)
+	assert.Equal(t, testEntry("content"), entry)
+
+	// Test: Entries survive reopening the store
+	reopened, err := NewDiskStore(dir, 1<<20)
+	require.NoError(t, err)
+	entry, found = reopened.Get("localhost /page")
+	require.True(t, found)
+	assert.Equal(t, "content", string(entry.Body))
+
+	reopened.Delete("localhost /page")
+	_, found = reopened.Get("localhost /page")
+	assert.False(t, found)
+
+	// Test: Files above size limit are evicted
+	small, err := NewDiskStore(t.TempDir(), 600)
+	require.NoError(t, err)
+	small.Set("a", testEntry(strings.Repeat("a", 300)))
+	small.Set("b", testEntry(strings.Repeat("b", 300)))
+	_, found = small.Get("a")
+	assert.False(t, found)
+	_, found = small.Get("b")
+	assert.True(t, found)
+}
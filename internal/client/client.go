@@ -0,0 +1,372 @@
+// Package client implements HTTP/1.1 client with keep-alive connection pooling,
+// used to talk to upstream servers without net/http
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/response"
+)
+
+const (
+	defaultDialTimeout         = 30 * time.Second
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultMaxIdleConnsPerHost = 2
+	defaultMaxRedirects        = 10
+)
+
+// Redirect responses body up to this size is read so connection can be reused
+const maxDrainSize = 4096
+
+// Client sends requests reusing idle connections per host. Zero value is ready to use,
+// fields must not be changed once client is used.
+type Client struct {
+	// Timeout limits whole exchange including redirects and reading body, no limit when 0
+	Timeout time.Duration
+	// DialTimeout limits connecting (and TLS handshake), 30 seconds when 0
+	DialTimeout time.Duration
+	// ResponseHeaderTimeout limits waiting for response headers after request was sent, no limit when 0
+	ResponseHeaderTimeout time.Duration
+	// IdleConnTimeout is how long unused connection is kept in pool, 90 seconds when 0
+	IdleConnTimeout time.Duration
+	// MaxIdleConnsPerHost limits pooled connections per host, 2 when 0
+	MaxIdleConnsPerHost int
+	// MaxRedirects is number of redirects followed, 10 when 0. Negative value disables
+	// following and redirect response is returned.
+	MaxRedirects int
+	// TLSConfig for https urls, default configuration when nil
+	TLSConfig *tls.Config
+
+	mu   sync.Mutex
+	idle map[string][]*idleConn
+}
+
+type idleConn struct {
+	conn  net.Conn
+	since time.Time
+}
+
+// Get sends GET request to url
+func (c *Client) Get(rawURL string) (*Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do sends request following redirects. Response body has to be closed.
+func (c *Client) Do(req *Request) (*Response, error) {
+	var deadline time.Time
+	if c.Timeout > 0 {
+		deadline = time.Now().Add(c.Timeout)
+	}
+
+	redirects := 0
+	for {
+		resp, err := c.send(req, deadline)
+		if err != nil {
+			return nil, err
+		}
+		next, err := c.redirectRequest(req, resp, redirects)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if next == nil {
+			return resp, nil
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrainSize))
+		resp.Body.Close()
+		redirects++
+		req = next
+	}
+}
+
+// send performs single exchange. Request is sent again on new connection when pooled one
+// was closed by server before it answered anything.
+func (c *Client) send(req *Request, deadline time.Time) (*Response, error) {
+	if req.URL == nil || req.URL.Host == "" {
+		return nil, fmt.Errorf("request url must be absolute")
+	}
+	key := poolKey(req.URL)
+	for {
+		conn, reused, err := c.getConn(req.URL, key, deadline)
+		if err != nil {
+			return nil, err
+		}
+
+		conn.SetDeadline(deadline)
+		if c.ResponseHeaderTimeout > 0 {
+			headerDeadline := time.Now().Add(c.ResponseHeaderTimeout)
+			if deadline.IsZero() || headerDeadline.Before(deadline) {
+				conn.SetReadDeadline(headerDeadline)
+			}
+		}
+
+		reader := response.NewReader(conn)
+		var parsed *response.Response
+		writeErr := req.write(conn)
+		err = writeErr
+		if err == nil {
+			parsed, err = c.readHead(reader, req.Method)
+		}
+		if err != nil {
+			conn.Close()
+			if reused && req.replayable() && reader.Received() == 0 && (writeErr != nil || isIdempotent(req.Method)) {
+				continue
+			}
+			return nil, err
+		}
+		conn.SetReadDeadline(deadline)
+
+		resp := &Response{
+			StatusLine:    parsed.StatusLine,
+			Headers:       parsed.Headers,
+			ContentLength: parsed.ContentLength,
+			Request:       req,
+		}
+		keepAlive := canKeepAlive(req, resp)
+		body := &bodyReader{reader: reader, parsed: parsed, resp: resp, release: func(reusable bool) {
+			if reusable && keepAlive {
+				c.putConn(key, conn)
+				return
+			}
+			conn.Close()
+		}}
+		resp.Body = body
+		if parsed.ParsingState == response.ParsingDone && len(parsed.Body) == 0 {
+			// Nothing more to read, connection goes back to the pool right away
+			body.finish(true)
+		}
+		return resp, nil
+	}
+}
+
+// readHead reads response head skipping interim 1xx responses, except 101 which ends HTTP exchange
+func (c *Client) readHead(reader *response.Reader, method string) (*response.Response, error) {
+	for {
+		parsed, err := reader.ReadHead(method)
+		if err != nil {
+			return nil, err
+		}
+		statusCode := parsed.StatusLine.StatusCode
+		if statusCode >= 200 || statusCode == response.SwitchingProtocolsStatusCode {
+			return parsed, nil
+		}
+	}
+}
+
+// canKeepAlive reports whether connection may carry next request after this exchange
+func canKeepAlive(req *Request, resp *Response) bool {
+	if resp.StatusLine.HttpVersion != "1.1" {
+		return false
+	}
+	if resp.StatusLine.StatusCode == response.SwitchingProtocolsStatusCode {
+		return false
+	}
+	requestConnection, _ := req.Header.Get("Connection")
+	responseConnection, _ := resp.Headers.Get("Connection")
+	return !hasCloseOption(requestConnection) && !hasCloseOption(responseConnection)
+}
+
+// hasCloseOption reports whether Connection header value contains close option
+func hasCloseOption(connection string) bool {
+	for _, option := range strings.Split(connection, ",") {
+		if strings.EqualFold(strings.TrimSpace(option), "close") {
+			return true
+		}
+	}
+	return false
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// redirectRequest returns request following redirect response, nil when response is not followed
+func (c *Client) redirectRequest(req *Request, resp *Response, redirects int) (*Request, error) {
+	statusCode := resp.StatusLine.StatusCode
+	switch statusCode {
+	case 301, 302, 303, 307, 308:
+	default:
+		return nil, nil
+	}
+	location, exists := resp.Headers.Get("Location")
+	if !exists || c.MaxRedirects < 0 {
+		return nil, nil
+	}
+	maxRedirects := c.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+	if redirects >= maxRedirects {
+		return nil, fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+
+	target, err := req.URL.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect location %q: %v", location, err)
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported redirect location %q", location)
+	}
+
+	next := &Request{
+		Method:        req.Method,
+		URL:           target,
+		Header:        maps.Clone(req.Header),
+		Body:          req.Body,
+		BodyReader:    req.BodyReader,
+		ContentLength: req.ContentLength,
+	}
+	// 307 and 308 keep method and body, other redirects continue with GET
+	if statusCode != 307 && statusCode != 308 && req.Method != "HEAD" && (req.Method != "GET" || statusCode == 303) {
+		next.Method = "GET"
+		next.Body = nil
+		next.BodyReader = nil
+		next.ContentLength = 0
+		next.Header.Del("Content-Type")
+	}
+	// Streamed body was already consumed, redirect response is returned to caller
+	if !next.replayable() {
+		return nil, nil
+	}
+	next.Header.Del("Host")
+	// Credentials are not sent to other hosts
+	if target.Host != req.URL.Host {
+		next.Header.Del("Authorization")
+		next.Header.Del("Cookie")
+	}
+	return next, nil
+}
+
+func poolKey(u *url.URL) string {
+	return u.Scheme + "://" + address(u)
+}
+
+// address returns host:port of url with default port of scheme
+func address(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// getConn returns pooled connection to url host or dials new one
+func (c *Client) getConn(u *url.URL, key string, deadline time.Time) (net.Conn, bool, error) {
+	idleTimeout := c.IdleConnTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleConnTimeout
+	}
+
+	c.mu.Lock()
+	for len(c.idle[key]) > 0 {
+		conns := c.idle[key]
+		pooled := conns[len(conns)-1]
+		c.idle[key] = conns[:len(conns)-1]
+		if time.Since(pooled.since) < idleTimeout {
+			c.mu.Unlock()
+			return pooled.conn, true, nil
+		}
+		pooled.conn.Close()
+	}
+	c.mu.Unlock()
+
+	conn, err := c.dial(u, deadline)
+	return conn, false, err
+}
+
+func (c *Client) dial(u *url.URL, deadline time.Time) (net.Conn, error) {
+	dialTimeout := c.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = defaultDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	if !deadline.IsZero() {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithDeadline(ctx, deadline)
+		defer cancelDeadline()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address(u))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" {
+		return conn, nil
+	}
+
+	config := &tls.Config{}
+	if c.TLSConfig != nil {
+		config = c.TLSConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = u.Hostname()
+	}
+	tlsConn := tls.Client(conn, config)
+	err = tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func (c *Client) putConn(key string, conn net.Conn) {
+	maxIdle := c.MaxIdleConnsPerHost
+	if maxIdle <= 0 {
+		maxIdle = defaultMaxIdleConnsPerHost
+	}
+	// Deadline of finished exchange must not affect next one
+	conn.SetDeadline(time.Time{})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.idle == nil {
+		c.idle = map[string][]*idleConn{}
+	}
+	if len(c.idle[key]) >= maxIdle {
+		conn.Close()
+		return
+	}
+	c.idle[key] = append(c.idle[key], &idleConn{conn: conn, since: time.Now()})
+}
+
+// CloseIdleConnections closes pooled connections, connections in use are not affected
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, conns := range c.idle {
+		for _, pooled := range conns {
+			pooled.conn.Close()
+		}
+		delete(c.idle, key)
+	}
+}
+
+// IsTimeout reports whether error was caused by exceeded timeout
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+}
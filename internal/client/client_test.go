@@ -0,0 +1,370 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startRawServer answers every connection with handle, used for responses net/http would not produce
+func startRawServer(t *testing.T, handle func(conn net.Conn, reader *bufio.Reader)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn, bufio.NewReader(conn))
+			}()
+		}
+	}()
+	return "http://" + listener.Addr().String()
+}
+
+// readRequestHead reads request line and headers sent by client
+func readRequestHead(t *testing.T, reader *bufio.Reader) string {
+	var head strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return head.String()
+		}
+		head.WriteString(line)
+		if line == "\r\n" {
+			return head.String()
+		}
+	}
+}
+
+func readBody(t *testing.T, resp *Response) string {
+	t.Helper()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	return string(body)
+}
+
+func TestClientKeepAlive(t *testing.T) {
+	var connections atomic.Int32
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Method", r.Method)
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte("hello " + string(body)))
+	}))
+	upstream.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	upstream.Start()
+	defer upstream.Close()
+
+	c := &Client{}
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get(upstream.URL + "/path?query=1")
+		require.NoError(t, err)
+		assert.Equal(t, response.OkStatusCode, resp.StatusLine.StatusCode)
+		assert.Equal(t, "OK", resp.StatusLine.ReasonPhrase)
+		assert.Equal(t, int64(6), resp.ContentLength)
+		assert.Equal(t, "hello ", readBody(t, resp))
+	}
+
+	// Test: Request body is sent with Content-Length
+	req, err := NewRequest("POST", upstream.URL+"/", []byte("world"))
+	require.NoError(t, err)
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", readBody(t, resp))
+	headerValue, _ := resp.Headers.Get("X-Method")
+	assert.Equal(t, "POST", headerValue)
+
+	// Test: Connections are reused
+	assert.Equal(t, int32(1), connections.Load())
+
+	// Test: Connection: close is honored
+	req, err = NewRequest("GET", upstream.URL+"/", nil)
+	require.NoError(t, err)
+	req.Header.Set("Connection", "close")
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	readBody(t, resp)
+	resp, err = c.Get(upstream.URL + "/")
+	require.NoError(t, err)
+	readBody(t, resp)
+	assert.Equal(t, int32(2), connections.Load())
+
+	// Test: Body not read to the end closes connection
+	upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 100000)))
+	})
+	resp, err = c.Get(upstream.URL + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	resp, err = c.Get(upstream.URL + "/")
+	require.NoError(t, err)
+	assert.Len(t, readBody(t, resp), 100000)
+	assert.Equal(t, int32(3), connections.Load())
+}
+
+func TestClientResponseFraming(t *testing.T) {
+	responses := map[string]string{
+		"/chunked": "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n" +
+			"5;ext=1\r\nhello\r\n6\r\n world\r\n0\r\nX-Checksum: abc\r\nContent-Length: 1\r\n\r\n",
+		"/close":   "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\nuntil the end",
+		"/interim": "HTTP/1.1 103 Early Hints\r\nLink: </style.css>\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\nbody",
+		"/head":    "HTTP/1.1 200 OK\r\nContent-Length: 1000\r\n\r\n",
+		"/empty":   "HTTP/1.1 204 No Content\r\n\r\n",
+		"/invalid": "HTTP/1.1 2000 OK\r\n\r\n",
+		"/short":   "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort",
+	}
+	url := startRawServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		head := readRequestHead(t, reader)
+		target := strings.Fields(head)[1]
+		conn.Write([]byte(responses[target]))
+	})
+	c := &Client{}
+
+	resp, err := c.Get(url + "/chunked")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	assert.Equal(t, "hello world", readBody(t, resp))
+	checksum, _ := resp.Trailers.Get("X-Checksum")
+	assert.Equal(t, "abc", checksum)
+	// Test: Forbidden trailer fields are dropped
+	_, exists := resp.Trailers.Get("Content-Length")
+	assert.False(t, exists)
+
+	resp, err = c.Get(url + "/close")
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", readBody(t, resp))
+
+	// Test: Interim responses are skipped
+	resp, err = c.Get(url + "/interim")
+	require.NoError(t, err)
+	assert.Equal(t, response.OkStatusCode, resp.StatusLine.StatusCode)
+	assert.Equal(t, "body", readBody(t, resp))
+
+	req, err := NewRequest("HEAD", url+"/head", nil)
+	require.NoError(t, err)
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), resp.ContentLength)
+	assert.Empty(t, readBody(t, resp))
+
+	resp, err = c.Get(url + "/empty")
+	require.NoError(t, err)
+	assert.Equal(t, response.NoContentStatusCode, resp.StatusLine.StatusCode)
+	assert.Empty(t, readBody(t, resp))
+
+	_, err = c.Get(url + "/invalid")
+	assert.Error(t, err)
+
+	// Test: Body shorter than Content-Length
+	resp, err = c.Get(url + "/short")
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestClientRetriesClosedPooledConnection(t *testing.T) {
+	var connections atomic.Int32
+	url := startRawServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		connections.Add(1)
+		readRequestHead(t, reader)
+		// Connection is closed right after response without telling client
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+	})
+	c := &Client{}
+
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get(url + "/")
+		require.NoError(t, err)
+		assert.Equal(t, "ok", readBody(t, resp))
+		// Let server close the connection
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int32(3), connections.Load())
+}
+
+func TestClientRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/form", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/result", http.StatusFound)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/result", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/result", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte(r.Method + " " + string(body) + " " + r.Header.Get("Authorization")))
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusMovedPermanently)
+	})
+	upstream := httptest.NewServer(mux)
+	defer upstream.Close()
+	c := &Client{}
+
+	// Test: 302 after POST continues with GET
+	req, err := NewRequest("POST", upstream.URL+"/form", []byte("data"))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, "GET  Bearer token", readBody(t, resp))
+	assert.Equal(t, "/result", resp.Request.URL.Path)
+
+	// Test: 307 keeps method and body
+	req, err = NewRequest("POST", upstream.URL+"/moved", []byte("data"))
+	require.NoError(t, err)
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, "POST data ", readBody(t, resp))
+
+	_, err = c.Get(upstream.URL + "/loop")
+	assert.ErrorContains(t, err, "stopped after 10 redirects")
+
+	// Test: Following disabled
+	c = &Client{MaxRedirects: -1}
+	resp, err = c.Get(upstream.URL + "/loop")
+	require.NoError(t, err)
+	readBody(t, resp)
+	assert.Equal(t, response.StatusCode(http.StatusMovedPermanently), resp.StatusLine.StatusCode)
+}
+
+func TestClientTimeouts(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow-body" {
+			w.Write([]byte("start"))
+			w.(http.Flusher).Flush()
+		}
+		<-release
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	c := &Client{ResponseHeaderTimeout: 50 * time.Millisecond}
+	_, err := c.Get(upstream.URL + "/slow-headers")
+	require.Error(t, err)
+	assert.True(t, IsTimeout(err))
+
+	// Test: Timeout covers reading body
+	c = &Client{Timeout: 100 * time.Millisecond}
+	resp, err := c.Get(upstream.URL + "/slow-body")
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	require.Error(t, err)
+	assert.True(t, IsTimeout(err))
+	resp.Body.Close()
+
+	c = &Client{DialTimeout: time.Second}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedURL := "http://" + listener.Addr().String()
+	listener.Close()
+	_, err = c.Get(closedURL)
+	assert.Error(t, err)
+}
+
+func TestNewRequest(t *testing.T) {
+	_, err := NewRequest("GET", "127.0.0.1:8080", nil)
+	assert.Error(t, err)
+	_, err = NewRequest("GET", "ftp://example.com", nil)
+	assert.Error(t, err)
+
+	req, err := NewRequest("", "http://example.com/a?b=c", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "GET", req.Method)
+
+	var out strings.Builder
+	req.Header.Set("Accept", "*/*")
+	require.NoError(t, req.write(&out))
+	assert.Equal(t, "GET /a?b=c HTTP/1.1\r\nHost: example.com\r\nAccept: */*\r\n\r\n", out.String())
+
+	// Test: Header values can't inject lines
+	req.Header.Set("X-Bad", "a\r\nInjected: 1")
+	assert.Error(t, req.write(&out))
+}
+
+func TestClientStreamingBody(t *testing.T) {
+	firstPart := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved" {
+			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			return
+		}
+		buf := make([]byte, 6)
+		n, _ := io.ReadFull(r.Body, buf)
+		firstPart <- string(buf[:n])
+		rest, _ := io.ReadAll(r.Body)
+		w.Write([]byte(fmt.Sprintf("%v %d %s", r.TransferEncoding, r.ContentLength, string(buf[:n])+string(rest))))
+	}))
+	defer upstream.Close()
+	c := &Client{}
+
+	// Test: Body with known length is sent with Content-Length
+	req, err := NewRequestReader("POST", upstream.URL, strings.NewReader("hello world"), 11)
+	require.NoError(t, err)
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	<-firstPart
+	assert.Equal(t, "[] 11 hello world", readBody(t, resp))
+
+	// Test: Body of unknown length is sent chunked and reaches upstream before it is complete
+	bodyReader, bodyWriter := io.Pipe()
+	req, err = NewRequestReader("PUT", upstream.URL, bodyReader, -1)
+	require.NoError(t, err)
+	result := make(chan string, 1)
+	go func() {
+		resp, err := c.Do(req)
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		result <- string(body)
+	}()
+	bodyWriter.Write([]byte("hello "))
+	select {
+	case part := <-firstPart:
+		assert.Equal(t, "hello ", part)
+	case <-time.After(5 * time.Second):
+		t.Fatal("first part of body didn't reach upstream")
+	}
+	bodyWriter.Write([]byte("world"))
+	bodyWriter.Close()
+	assert.Equal(t, "[chunked] -1 hello world", <-result)
+
+	// Test: Body shorter than ContentLength
+	req, err = NewRequestReader("POST", upstream.URL, strings.NewReader("short"), 10)
+	require.NoError(t, err)
+	_, err = c.Do(req)
+	assert.ErrorContains(t, err, "shorter than Content-Length")
+
+	// Test: Redirect which would send streamed body again is returned
+	req, err = NewRequestReader("POST", upstream.URL+"/moved", strings.NewReader("data"), 4)
+	require.NoError(t, err)
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	readBody(t, resp)
+	assert.Equal(t, response.StatusCode(http.StatusTemporaryRedirect), resp.StatusLine.StatusCode)
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+)
+
+// Request is request sent by Client. Body is kept in memory so request can be sent
+// again on redirect or when pooled connection turns out to be closed.
+// BodyReader streams body instead, such request is sent only once: it is not retried and
+// redirects which would send body again are returned instead of followed.
+type Request struct {
+	Method string
+	URL    *url.URL
+	// Header holds request headers, Host header overrides host taken from URL.
+	// Content-Length and Transfer-Encoding are set by client.
+	Header headers.Headers
+	Body   []byte
+	// BodyReader is read to the end while request is written, Body is ignored when it is set
+	BodyReader io.Reader
+	// ContentLength is number of bytes BodyReader produces, body is sent chunked when it is 0 or negative
+	ContentLength int64
+}
+
+// NewRequestReader creates request streaming body from reader, contentLength -1 means unknown length
+func NewRequestReader(method, rawURL string, body io.Reader, contentLength int64) (*Request, error) {
+	req, err := NewRequest(method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.BodyReader = body
+	req.ContentLength = contentLength
+	return req, nil
+}
+
+// replayable reports whether request can be sent again, streamed body is consumed by first attempt
+func (req *Request) replayable() bool {
+	return req.BodyReader == nil
+}
+
+// NewRequest creates request for absolute http or https url
+func NewRequest(method, rawURL string, body []byte) (*Request, error) {
+	requestURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %v", err)
+	}
+	if requestURL.Scheme != "http" && requestURL.Scheme != "https" || requestURL.Host == "" {
+		return nil, fmt.Errorf("url must be absolute http or https url: %s", rawURL)
+	}
+	if method == "" {
+		method = "GET"
+	}
+	return &Request{
+		Method: method,
+		URL:    requestURL,
+		Header: headers.NewHeaders(),
+		Body:   body,
+	}, nil
+}
+
+// Methods which define meaning of request body, Content-Length is sent for them even when body is empty
+var methodsWithBody = []string{"POST", "PUT", "PATCH"}
+
+// write sends request line, headers and body in HTTP/1.1 format
+func (req *Request) write(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	target := req.URL.RequestURI()
+	fmt.Fprintf(out, "%s %s HTTP/1.1\r\n", req.Method, target)
+
+	host, exists := req.Header.Get("Host")
+	if !exists {
+		host = req.URL.Host
+	}
+	fmt.Fprintf(out, "Host: %s\r\n", host)
+
+	for name, value := range req.Header {
+		if strings.EqualFold(name, "Host") || strings.EqualFold(name, "Content-Length") || strings.EqualFold(name, "Transfer-Encoding") {
+			continue
+		}
+		if strings.ContainsAny(name+value, "\r\n") {
+			return fmt.Errorf("invalid header %s: contains line break", name)
+		}
+		fmt.Fprintf(out, "%s: %s\r\n", name, value)
+	}
+	if req.BodyReader != nil {
+		return req.writeBodyReader(out)
+	}
+	if len(req.Body) > 0 || containsFold(methodsWithBody, req.Method) {
+		fmt.Fprintf(out, "Content-Length: %s\r\n", strconv.Itoa(len(req.Body)))
+	}
+	out.WriteString("\r\n")
+	out.Write(req.Body)
+	return out.Flush()
+}
+
+// Size of chunks streamed body of unknown length is sent in
+const chunkSize = 32 << 10
+
+// writeBodyReader ends header section with body framing and streams BodyReader to out
+func (req *Request) writeBodyReader(out *bufio.Writer) error {
+	if req.ContentLength > 0 {
+		fmt.Fprintf(out, "Content-Length: %s\r\n\r\n", strconv.FormatInt(req.ContentLength, 10))
+		n, err := io.CopyN(out, req.BodyReader, req.ContentLength)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("request body shorter than Content-Length: %d of %d bytes", n, req.ContentLength)
+			}
+			return err
+		}
+		return out.Flush()
+	}
+
+	out.WriteString("Transfer-Encoding: chunked\r\n\r\n")
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := req.BodyReader.Read(buf)
+		if n > 0 {
+			out.WriteString(strconv.FormatInt(int64(n), 16) + "\r\n")
+			out.Write(buf[:n])
+			out.WriteString("\r\n")
+			// Chunk reaches upstream right away, body may be produced slowly
+			if flushErr := out.Flush(); flushErr != nil {
+				return flushErr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	out.WriteString("0\r\n\r\n")
+	return out.Flush()
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
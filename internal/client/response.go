@@ -0,0 +1,67 @@
+package client
+
+import (
+	"errors"
+	"io"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+	"github.com/MichalGul/http_server_go/internal/response"
+)
+
+// Response is upstream response. Body is streamed from connection as it is read,
+// it has to be read to the end or closed so connection can be reused or released.
+type Response struct {
+	StatusLine response.StatusLine
+	Headers    headers.Headers
+	Body       io.ReadCloser
+	// ContentLength is length of the body, -1 when unknown (chunked or close-delimited)
+	ContentLength int64
+	// Trailers holds fields sent after chunked body, filled once Body is read to the end
+	Trailers headers.Headers
+	// Request which response answers, last one when redirects were followed
+	Request *Request
+}
+
+// bodyReader streams response body from connection, release is called once with information
+// whether connection can be used for next request
+type bodyReader struct {
+	reader   *response.Reader
+	parsed   *response.Response
+	resp     *Response
+	release  func(reusable bool)
+	err      error
+	released bool
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.reader.Read(p)
+	if errors.Is(err, io.EOF) {
+		b.resp.Trailers = b.parsed.Trailers
+		b.finish(true)
+	} else if err != nil {
+		b.err = err
+		b.finish(false)
+	}
+	return n, err
+}
+
+// Close releases connection, it is reused only when body was read to the end
+func (b *bodyReader) Close() error {
+	b.finish(b.parsed.ParsingState == response.ParsingDone && len(b.parsed.Body) == 0 && b.err == nil)
+	if b.err == nil {
+		b.err = errors.New("read on closed response body")
+	}
+	return nil
+}
+
+func (b *bodyReader) finish(completed bool) {
+	if b.released {
+		return
+	}
+	b.released = true
+	// Data past the response means connection is out of sync
+	b.release(completed && b.reader.Buffered() == 0 && !b.parsed.CloseDelimited())
+}
@@ -0,0 +1,82 @@
+package headers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheControl holds Cache-Control directives. Names are lowercased,
+// directives without argument have empty value.
+type CacheControl map[string]string
+
+// ParseCacheControl parses Cache-Control value, e.g. `max-age=60, no-cache="Set-Cookie"`
+func ParseCacheControl(value string) CacheControl {
+	directives := CacheControl{}
+	for _, directive := range splitQuoted(value, ',') {
+		name, argument, _ := strings.Cut(directive, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		argument = strings.TrimSpace(argument)
+		if unquoted, err := strconv.Unquote(argument); err == nil && strings.HasPrefix(argument, `"`) {
+			argument = unquoted
+		}
+		// First occurrence wins, duplicates are invalid anyway
+		if _, exists := directives[name]; !exists {
+			directives[name] = argument
+		}
+	}
+	return directives
+}
+
+// Has reports whether directive is present
+func (cc CacheControl) Has(name string) bool {
+	_, exists := cc[name]
+	return exists
+}
+
+// Largest delta-seconds value, bigger values are clamped to it (RFC 9111 section 1.2.2)
+const maxDeltaSeconds = 1 << 31
+
+// Seconds returns delta-seconds argument of directive (max-age, s-maxage...), false when missing or invalid
+func (cc CacheControl) Seconds(name string) (time.Duration, bool) {
+	argument, exists := cc[name]
+	if !exists {
+		return 0, false
+	}
+	return ParseDeltaSeconds(argument)
+}
+
+// ParseDeltaSeconds parses delta-seconds value (e.g. Age header), false when it is invalid
+func ParseDeltaSeconds(argument string) (time.Duration, bool) {
+	seconds, err := strconv.ParseInt(argument, 10, 64)
+	if errors.Is(err, strconv.ErrRange) && !strings.HasPrefix(argument, "-") {
+		seconds, err = maxDeltaSeconds, nil
+	}
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(min(seconds, maxDeltaSeconds)) * time.Second, true
+}
+
+// splitQuoted splits value by separator which is not inside quoted string
+func splitQuoted(value string, separator byte) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && quoted:
+			i++
+		case value[i] == '"':
+			quoted = !quoted
+		case value[i] == separator && !quoted:
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, value[start:])
+}
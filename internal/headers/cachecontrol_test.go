@@ -0,0 +1,40 @@
+package headers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	cc := ParseCacheControl(`Max-Age=60, no-cache="Set-Cookie, X-Id", private, s-maxage=abc, max-age=5`)
+	assert.True(t, cc.Has("private"))
+	assert.Equal(t, "Set-Cookie, X-Id", cc["no-cache"])
+
+	maxAge, ok := cc.Seconds("max-age")
+	assert.True(t, ok)
+	assert.Equal(t, 60*time.Second, maxAge)
+
+	// Test: Invalid delta-seconds
+	_, ok = cc.Seconds("s-maxage")
+	assert.False(t, ok)
+
+	// Test: Missing directive
+	_, ok = cc.Seconds("stale-while-revalidate")
+	assert.False(t, ok)
+	assert.False(t, cc.Has("no-store"))
+
+	assert.Empty(t, ParseCacheControl(""))
+
+	// Test: Huge values are clamped instead of overflowing Duration
+	cc = ParseCacheControl("max-age=9999999999999, s-maxage=99999999999999999999999, stale-while-revalidate=-99999999999999999999999")
+	maxAge, ok = cc.Seconds("max-age")
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(1<<31)*time.Second, maxAge)
+	sMaxAge, ok := cc.Seconds("s-maxage")
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(1<<31)*time.Second, sMaxAge)
+	_, ok = cc.Seconds("stale-while-revalidate")
+	assert.False(t, ok)
+}
@@ -0,0 +1,36 @@
+package headers
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+)
+
+// ParseChunkSize parses chunk-size line of chunked body without CRLF (RFC 9112 section 7.1),
+// chunk extensions after ; are ignored. Request and response parsers share it.
+func ParseChunkSize(line []byte) (int64, error) {
+	sizePart, _, _ := bytes.Cut(line, []byte(";"))
+	sizePart = bytes.TrimRight(sizePart, " \t")
+	if len(sizePart) == 0 {
+		return 0, fmt.Errorf("malformed chunk size: empty")
+	}
+	var size int64
+	for _, c := range sizePart {
+		var digit byte
+		switch {
+		case c >= '0' && c <= '9':
+			digit = c - '0'
+		case c >= 'a' && c <= 'f':
+			digit = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			digit = c - 'A' + 10
+		default:
+			return 0, fmt.Errorf("malformed chunk size: %q", sizePart)
+		}
+		if size > (math.MaxInt64-int64(digit))/16 {
+			return 0, fmt.Errorf("malformed chunk size: %q out of range", sizePart)
+		}
+		size = size*16 + int64(digit)
+	}
+	return size, nil
+}
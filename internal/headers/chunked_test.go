@@ -0,0 +1,23 @@
+package headers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChunkSize(t *testing.T) {
+	// Test: Hex size in either case, extensions and trailing whitespace ignored
+	for line, expected := range map[string]int64{"0": 0, "1a": 26, "FF": 255, "10;name=value": 16, "5 \t;ext": 5, "7fffffffffffffff": 1<<63 - 1} {
+		size, err := ParseChunkSize([]byte(line))
+		require.NoError(t, err, line)
+		assert.Equal(t, expected, size, line)
+	}
+
+	// Test: Malformed and overflowing sizes
+	for _, line := range []string{"", ";ext", "zz", "-1", "+5", "0x10", " 5", "8000000000000000"} {
+		_, err := ParseChunkSize([]byte(line))
+		assert.Error(t, err, line)
+	}
+}
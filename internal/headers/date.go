@@ -0,0 +1,35 @@
+package headers
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeFormat is preferred HTTP-date format (IMF-fixdate), always in GMT
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// Obsolete HTTP-date formats recipients still have to accept
+var obsoleteTimeFormats = []string{
+	"Monday, 02-Jan-06 15:04:05 GMT", // RFC 850
+	"Mon Jan _2 15:04:05 2006",       // ANSI C asctime()
+}
+
+// FormatTime formats time as HTTP-date
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+// ParseTime parses HTTP-date in any of three formats allowed by RFC 9110
+func ParseTime(value string) (time.Time, error) {
+	t, err := time.Parse(TimeFormat, value)
+	if err == nil {
+		return t, nil
+	}
+	for _, layout := range obsoleteTimeFormats {
+		t, err = time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("malformed HTTP-date: %s", value)
+}
@@ -0,0 +1,56 @@
+package headers
+
+import (
+	"strings"
+)
+
+// ParseETagList splits If-Match/If-None-Match value into list of entity tags.
+// Wildcard "*" is returned as single element list, malformed tags are skipped.
+func ParseETagList(value string) []string {
+	value = strings.TrimSpace(value)
+	if value == "*" {
+		return []string{"*"}
+	}
+
+	var etags []string
+	for len(value) > 0 {
+		value = strings.TrimLeft(value, " \t,")
+		if value == "" {
+			break
+		}
+
+		start := 0
+		if strings.HasPrefix(value, "W/") {
+			start = 2
+		}
+		if len(value) <= start || value[start] != '"' {
+			// not an entity tag, skip to next element
+			_, rest, _ := strings.Cut(value, ",")
+			value = rest
+			continue
+		}
+		end := strings.IndexByte(value[start+1:], '"')
+		if end == -1 {
+			break
+		}
+		end += start + 2
+		etags = append(etags, value[:end])
+		value = value[end:]
+	}
+	return etags
+}
+
+// IsWeakETag reports whether entity tag has weakness indicator
+func IsWeakETag(etag string) bool {
+	return strings.HasPrefix(etag, "W/")
+}
+
+// ETagStrongMatch compares entity tags with strong comparison, both have to be strong and identical
+func ETagStrongMatch(a, b string) bool {
+	return a == b && a != "" && !IsWeakETag(a)
+}
+
+// ETagWeakMatch compares entity tags ignoring weakness indicator
+func ETagWeakMatch(a, b string) bool {
+	return a != "" && strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
@@ -0,0 +1,27 @@
+package headers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseETagList(t *testing.T) {
+	assert.Equal(t, []string{"*"}, ParseETagList(" * "))
+	assert.Equal(t, []string{`"a"`}, ParseETagList(`"a"`))
+	assert.Equal(t, []string{`"a"`, `W/"b"`, `"c,d"`}, ParseETagList(`"a", W/"b" ,"c,d"`))
+	// Test: Malformed elements are skipped
+	assert.Equal(t, []string{`"b"`}, ParseETagList(`abc, "b", "unterminated`))
+	assert.Empty(t, ParseETagList(""))
+}
+
+func TestETagComparison(t *testing.T) {
+	assert.True(t, ETagStrongMatch(`"1"`, `"1"`))
+	assert.False(t, ETagStrongMatch(`W/"1"`, `W/"1"`))
+	assert.False(t, ETagStrongMatch(`"1"`, `"2"`))
+
+	assert.True(t, ETagWeakMatch(`W/"1"`, `"1"`))
+	assert.True(t, ETagWeakMatch(`W/"1"`, `W/"1"`))
+	assert.False(t, ETagWeakMatch(`"1"`, `"2"`))
+	assert.False(t, ETagWeakMatch(`"1"`, ""))
+}
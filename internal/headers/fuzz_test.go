@@ -0,0 +1,89 @@
+package headers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func FuzzHeadersParse(f *testing.F) {
+	for _, seed := range []string{
+		"Host: localhost:42069\r\n\r\n",
+		"Host: localhost\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+		"X-Person: a\r\nx-person: b\r\n\r\n",
+		"       Host: spaced      \r\n\r\n",
+		"Empty:\r\n\r\n",
+		"Value: with: colons\r\n\r\n",
+		"\r\n",
+		// Adversarial
+		"Host : localhost\r\n\r\n",
+		"H©st: localhost\r\n\r\n",
+		"No colon\r\n\r\n",
+		": no name\r\n\r\n",
+		"\x00: x\r\n\r\n",
+		"X: a\rb\nc\r\n\r\n",
+		"\tHost\t: tab\r\n\r\n",
+		"X: " + strings.Repeat("a", 10000) + "\r\n\r\n",
+		"Incomplete: line",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data string) {
+		h := NewHeaders()
+		consumed := 0
+		done := false
+		var parseErr error
+		for !done {
+			n, fieldsDone, err := h.Parse([]byte(data[consumed:]))
+			if err != nil {
+				parseErr = err
+				break
+			}
+			require.LessOrEqual(t, consumed+n, len(data))
+			if n == 0 {
+				break
+			}
+			consumed += n
+			done = fieldsDone
+		}
+
+		// Section parsed at once gives the same result
+		section := NewHeaders()
+		n, sectionDone, err := section.ParseSection([]byte(data))
+		if parseErr != nil {
+			// Section is checked only once it is complete
+			if err == nil {
+				require.False(t, sectionDone)
+			}
+			return
+		}
+		require.NoError(t, err)
+		require.Equal(t, done, sectionDone)
+		if !done {
+			return
+		}
+		require.Equal(t, consumed, n)
+		assert.Equal(t, h, section)
+
+		// Parsed fields are valid and serialized fields parse into the same headers
+		var out strings.Builder
+		for name, value := range h {
+			require.True(t, IsValidHeaderName(name))
+			require.Equal(t, strings.ToLower(name), name)
+			require.NotContains(t, value, "\r\n")
+			out.WriteString(name + ": " + value + "\r\n")
+		}
+		out.WriteString("\r\n")
+		reparsed := NewHeaders()
+		_, reparsedDone, err := reparsed.ParseSection([]byte(out.String()))
+		require.NoError(t, err)
+		require.True(t, reparsedDone)
+		for name, value := range h {
+			// Value joined from repeated empty fields ends with ", " and loses the space
+			assert.Equal(t, strings.TrimSpace(value), reparsed[name])
+		}
+		assert.Len(t, reparsed, len(h))
+	})
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"fmt"
 	"strings"
-	"unicode"
 )
 
 type Headers map[string]string
@@ -19,69 +18,221 @@ func IsValidHeaderName(s string) bool {
 	if len(s) == 0 {
 		return false
 	}
-	for _, r := range s {
-		// Only ASCII allowed
-		if r > 127 {
+	for i := 0; i < len(s); i++ {
+		if !tokenChars[s[i]] {
 			return false
 		}
-		// No controls or whitespace
-		if r <= 31 || r == 127 || unicode.IsSpace(r) {
-			return false
-		}
-		switch r {
-		// Disallowed separators per HTTP token rule
-		case '(', ')', '<', '>', '@', // angles/at
-			',', ';', ':', // comma/semicolon/colon
-			'\\', '"', '/', // backslash/quote/slash
-			'[', ']', '?', '=', // brackets/question/equals
-			'{', '}', // braces
-			' ':
+	}
+	return true
+}
+
+// tokenChars marks bytes allowed in field name: visible ASCII except separators
+var tokenChars = func() (table [256]bool) {
+	for c := 33; c < 127; c++ {
+		table[c] = true
+	}
+	for _, c := range "()<>@,;:\\\"/[]?={}" {
+		table[c] = false
+	}
+	return table
+}()
+
+func isValidHeaderNameBytes(name []byte) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, c := range name {
+		if !tokenChars[c] {
 			return false
 		}
-		// Otherwise allowed tchar (ALPHA/DIGIT and listed symbols)
 	}
 	return true
 }
 
+// commonHeaderNames holds lowercased names of frequently sent fields, parsed names found here
+// don't need new string
+var commonHeaderNames = func() map[string]string {
+	names := map[string]string{}
+	for _, name := range []string{
+		"accept", "accept-encoding", "accept-language", "authorization", "cache-control",
+		"connection", "content-encoding", "content-length", "content-type", "cookie", "date",
+		"etag", "expect", "host", "if-match", "if-modified-since", "if-none-match", "if-range",
+		"if-unmodified-since", "last-modified", "origin", "pragma", "range", "referer", "server",
+		"te", "trailer", "transfer-encoding", "upgrade", "user-agent", "vary", "via",
+		"x-forwarded-for", "x-forwarded-host", "x-forwarded-proto", "x-request-id",
+		"sec-websocket-key", "sec-websocket-version", "sec-websocket-protocol", "sec-websocket-extensions",
+		"sec-websocket-accept", "last-event-id", "location",
+	} {
+		names[name] = name
+	}
+	return names
+}()
+
+// maxStackName is longest name lowercased without allocation
+const maxStackName = 64
+
+// lowerName returns lowercased field name, names of common fields are returned without allocation
+func lowerName[T string | []byte](name T) string {
+	if len(name) > maxStackName {
+		return string(appendLower(nil, name))
+	}
+	var buf [maxStackName]byte
+	lower := appendLower(buf[:0], name)
+	if common, ok := commonHeaderNames[string(lower)]; ok {
+		return common
+	}
+	return string(lower)
+}
+
+// appendLower appends name with ASCII letters lowercased
+func appendLower[T string | []byte](dst []byte, name T) []byte {
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
+
+// parseHeader splits field line into lowercased name and value, only value is copied
+// unless name is not one of the common ones
 func parseHeader(fieldLine []byte) (string, string, error) {
-	parts := bytes.SplitN(fieldLine, []byte(":"), 2) // case for : in field value
-	if len(parts) != 2 {
+	colon := bytes.IndexByte(fieldLine, ':') // case for : in field value
+	if colon == -1 {
 		return "", "", fmt.Errorf("malformed header")
 	}
 
-	if strings.HasSuffix(string(parts[0]), " ") {
+	if colon > 0 && fieldLine[colon-1] == ' ' {
 		return "", "", fmt.Errorf("whitespace between field name and colon detected. Malformed header")
 	}
 
-	headerNameBytes := bytes.TrimSpace(parts[0])
-	headerValue := bytes.TrimSpace(parts[1])
-
-	headerName := string(headerNameBytes)
+	headerNameBytes := bytes.TrimSpace(fieldLine[:colon])
+	headerValue := bytes.TrimSpace(fieldLine[colon+1:])
 
-	if !IsValidHeaderName(headerName) {
+	if !isValidHeaderNameBytes(headerNameBytes) {
 		return "", "", fmt.Errorf("not allowed character in header key")
 	}
 
-	return strings.ToLower(headerName), string(headerValue), nil
+	return lowerName(headerNameBytes), string(headerValue), nil
+}
+
+// parseHeaderString is parseHeader for line of section already converted to string,
+// value and lowercase name are substrings of the line
+func parseHeaderString(fieldLine string) (string, string, error) {
+	colon := strings.IndexByte(fieldLine, ':')
+	if colon == -1 {
+		return "", "", fmt.Errorf("malformed header")
+	}
+	if colon > 0 && fieldLine[colon-1] == ' ' {
+		return "", "", fmt.Errorf("whitespace between field name and colon detected. Malformed header")
+	}
 
+	headerName := strings.TrimSpace(fieldLine[:colon])
+	headerValue := strings.TrimSpace(fieldLine[colon+1:])
+	if !IsValidHeaderName(headerName) {
+		return "", "", fmt.Errorf("not allowed character in header key")
+	}
+	for i := 0; i < len(headerName); i++ {
+		if 'A' <= headerName[i] && headerName[i] <= 'Z' {
+			return lowerName(headerName), headerValue, nil
+		}
+	}
+	return headerName, headerValue, nil
 }
 
+// Get returns header value, name is matched case-insensitively.
+// Parsed headers are stored lowercased so that is checked first, headers set by handlers
+// keep their original casing and are found by scanning.
 func (h Headers) Get(name string) (string, bool) {
 
-	name = strings.ToLower(name)
-	headerValue, exists := h[name]
+	var headerValue string
+	var exists bool
+	if len(name) <= maxStackName {
+		// string conversion used as map key doesn't allocate
+		var buf [maxStackName]byte
+		headerValue, exists = h[string(appendLower(buf[:0], name))]
+	} else {
+		headerValue, exists = h[strings.ToLower(name)]
+	}
 	if exists {
 		return headerValue, true
 	}
+	for key, value := range h {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
 	return "", false
 
 }
 
+// Set sets header value replacing any existing header with the same name regardless of its casing
 func (h Headers) Set(name, value string) {
-	// name = strings.ToLower(name)
+	h.Del(name)
 	h[name] = value
 }
 
+// Del removes header, name is matched case-insensitively
+func (h Headers) Del(name string) {
+	for key := range h {
+		if strings.EqualFold(key, name) {
+			delete(h, key)
+		}
+	}
+}
+
+// HasToken reports whether comma separated header value (e.g. Connection) contains token,
+// tokens are compared case-insensitively
+func HasToken(value, token string) bool {
+	for _, option := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(option), token) {
+			return true
+		}
+	}
+	return false
+}
+
+var sectionEnd = []byte("\r\n\r\n")
+
+// ParseSection parses whole field section terminated by empty line, it returns 0 until all of it
+// is in data. Section is converted to string once and values are sliced from it, so parsing costs
+// single allocation instead of one per field. Errors are the same as from Parse.
+func (h Headers) ParseSection(data []byte) (int, bool, error) {
+	if bytes.HasPrefix(data, crlf) {
+		return len(crlf), true, nil
+	}
+	end := bytes.Index(data, sectionEnd)
+	if end == -1 {
+		return 0, false, nil
+	}
+
+	section := string(data[:end])
+	for {
+		line, rest, more := strings.Cut(section, "\r\n")
+		name, value, err := parseHeaderString(line)
+		if err != nil {
+			return 0, false, err
+		}
+		h.add(name, value)
+		if !more {
+			break
+		}
+		section = rest
+	}
+	return end + len(sectionEnd), true, nil
+}
+
+// add sets parsed field, repeated field is joined with previous value
+func (h Headers) add(name, value string) {
+	if existing, exists := h[name]; !exists {
+		h[name] = value
+	} else {
+		h[name] = existing + ", " + value
+	}
+}
+
 // Parse raw string headers to Headers map
 // Headers structure: ```field-line   = field-name ":" OWS field-value OWS``` OWS whitespaces zero or more
 // gets header data in bytes parses it according to headers structure and check if last crlf was found meainng end of headers.
@@ -109,12 +260,7 @@ func (h Headers) Parse(data []byte) (int, bool, error) {
 	}
 
 	// Set header if not existing else append
-
-	if _, exists := h[name]; !exists {
-		h[name] = value
-	} else {
-		h[name] += fmt.Sprintf(", %s", value)
-	}
+	h.add(name, value)
 
 	dataRead += len(headerBytes)
 
@@ -99,3 +99,34 @@ func TestParsingHeaders(t *testing.T) {
 	assert.False(t, done)
 
 }
+
+func TestParseSection(t *testing.T) {
+	// Test: Whole section parsed at once
+	headers := NewHeaders()
+	data := []byte("Host: localhost:42069\r\nX-Person: a\r\nx-person: b\r\n   Accept:  */* \r\n\r\nbody")
+	n, done, err := headers.ParseSection(data)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, len(data)-len("body"), n)
+	assert.Equal(t, Headers{"host": "localhost:42069", "x-person": "a, b", "accept": "*/*"}, headers)
+
+	// Test: Incomplete section waits for more data
+	headers = NewHeaders()
+	n, done, err = headers.ParseSection([]byte("Host: localhost\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+	assert.Empty(t, headers)
+
+	// Test: Empty section
+	n, done, err = headers.ParseSection([]byte("\r\nbody"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.True(t, done)
+
+	// Test: Same errors as Parse
+	_, _, err = headers.ParseSection([]byte("Host : localhost\r\n\r\n"))
+	require.ErrorContains(t, err, "whitespace between field name and colon detected")
+	_, _, err = headers.ParseSection([]byte("Host: localhost\r\nH©st: x\r\n\r\n"))
+	require.ErrorContains(t, err, "not allowed character in header key")
+}
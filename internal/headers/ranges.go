@@ -0,0 +1,144 @@
+package headers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnsatisfiableRange is returned when none of requested ranges overlaps representation
+var ErrUnsatisfiableRange = errors.New("range not satisfiable")
+
+// ErrInvalidRange is returned when Range header does not follow bytes range syntax,
+// such header should be ignored and full representation sent
+var ErrInvalidRange = errors.New("invalid range")
+
+// ByteRange is satisfiable range resolved against representation size
+type ByteRange struct {
+	Start  int64
+	Length int64
+}
+
+// ContentRange returns value of Content-Range header for range of representation of given size
+func (r ByteRange) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+// ParseRange parses Range header value (RFC 9233) against representation size
+// Supported forms: bytes=0-499, bytes=500-, bytes=-500 and comma separated list of them.
+// Ranges not overlapping representation are skipped, when none is left ErrUnsatisfiableRange is returned.
+func ParseRange(value string, size int64) ([]ByteRange, error) {
+	unit, rangeSet, found := strings.Cut(value, "=")
+	if !found || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, ErrInvalidRange
+	}
+
+	var ranges []ByteRange
+	for _, spec := range strings.Split(rangeSet, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		first, last, found := strings.Cut(spec, "-")
+		if !found {
+			return nil, ErrInvalidRange
+		}
+		first = strings.TrimSpace(first)
+		last = strings.TrimSpace(last)
+
+		var byteRange ByteRange
+		if first == "" {
+			// suffix range, last N bytes of representation
+			suffixLength, err := parseRangeNumber(last)
+			if err != nil {
+				return nil, err
+			}
+			if suffixLength == 0 {
+				continue
+			}
+			if suffixLength > size {
+				suffixLength = size
+			}
+			byteRange = ByteRange{Start: size - suffixLength, Length: suffixLength}
+		} else {
+			start, err := parseRangeNumber(first)
+			if err != nil {
+				return nil, err
+			}
+			end := size - 1
+			if last != "" {
+				end, err = parseRangeNumber(last)
+				if err != nil {
+					return nil, err
+				}
+				if end < start {
+					return nil, ErrInvalidRange
+				}
+			}
+			if start >= size {
+				continue
+			}
+			if end >= size {
+				end = size - 1
+			}
+			byteRange = ByteRange{Start: start, Length: end - start + 1}
+		}
+
+		if byteRange.Length > 0 {
+			ranges = append(ranges, byteRange)
+		}
+	}
+
+	if len(ranges) == 0 {
+		if size == 0 || strings.TrimSpace(rangeSet) != "" {
+			return nil, ErrUnsatisfiableRange
+		}
+		return nil, ErrInvalidRange
+	}
+	return ranges, nil
+}
+
+func parseRangeNumber(s string) (int64, error) {
+	if s == "" {
+		return 0, ErrInvalidRange
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, ErrInvalidRange
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidRange
+	}
+	return n, nil
+}
+
+// IfRange holds validator from If-Range header, either entity tag or HTTP-date
+type IfRange struct {
+	ETag string
+	Date time.Time
+}
+
+// ParseIfRange parses If-Range header value. Weak entity tags are not allowed in If-Range
+func ParseIfRange(value string) (IfRange, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "\"") {
+		if len(value) < 2 || !strings.HasSuffix(value, "\"") {
+			return IfRange{}, fmt.Errorf("malformed entity tag in If-Range: %s", value)
+		}
+		return IfRange{ETag: value}, nil
+	}
+	if strings.HasPrefix(value, "W/") {
+		return IfRange{}, fmt.Errorf("weak entity tag not allowed in If-Range: %s", value)
+	}
+
+	date, err := ParseTime(value)
+	if err != nil {
+		return IfRange{}, err
+	}
+	return IfRange{Date: date}, nil
+}
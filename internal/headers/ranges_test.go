@@ -0,0 +1,81 @@
+package headers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+
+	// Test: Single closed range
+	ranges, err := ParseRange("bytes=0-499", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 500}}, ranges)
+	assert.Equal(t, "bytes 0-499/1000", ranges[0].ContentRange(1000))
+
+	// Test: Open ended range
+	ranges, err = ParseRange("bytes=900-", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 900, Length: 100}}, ranges)
+
+	// Test: Suffix range longer than representation
+	ranges, err = ParseRange("bytes=-5000", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 1000}}, ranges)
+
+	// Test: End past representation is clamped
+	ranges, err = ParseRange("bytes=990-2000", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 990, Length: 10}}, ranges)
+
+	// Test: Multiple ranges with whitespace, unsatisfiable one skipped
+	ranges, err = ParseRange("bytes=0-9 , -10, 5000-6000", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 10}, {Start: 990, Length: 10}}, ranges)
+
+	// Test: Unsatisfiable
+	_, err = ParseRange("bytes=1000-", 1000)
+	assert.ErrorIs(t, err, ErrUnsatisfiableRange)
+	_, err = ParseRange("bytes=-0", 1000)
+	assert.ErrorIs(t, err, ErrUnsatisfiableRange)
+
+	// Test: Range unit is case insensitive
+	ranges, err = ParseRange("Bytes=0-9", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 10}}, ranges)
+
+	// Test: Invalid syntax
+	for _, value := range []string{"bytes", "items=0-1", "bytes=a-b", "bytes=5-1", "bytes=1", "bytes=", "bytes=+1-2"} {
+		_, err = ParseRange(value, 1000)
+		assert.ErrorIs(t, err, ErrInvalidRange, value)
+	}
+}
+
+func TestParseIfRange(t *testing.T) {
+	ifRange, err := ParseIfRange(`"abc"`)
+	require.NoError(t, err)
+	assert.Equal(t, `"abc"`, ifRange.ETag)
+
+	ifRange, err = ParseIfRange("Sun, 06 Nov 1994 08:49:37 GMT")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC), ifRange.Date)
+
+	_, err = ParseIfRange(`W/"abc"`)
+	require.Error(t, err)
+
+	_, err = ParseIfRange("yesterday")
+	require.Error(t, err)
+}
+
+func TestParseTime(t *testing.T) {
+	expected := time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC)
+	for _, value := range []string{"Sun, 06 Nov 1994 08:49:37 GMT", "Sunday, 06-Nov-94 08:49:37 GMT", "Sun Nov  6 08:49:37 1994"} {
+		parsed, err := ParseTime(value)
+		require.NoError(t, err, value)
+		assert.True(t, expected.Equal(parsed), value)
+	}
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", FormatTime(expected))
+}
@@ -0,0 +1,53 @@
+package headers
+
+import (
+	"strings"
+)
+
+// Fields which must not be sent in trailer section (RFC 9110 section 6.5.1),
+// they are needed for framing, routing, authentication or to process the content
+var forbiddenTrailers = map[string]bool{
+	"transfer-encoding":   true,
+	"content-length":      true,
+	"host":                true,
+	"cache-control":       true,
+	"expect":              true,
+	"max-forwards":        true,
+	"pragma":              true,
+	"range":               true,
+	"te":                  true,
+	"authorization":       true,
+	"proxy-authenticate":  true,
+	"proxy-authorization": true,
+	"www-authenticate":    true,
+	"set-cookie":          true,
+	"cookie":              true,
+	"age":                 true,
+	"expires":             true,
+	"date":                true,
+	"location":            true,
+	"retry-after":         true,
+	"vary":                true,
+	"warning":             true,
+	"content-encoding":    true,
+	"content-type":        true,
+	"content-range":       true,
+	"trailer":             true,
+}
+
+// IsForbiddenTrailer reports whether field is not allowed in trailer section
+func IsForbiddenTrailer(name string) bool {
+	return forbiddenTrailers[strings.ToLower(name)]
+}
+
+// ParseTrailerNames splits Trailer header value into lowercased field names
+func ParseTrailerNames(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	return names
+}
@@ -0,0 +1,312 @@
+// Package metrics collects counters, gauges and histograms and exposes them
+// in Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/response"
+)
+
+// DefaultBuckets are histogram buckets for latencies in seconds
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// ExponentialBuckets returns count buckets, first is start and every next is factor times bigger
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// Registry holds metrics exposed together
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+	names    map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+// Counter registers counter, value which only goes up. Label names are given in the order
+// label values are later passed to Add and Inc.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, "counter", labels, nil)}
+}
+
+// Gauge registers gauge, value which goes up and down
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, "gauge", labels, nil)}
+}
+
+// Histogram registers histogram counting observations in buckets with given upper bounds,
+// DefaultBuckets are used when buckets is nil
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{r.register(name, help, "histogram", labels, buckets)}
+}
+
+func (r *Registry) register(name, help, kind string, labels []string, buckets []float64) *family {
+	if !validName(name) {
+		panic("metrics: invalid metric name " + strconv.Quote(name))
+	}
+	for _, label := range labels {
+		if !validName(label) || label == "le" {
+			panic("metrics: invalid label name " + strconv.Quote(label))
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+	r.families = append(r.families, f)
+	return f
+}
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		isLetter := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == ':'
+		if !isLetter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// family is metric with all its labeled series
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is single labeled value, histogram keeps bucket counts, sum and count
+type series struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	count       uint64
+}
+
+// with returns series for label values, family lock has to be held
+func (f *family) with(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, exists := f.series[key]
+	if !exists {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.buckets != nil {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+type Counter struct{ f *family }
+
+// Add increases counter by value, negative values are ignored
+func (c *Counter) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		return
+	}
+	c.f.mu.Lock()
+	c.f.with(labelValues).value += value
+	c.f.mu.Unlock()
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+type Gauge struct{ f *family }
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.f.mu.Lock()
+	g.f.with(labelValues).value = value
+	g.f.mu.Unlock()
+}
+
+func (g *Gauge) Add(value float64, labelValues ...string) {
+	g.f.mu.Lock()
+	g.f.with(labelValues).value += value
+	g.f.mu.Unlock()
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+type Histogram struct{ f *family }
+
+// Observe records value in the first bucket it fits in, +Inf bucket is the total count
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	s := h.f.with(labelValues)
+	s.value += value
+	s.count++
+	if i := sort.SearchFloat64s(h.f.buckets, value); i < len(s.counts) {
+		s.counts[i]++
+	}
+}
+
+// WriteTo writes all metrics in Prometheus text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	var out []byte
+	for _, f := range families {
+		out = f.append(out)
+	}
+	n, err := w.Write(out)
+	return int64(n), err
+}
+
+func (f *family) append(dst []byte) []byte {
+	dst = append(dst, "# HELP "+f.name+" "...)
+	dst = append(dst, escape(f.help, false)...)
+	dst = append(dst, "\n# TYPE "+f.name+" "+f.kind+"\n"...)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != "histogram" {
+			dst = f.appendSample(dst, f.name, s.labelValues, "", s.value)
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += s.counts[i]
+			dst = f.appendSample(dst, f.name+"_bucket", s.labelValues, formatFloat(bound), float64(cumulative))
+		}
+		dst = f.appendSample(dst, f.name+"_bucket", s.labelValues, "+Inf", float64(s.count))
+		dst = f.appendSample(dst, f.name+"_sum", s.labelValues, "", s.value)
+		dst = f.appendSample(dst, f.name+"_count", s.labelValues, "", float64(s.count))
+	}
+	return dst
+}
+
+// appendSample writes sample line, le label is added for histogram bucket
+func (f *family) appendSample(dst []byte, name string, labelValues []string, le string, value float64) []byte {
+	dst = append(dst, name...)
+	if len(labelValues) > 0 || le != "" {
+		dst = append(dst, '{')
+		for i, label := range f.labels {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = append(dst, label+`="`...)
+			dst = append(dst, escape(labelValues[i], true)...)
+			dst = append(dst, '"')
+		}
+		if le != "" {
+			if len(labelValues) > 0 {
+				dst = append(dst, ',')
+			}
+			dst = append(dst, `le="`+le+`"`...)
+		}
+		dst = append(dst, '}')
+	}
+	dst = append(dst, ' ')
+	dst = append(dst, formatFloat(value)...)
+	return append(dst, '\n')
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// escape escapes backslash and new line, in label values also double quote
+func escape(value string, quote bool) string {
+	if !strings.ContainsAny(value, "\\\n\"") {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\\':
+			b.WriteString(`\\`)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '"' && quote:
+			b.WriteString(`\"`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Content type of text exposition format
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Serve is Handler exposing metrics, mount it on path scraped by Prometheus (usually /metrics)
+func (r *Registry) Serve(w *response.Writer, req *request.Request) {
+	var body strings.Builder
+	r.WriteTo(&body)
+
+	w.WriteStatusLine(response.OkStatusCode)
+	h := response.GetDefaultHeaders(body.Len())
+	h.Set("Content-Type", contentType)
+	h.Set("Cache-Control", "no-store")
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(body.String()))
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exposition(t *testing.T, r *Registry) string {
+	t.Helper()
+	var out strings.Builder
+	_, err := r.WriteTo(&out)
+	require.NoError(t, err)
+	return out.String()
+}
+
+func TestExpositionFormat(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("requests_total", "Requests served.", "method", "status")
+	active := r.Gauge("active", "Open connections.\nSecond line with \\.")
+	latency := r.Histogram("latency_seconds", "Latency.", []float64{1, 0.1}, "route")
+
+	requests.Inc("GET", "200")
+	requests.Add(2, "GET", "200")
+	requests.Inc("POST", "500")
+	// Test: Counter doesn't go down
+	requests.Add(-5, "POST", "500")
+	active.Inc()
+	active.Inc()
+	active.Dec()
+	latency.Observe(0.05, `/a"b\`)
+	latency.Observe(0.5, `/a"b\`)
+	latency.Observe(3, `/a"b\`)
+
+	expected := `# HELP active Open connections.\nSecond line with \\.
+# TYPE active gauge
+active 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a\"b\\",le="0.1"} 1
+latency_seconds_bucket{route="/a\"b\\",le="1"} 2
+latency_seconds_bucket{route="/a\"b\\",le="+Inf"} 3
+latency_seconds_sum{route="/a\"b\\"} 3.55
+latency_seconds_count{route="/a\"b\\"} 3
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{method="GET",status="200"} 3
+requests_total{method="POST",status="500"} 1
+`
+	assert.Equal(t, expected, exposition(t, r))
+
+	active.Set(42)
+	assert.Contains(t, exposition(t, r), "\nactive 42\n")
+}
+
+func TestRegistryValidation(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("total", "")
+	assert.Panics(t, func() { r.Gauge("total", "") })
+	assert.Panics(t, func() { r.Counter("1st", "") })
+	assert.Panics(t, func() { r.Counter("with-dash", "") })
+	assert.Panics(t, func() { r.Histogram("h", "", nil, "le") })
+
+	labeled := r.Counter("labeled", "", "method")
+	assert.Panics(t, func() { labeled.Inc() })
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	r := NewRegistry()
+	counter := r.Counter("total", "", "worker")
+	histogram := r.Histogram("sizes", "", ExponentialBuckets(1, 10, 3))
+	assert.Equal(t, []float64{1, 10, 100}, histogram.f.buckets)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				counter.Inc("all")
+				histogram.Observe(float64(j))
+			}
+			r.WriteTo(io.Discard)
+		}()
+	}
+	wg.Wait()
+	out := exposition(t, r)
+	assert.Contains(t, out, "total{worker=\"all\"} 8000\n")
+	assert.Contains(t, out, "sizes_bucket{le=\"10\"} 88\n")
+	assert.Contains(t, out, "sizes_count 8000\n")
+}
+
+func TestServe(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("total", "Total.").Inc()
+
+	var buf bytes.Buffer
+	w := response.NewWritter(&buf)
+	r.Serve(w, &request.Request{})
+	require.NoError(t, w.Finish())
+
+	resp, err := response.ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, response.OkStatusCode, resp.StatusLine.StatusCode)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", resp.Headers["content-type"])
+	assert.Equal(t, "# HELP total Total.\n# TYPE total counter\ntotal 1\n", string(resp.Body))
+}
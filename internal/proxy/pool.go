@@ -0,0 +1,393 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/client"
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/response"
+)
+
+type Balancing int
+
+const (
+	RoundRobin Balancing = iota
+	LeastConnections
+	// ConsistentHash sends requests with the same key (header, cookie or client IP) to the same backend
+	ConsistentHash
+)
+
+const (
+	defaultMaxFails            = 3
+	defaultEjectDuration       = 30 * time.Second
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 2 * time.Second
+)
+
+// Virtual nodes per backend on consistent hash ring, spreads keys evenly
+const hashReplicas = 100
+
+type PoolOptions struct {
+	Balancing Balancing
+	// HashHeader or HashCookie value is hash key for ConsistentHash, client IP is used when they are empty or missing
+	HashHeader string
+	HashCookie string
+
+	// HealthCheck enables active checks of backends
+	HealthCheck HealthCheck
+
+	// MaxFails consecutive failed requests eject backend for EjectDuration, 3 when 0, negative disables ejection
+	MaxFails int
+	// EjectDuration is 30 seconds when 0
+	EjectDuration time.Duration
+
+	// Retries of idempotent requests on different backend when upstream can't be reached
+	Retries int
+}
+
+type HealthCheck struct {
+	// Path requested on every backend, checks are disabled when empty. 2xx and 3xx answers mean healthy backend.
+	Path string
+	// Interval between checks, 10 seconds when 0
+	Interval time.Duration
+	// Timeout of single check, 2 seconds when 0
+	Timeout time.Duration
+}
+
+// Pool holds upstream backends and picks one for every request
+type Pool struct {
+	backends []*backend
+	options  PoolOptions
+
+	maxFails      int
+	ejectDuration time.Duration
+	retries       int
+
+	counter atomic.Uint64
+	ring    []ringNode
+
+	client *client.Client
+	stop   chan struct{}
+	once   sync.Once
+}
+
+type backend struct {
+	url    *url.URL
+	active atomic.Int64
+
+	mu                  sync.Mutex
+	unhealthy           bool
+	consecutiveFailures int
+	ejectedUntil        time.Time
+	requests            uint64
+	failures            uint64
+	lastCheck           time.Time
+	lastCheckError      string
+}
+
+type ringNode struct {
+	hash    uint64
+	backend *backend
+}
+
+// BackendStatus is state of single backend reported by Pool.Status
+type BackendStatus struct {
+	URL                 string    `json:"url"`
+	Available           bool      `json:"available"`
+	Healthy             bool      `json:"healthy"`
+	EjectedUntil        time.Time `json:"ejected_until,omitzero"`
+	ActiveRequests      int64     `json:"active_requests"`
+	Requests            uint64    `json:"requests"`
+	Failures            uint64    `json:"failures"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastCheck           time.Time `json:"last_check,omitzero"`
+	LastCheckError      string    `json:"last_check_error,omitempty"`
+}
+
+// NewPool creates pool of upstream base URLs and starts health checks when enabled.
+// Close stops health checks.
+func NewPool(targets []string, options PoolOptions) (*Pool, error) {
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("pool needs at least one upstream")
+	}
+	pool := &Pool{
+		options:       options,
+		maxFails:      options.MaxFails,
+		ejectDuration: options.EjectDuration,
+		retries:       options.Retries,
+		stop:          make(chan struct{}),
+	}
+	if pool.maxFails == 0 {
+		pool.maxFails = defaultMaxFails
+	}
+	if pool.ejectDuration <= 0 {
+		pool.ejectDuration = defaultEjectDuration
+	}
+
+	for _, target := range targets {
+		targetURL, err := parseUpstream(strings.TrimSpace(target))
+		if err != nil {
+			return nil, err
+		}
+		pool.backends = append(pool.backends, &backend{url: targetURL})
+	}
+
+	if options.Balancing == ConsistentHash {
+		pool.buildRing()
+	}
+
+	if options.HealthCheck.Path != "" {
+		interval := options.HealthCheck.Interval
+		if interval <= 0 {
+			interval = defaultHealthCheckInterval
+		}
+		timeout := options.HealthCheck.Timeout
+		if timeout <= 0 {
+			timeout = defaultHealthCheckTimeout
+		}
+		pool.client = &client.Client{
+			Timeout: timeout,
+			// Redirect answer already means backend is alive
+			MaxRedirects: -1,
+		}
+		pool.checkAll()
+		go pool.healthCheckLoop(interval)
+	}
+	return pool, nil
+}
+
+// Close stops health checks
+func (p *Pool) Close() {
+	if p.stop == nil {
+		return
+	}
+	p.once.Do(func() { close(p.stop) })
+}
+
+func (p *Pool) buildRing() {
+	for _, b := range p.backends {
+		for i := 0; i < hashReplicas; i++ {
+			p.ring = append(p.ring, ringNode{hash: hashKey(b.url.String() + "#" + strconv.Itoa(i)), backend: b})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+}
+
+func hashKey(key string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	// FNV of similar keys (url#1, url#2...) differs mostly in low bits, mix them so ring positions spread
+	x := hash.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// next returns backend for request which is available and was not tried yet, nil when there is none
+func (p *Pool) next(req *request.Request, tried []*backend) *backend {
+	now := time.Now()
+	usable := func(b *backend) bool {
+		for _, t := range tried {
+			if t == b {
+				return false
+			}
+		}
+		return b.available(now)
+	}
+
+	switch p.options.Balancing {
+	case LeastConnections:
+		var best *backend
+		// Start at rotating offset so ties are spread between backends
+		start := int(p.counter.Add(1) - 1)
+		for i := range p.backends {
+			b := p.backends[(start+i)%len(p.backends)]
+			if usable(b) && (best == nil || b.active.Load() < best.active.Load()) {
+				best = b
+			}
+		}
+		return best
+
+	case ConsistentHash:
+		hash := hashKey(p.hashKey(req))
+		index := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= hash })
+		// Walk the ring clockwise, unavailable backend moves its keys to the next one
+		for i := range p.ring {
+			node := p.ring[(index+i)%len(p.ring)]
+			if usable(node.backend) {
+				return node.backend
+			}
+		}
+		return nil
+
+	default:
+		start := int(p.counter.Add(1) - 1)
+		for i := range p.backends {
+			b := p.backends[(start+i)%len(p.backends)]
+			if usable(b) {
+				return b
+			}
+		}
+		return nil
+	}
+}
+
+// hashKey returns consistent hash key of request
+func (p *Pool) hashKey(req *request.Request) string {
+	if p.options.HashHeader != "" {
+		if value, exists := req.Headers.Get(p.options.HashHeader); exists && value != "" {
+			return value
+		}
+	}
+	if p.options.HashCookie != "" {
+		if value, exists := cookieValue(req, p.options.HashCookie); exists {
+			return value
+		}
+	}
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return ip
+}
+
+func cookieValue(req *request.Request, name string) (string, bool) {
+	cookies, _ := req.Headers.Get("Cookie")
+	// Multiple Cookie lines were joined with comma by header parser
+	for _, pair := range strings.FieldsFunc(cookies, func(r rune) bool { return r == ';' || r == ',' }) {
+		cookieName, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if found && cookieName == name {
+			return strings.Trim(value, `"`), true
+		}
+	}
+	return "", false
+}
+
+func (b *backend) available(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.unhealthy && !now.Before(b.ejectedUntil)
+}
+
+// reportFailure counts failed request, backend is ejected after too many failures in a row
+func (p *Pool) reportFailure(b *backend) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests++
+	b.failures++
+	b.consecutiveFailures++
+	if p.maxFails > 0 && b.consecutiveFailures >= p.maxFails {
+		b.ejectedUntil = time.Now().Add(p.ejectDuration)
+		b.consecutiveFailures = 0
+	}
+}
+
+func (p *Pool) reportSuccess(b *backend) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests++
+	b.consecutiveFailures = 0
+}
+
+func (p *Pool) healthCheckLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.checkAll()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+func (p *Pool) checkAll() {
+	var wg sync.WaitGroup
+	for _, b := range p.backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.check(b)
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *Pool) check(b *backend) {
+	checkURL := *b.url
+	checkURL.Path = joinPath(b.url.Path, p.options.HealthCheck.Path)
+	checkURL.RawPath = ""
+	checkURL.RawQuery = ""
+
+	errorMessage := ""
+	resp, err := p.client.Get(checkURL.String())
+	if err != nil {
+		errorMessage = err.Error()
+	} else {
+		resp.Body.Close()
+		statusCode := resp.StatusLine.StatusCode
+		if statusCode < 200 || statusCode >= 400 {
+			errorMessage = "status " + strconv.Itoa(int(statusCode))
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastCheck = time.Now()
+	b.lastCheckError = errorMessage
+	b.unhealthy = errorMessage != ""
+}
+
+// Status returns state of every backend
+func (p *Pool) Status() []BackendStatus {
+	now := time.Now()
+	statuses := make([]BackendStatus, 0, len(p.backends))
+	for _, b := range p.backends {
+		b.mu.Lock()
+		status := BackendStatus{
+			URL:                 b.url.String(),
+			Available:           !b.unhealthy && !now.Before(b.ejectedUntil),
+			Healthy:             !b.unhealthy,
+			ActiveRequests:      b.active.Load(),
+			Requests:            b.requests,
+			Failures:            b.failures,
+			ConsecutiveFailures: b.consecutiveFailures,
+			LastCheck:           b.lastCheck,
+			LastCheckError:      b.lastCheckError,
+		}
+		if now.Before(b.ejectedUntil) {
+			status.EjectedUntil = b.ejectedUntil
+		}
+		b.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// ServeStatus is admin handler answering with JSON array of backend states
+func (p *Pool) ServeStatus(w *response.Writer, req *request.Request) {
+	body, err := json.MarshalIndent(p.Status(), "", "  ")
+	if err != nil {
+		body = []byte("[]")
+	}
+	w.WriteStatusLine(response.OkStatusCode)
+	h := response.GetDefaultHeaders(len(body))
+	h.Set("Content-Type", "application/json")
+	h.Set("Cache-Control", "no-store")
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
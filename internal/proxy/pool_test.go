@@ -0,0 +1,245 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startBackend runs upstream answering with its name
+func startBackend(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+// closedURL returns url of port nothing listens on
+func closedURL(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+	return "http://" + address
+}
+
+func startPoolProxy(t *testing.T, pool *Pool) string {
+	t.Helper()
+	srv, err := server.Serve(0, NewPoolProxy(pool, Options{}).Serve)
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+	return "http://127.0.0.1:" + strconv.Itoa(srv.Addr().(*net.TCPAddr).Port)
+}
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func poolRequest(headerName, headerValue string) *request.Request {
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+		RemoteAddr:  "10.0.0.1:5000",
+	}
+	if headerName != "" {
+		req.Headers.Set(headerName, headerValue)
+	}
+	return req
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	pool, err := NewPool([]string{startBackend(t, "a").URL, startBackend(t, "b").URL, startBackend(t, "c").URL}, PoolOptions{})
+	require.NoError(t, err)
+	proxyURL := startPoolProxy(t, pool)
+
+	var names []string
+	for i := 0; i < 6; i++ {
+		status, body := get(t, proxyURL+"/")
+		require.Equal(t, http.StatusOK, status)
+		names = append(names, body)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, names)
+}
+
+func TestPoolLeastConnections(t *testing.T) {
+	pool, err := NewPool([]string{"http://a.test", "http://b.test", "http://c.test"}, PoolOptions{Balancing: LeastConnections})
+	require.NoError(t, err)
+	pool.backends[0].active.Store(5)
+	pool.backends[1].active.Store(1)
+	pool.backends[2].active.Store(3)
+
+	for i := 0; i < 3; i++ {
+		assert.Same(t, pool.backends[1], pool.next(poolRequest("", ""), nil))
+	}
+
+	// Test: Already tried backend is skipped
+	assert.Same(t, pool.backends[2], pool.next(poolRequest("", ""), []*backend{pool.backends[1]}))
+}
+
+func TestPoolConsistentHash(t *testing.T) {
+	pool, err := NewPool([]string{"http://a.test", "http://b.test", "http://c.test"}, PoolOptions{
+		Balancing:  ConsistentHash,
+		HashHeader: "X-User",
+		HashCookie: "session",
+	})
+	require.NoError(t, err)
+
+	// Test: Same key always goes to the same backend
+	first := pool.next(poolRequest("X-User", "alice"), nil)
+	for i := 0; i < 10; i++ {
+		assert.Same(t, first, pool.next(poolRequest("X-User", "alice"), nil))
+	}
+
+	// Test: Keys are spread between backends
+	used := map[*backend]bool{}
+	for i := 0; i < 100; i++ {
+		used[pool.next(poolRequest("X-User", "user-"+strconv.Itoa(i)), nil)] = true
+	}
+	assert.Len(t, used, 3)
+
+	// Test: Cookie is used when header is missing
+	byCookie := pool.next(poolRequest("Cookie", "theme=dark; session=alice"), nil)
+	assert.Same(t, first, byCookie)
+
+	// Test: Ejected backend moves only its own keys
+	assignment := map[string]*backend{}
+	for i := 0; i < 100; i++ {
+		key := "user-" + strconv.Itoa(i)
+		assignment[key] = pool.next(poolRequest("X-User", key), nil)
+	}
+	ejected := pool.backends[0]
+	ejected.ejectedUntil = time.Now().Add(time.Minute)
+	for key, previous := range assignment {
+		current := pool.next(poolRequest("X-User", key), nil)
+		assert.NotSame(t, ejected, current)
+		if previous != ejected {
+			assert.Same(t, previous, current, key)
+		}
+	}
+}
+
+func TestPoolRetryAndEjection(t *testing.T) {
+	dead := closedURL(t)
+	pool, err := NewPool([]string{dead, startBackend(t, "live").URL}, PoolOptions{MaxFails: 1, Retries: 1})
+	require.NoError(t, err)
+	proxyURL := startPoolProxy(t, pool)
+
+	// Test: Idempotent request is retried on other backend
+	for i := 0; i < 4; i++ {
+		status, body := get(t, proxyURL+"/")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "live", body)
+	}
+
+	// Test: Failing backend is ejected after MaxFails
+	statuses := pool.Status()
+	assert.False(t, statuses[0].Available)
+	assert.False(t, statuses[0].EjectedUntil.IsZero())
+	assert.Equal(t, uint64(1), statuses[0].Failures)
+	assert.True(t, statuses[1].Available)
+	assert.Equal(t, uint64(4), statuses[1].Requests)
+
+	// Test: Not idempotent request is not retried
+	pool, err = NewPool([]string{closedURL(t), closedURL(t)}, PoolOptions{MaxFails: -1, Retries: 3})
+	require.NoError(t, err)
+	proxyURL = startPoolProxy(t, pool)
+	resp, err := http.Post(proxyURL+"/", "text/plain", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	statuses = pool.Status()
+	assert.Equal(t, uint64(1), statuses[0].Failures+statuses[1].Failures)
+
+	// Test: Retries are limited by number of backends
+	status, _ := get(t, proxyURL+"/")
+	assert.Equal(t, http.StatusBadGateway, status)
+	statuses = pool.Status()
+	assert.Equal(t, uint64(3), statuses[0].Failures+statuses[1].Failures)
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	var healthy atomic.Bool
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" && !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("flaky"))
+	}))
+	defer flaky.Close()
+
+	pool, err := NewPool([]string{flaky.URL, startBackend(t, "stable").URL}, PoolOptions{
+		HealthCheck: HealthCheck{Path: "/healthz", Interval: 20 * time.Millisecond},
+	})
+	require.NoError(t, err)
+	defer pool.Close()
+	proxyURL := startPoolProxy(t, pool)
+
+	// Test: Unhealthy backend gets no traffic
+	for i := 0; i < 3; i++ {
+		_, body := get(t, proxyURL+"/")
+		assert.Equal(t, "stable", body)
+	}
+	status := pool.Status()[0]
+	assert.False(t, status.Healthy)
+	assert.Equal(t, "status 503", status.LastCheckError)
+
+	// Test: Recovered backend is used again
+	healthy.Store(true)
+	assert.Eventually(t, func() bool { return pool.Status()[0].Healthy }, 2*time.Second, 10*time.Millisecond)
+	seen := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		_, body := get(t, proxyURL+"/")
+		seen[body] = true
+	}
+	assert.True(t, seen["flaky"])
+
+	// Test: No available backend
+	healthy.Store(false)
+	pool.backends[1].mu.Lock()
+	pool.backends[1].ejectedUntil = time.Now().Add(time.Minute)
+	pool.backends[1].mu.Unlock()
+	assert.Eventually(t, func() bool { return !pool.Status()[0].Healthy }, 2*time.Second, 10*time.Millisecond)
+	status503, _ := get(t, proxyURL+"/")
+	assert.Equal(t, http.StatusServiceUnavailable, status503)
+}
+
+func TestPoolServeStatus(t *testing.T) {
+	pool, err := NewPool([]string{startBackend(t, "a").URL}, PoolOptions{})
+	require.NoError(t, err)
+	srv, err := server.Serve(0, pool.ServeStatus)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	resp, err := http.Get("http://127.0.0.1:" + strconv.Itoa(srv.Addr().(*net.TCPAddr).Port) + "/admin/upstreams")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var statuses []BackendStatus
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&statuses))
+	require.Len(t, statuses, 1)
+	assert.True(t, statuses[0].Available)
+	assert.Equal(t, pool.backends[0].url.String(), statuses[0].URL)
+}
@@ -0,0 +1,407 @@
+// Package proxy implements reverse proxy handler forwarding requests to upstream HTTP server
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/client"
+	"github.com/MichalGul/http_server_go/internal/headers"
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/response"
+	"github.com/MichalGul/http_server_go/internal/server"
+)
+
+const (
+	defaultDialTimeout     = 10 * time.Second
+	defaultResponseTimeout = 30 * time.Second
+)
+
+const copyBufferSize = 32 * 1024
+
+// Hop-by-hop headers describe single connection and are not forwarded (RFC 9110 7.6.1)
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+type Options struct {
+	// StripPrefix is removed from request path before it is appended to upstream path
+	StripPrefix string
+	// DialTimeout of upstream connection, 10 seconds when 0
+	DialTimeout time.Duration
+	// ResponseTimeout is time to wait for upstream response headers, 30 seconds when 0
+	ResponseTimeout time.Duration
+	// Client sends upstream requests, when nil client with configured timeouts is used.
+	// It must not follow redirects, they are passed to the client.
+	Client *client.Client
+}
+
+// Proxy forwards requests to upstream and streams its responses back
+type Proxy struct {
+	pool    *Pool
+	options Options
+	client  *client.Client
+}
+
+// New creates proxy for single upstream base URL, e.g. "http://127.0.0.1:8080/api"
+func New(target string, options Options) (*Proxy, error) {
+	targetURL, err := parseUpstream(target)
+	if err != nil {
+		return nil, err
+	}
+	return NewPoolProxy(&Pool{backends: []*backend{{url: targetURL}}}, options), nil
+}
+
+// NewPoolProxy creates proxy balancing requests between upstreams of the pool
+func NewPoolProxy(pool *Pool, options Options) *Proxy {
+	if options.DialTimeout <= 0 {
+		options.DialTimeout = defaultDialTimeout
+	}
+	if options.ResponseTimeout <= 0 {
+		options.ResponseTimeout = defaultResponseTimeout
+	}
+	upstreamClient := options.Client
+	if upstreamClient == nil {
+		upstreamClient = &client.Client{
+			DialTimeout:           options.DialTimeout,
+			ResponseHeaderTimeout: options.ResponseTimeout,
+			MaxIdleConnsPerHost:   16,
+			MaxRedirects:          -1,
+		}
+	}
+
+	return &Proxy{
+		pool:    pool,
+		options: options,
+		client:  upstreamClient,
+	}
+}
+
+func parseUpstream(target string) (*url.URL, error) {
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream url: %v", err)
+	}
+	if targetURL.Scheme != "http" && targetURL.Scheme != "https" || targetURL.Host == "" {
+		return nil, fmt.Errorf("upstream url must be absolute http or https url: %s", target)
+	}
+	return targetURL, nil
+}
+
+// Serve is server.Handler forwarding request to upstream.
+// Idempotent requests failing to reach upstream are retried on different backend when pool allows retries.
+func (p *Proxy) Serve(w *response.Writer, req *request.Request) {
+	if !strings.HasPrefix(req.RequestLine.RequestTarget, "/") {
+		server.HandlerError{StatusCode: response.BadRequestStatusCode, Message: "request target must be origin-form path"}.Write(w)
+		return
+	}
+
+	var tried []*backend
+	var lastErr error
+	for {
+		b := p.pool.next(req, tried)
+		if b == nil && lastErr != nil {
+			// Every backend was tried
+			writeUpstreamError(w, req, lastErr)
+			return
+		}
+		if b == nil {
+			server.HandlerError{StatusCode: response.ServiceUnavailableStatusCode, Message: "no upstream available"}.Write(w)
+			return
+		}
+		tried = append(tried, b)
+
+		upstreamReq, err := p.upstreamRequest(b.url, req)
+		if err != nil {
+			server.HandlerError{StatusCode: response.BadRequestStatusCode, Message: err.Error()}.Write(w)
+			return
+		}
+
+		b.active.Add(1)
+		upstreamResp, err := p.client.Do(upstreamReq)
+		if err != nil {
+			b.active.Add(-1)
+			p.pool.reportFailure(b)
+			if isIdempotent(req.RequestLine.Method) && len(tried) <= p.pool.retries {
+				lastErr = err
+				continue
+			}
+			writeUpstreamError(w, req, err)
+			return
+		}
+		if isGatewayError(upstreamResp.StatusLine.StatusCode) {
+			p.pool.reportFailure(b)
+		} else {
+			p.pool.reportSuccess(b)
+		}
+
+		p.writeResponse(w, req, upstreamResp)
+		upstreamResp.Body.Close()
+		b.active.Add(-1)
+		return
+	}
+}
+
+// upstreamRequest builds request to backend, body is streamed from request read by server.
+// Every attempt gets new reader over it, so body can be sent again on retry.
+func (p *Proxy) upstreamRequest(target *url.URL, req *request.Request) (*client.Request, error) {
+	upstreamURL, err := p.upstreamURL(target, req.RequestLine.RequestTarget)
+	if err != nil {
+		return nil, err
+	}
+
+	var upstreamReq *client.Request
+	if len(req.Body) > 0 {
+		upstreamReq, err = client.NewRequestReader(req.RequestLine.Method, upstreamURL, bytes.NewReader(req.Body), int64(len(req.Body)))
+	} else {
+		upstreamReq, err = client.NewRequest(req.RequestLine.Method, upstreamURL, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	copyRequestHeaders(upstreamReq.Header, req.Headers)
+	setForwardedHeaders(upstreamReq.Header, req)
+	return upstreamReq, nil
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// isGatewayError reports statuses meaning upstream itself couldn't handle the request
+func isGatewayError(statusCode response.StatusCode) bool {
+	return statusCode == 502 || statusCode == 503 || statusCode == 504
+}
+
+// upstreamURL joins upstream base with request path and query
+func (p *Proxy) upstreamURL(target *url.URL, requestTarget string) (string, error) {
+	if !strings.HasPrefix(requestTarget, "/") {
+		return "", fmt.Errorf("request target must be origin-form path")
+	}
+	path, query, _ := strings.Cut(requestTarget, "?")
+	if p.options.StripPrefix != "" {
+		stripped, found := strings.CutPrefix(path, p.options.StripPrefix)
+		// Prefix has to end at segment boundary, /apiary is not inside /api
+		if !found || (stripped != "" && stripped[0] != '/' && !strings.HasSuffix(p.options.StripPrefix, "/")) {
+			return "", fmt.Errorf("path %s is outside of %s", path, p.options.StripPrefix)
+		}
+		path = stripped
+	}
+
+	upstream := *target
+	upstream.RawPath = ""
+	upstream.Path = joinPath(target.Path, path)
+	upstream.RawQuery = query
+	if target.RawQuery != "" {
+		upstream.RawQuery = strings.TrimSuffix(target.RawQuery+"&"+query, "&")
+	}
+	return upstream.String(), nil
+}
+
+func joinPath(base, path string) string {
+	if path == "" {
+		if base == "" {
+			return "/"
+		}
+		return base
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return strings.TrimSuffix(base, "/") + path
+}
+
+func (p *Proxy) writeResponse(w *response.Writer, req *request.Request, upstreamResp *client.Response) {
+	err := w.WriteStatusLine(upstreamResp.StatusLine.StatusCode)
+	if err != nil {
+		return
+	}
+
+	h := w.Header()
+	// Upstream decides about body framing
+	h.Del("Content-Length")
+	h.Del("Content-Type")
+	copyResponseHeaders(h, upstreamResp.Headers)
+	if upstreamResp.ContentLength >= 0 {
+		h.Set("Content-Length", strconv.FormatInt(upstreamResp.ContentLength, 10))
+	}
+	// Trailers can be passed on only in chunked body
+	trailerValue, _ := upstreamResp.Headers.Get("Trailer")
+	var trailerNames []string
+	for _, name := range headers.ParseTrailerNames(trailerValue) {
+		if !headers.IsForbiddenTrailer(name) {
+			trailerNames = append(trailerNames, name)
+		}
+	}
+	if len(trailerNames) > 0 && upstreamResp.ContentLength < 0 {
+		h.Set("Trailer", strings.Join(trailerNames, ", "))
+	}
+
+	if !hasBody(req.RequestLine.Method, upstreamResp.StatusLine.StatusCode) {
+		w.WriteHeaders(nil)
+		return
+	}
+
+	body, err := w.Body()
+	if err != nil {
+		return
+	}
+	buf := make([]byte, copyBufferSize)
+	for {
+		n, readErr := upstreamResp.Body.Read(buf)
+		if n > 0 {
+			_, err = body.Write(buf[:n])
+			if err != nil {
+				return
+			}
+			// Streamed responses reach client as soon as upstream sends them
+			err = w.Flush()
+			if err != nil {
+				return
+			}
+		}
+		if errors.Is(readErr, io.EOF) {
+			break
+		}
+		if readErr != nil {
+			// Status was already sent, leaving body unfinished tells client response is incomplete
+			return
+		}
+	}
+
+	if upstreamResp.ContentLength < 0 {
+		for _, name := range trailerNames {
+			if value, exists := upstreamResp.Trailers.Get(name); exists {
+				w.SetTrailer(name, value)
+			}
+		}
+	}
+	body.Close()
+}
+
+func hasBody(method string, statusCode response.StatusCode) bool {
+	if method == "HEAD" {
+		return false
+	}
+	return statusCode >= 200 && statusCode != 204 && statusCode != 304
+}
+
+func writeUpstreamError(w *response.Writer, req *request.Request, err error) {
+	req.Logger().Warn("upstream request failed", "error", err)
+	if client.IsTimeout(err) {
+		server.HandlerError{StatusCode: response.GatewayTimeoutStatusCode, Message: "upstream timed out"}.Write(w)
+		return
+	}
+	server.HandlerError{StatusCode: response.BadGatewayStatusCode, Message: "upstream unavailable"}.Write(w)
+}
+
+// connectionHeaders returns hop-by-hop headers and headers listed in Connection header
+func connectionHeaders(connection string) []string {
+	names := append([]string(nil), hopByHopHeaders...)
+	for _, name := range strings.Split(connection, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func copyRequestHeaders(dst headers.Headers, src headers.Headers) {
+	connection, _ := src.Get("Connection")
+	skip := connectionHeaders(connection)
+	skip = append(skip, "Host", "Content-Length")
+	for name, value := range src {
+		if containsFold(skip, name) {
+			continue
+		}
+		dst.Set(name, value)
+	}
+}
+
+func copyResponseHeaders(dst headers.Headers, src headers.Headers) {
+	connection, _ := src.Get("Connection")
+	skip := connectionHeaders(connection)
+	skip = append(skip, "Content-Length")
+	for name, value := range src {
+		if containsFold(skip, name) {
+			continue
+		}
+		dst.Set(name, value)
+	}
+}
+
+// setForwardedHeaders adds client information to X-Forwarded-* and Forwarded headers
+func setForwardedHeaders(h headers.Headers, req *request.Request) {
+	host, _ := req.Headers.Get("Host")
+	clientIP := ""
+	if req.RemoteAddr != "" {
+		ip, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err == nil {
+			clientIP = ip
+		}
+	}
+
+	forwarded := "proto=http"
+	if clientIP != "" {
+		if prior, exists := h.Get("X-Forwarded-For"); exists && prior != "" {
+			h.Set("X-Forwarded-For", prior+", "+clientIP)
+		} else {
+			h.Set("X-Forwarded-For", clientIP)
+		}
+		node := clientIP
+		if strings.Contains(clientIP, ":") {
+			node = `"[` + clientIP + `]"`
+		}
+		forwarded = "for=" + node + ";" + forwarded
+	}
+	if host != "" {
+		h.Set("X-Forwarded-Host", host)
+		forwarded += ";host=" + quoteForwarded(host)
+	}
+	h.Set("X-Forwarded-Proto", "http")
+
+	if prior, exists := h.Get("Forwarded"); exists && prior != "" {
+		forwarded = prior + ", " + forwarded
+	}
+	h.Set("Forwarded", forwarded)
+}
+
+// quoteForwarded quotes value when it isn't valid token, e.g. host with port
+func quoteForwarded(value string) string {
+	for _, c := range value {
+		isToken := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+		if !isToken {
+			return strconv.Quote(value)
+		}
+	}
+	return value
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startProxy runs server proxying to upstream and returns its base url
+func startProxy(t *testing.T, upstream string, options Options) string {
+	t.Helper()
+	p, err := New(upstream, options)
+	require.NoError(t, err)
+	srv, err := server.Serve(0, p.Serve)
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+	return "http://127.0.0.1:" + strconv.Itoa(srv.Addr().(*net.TCPAddr).Port)
+}
+
+func TestProxyPassThrough(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Path", r.URL.RequestURI())
+		w.Header().Set("X-Body", string(body))
+		w.Header().Set("X-Seen-Forwarded-For", r.Header.Get("X-Forwarded-For"))
+		w.Header().Set("X-Seen-Forwarded-Host", r.Header.Get("X-Forwarded-Host"))
+		w.Header().Set("X-Seen-Forwarded-Proto", r.Header.Get("X-Forwarded-Proto"))
+		w.Header().Set("X-Seen-Forwarded", r.Header.Get("Forwarded"))
+		w.Header().Set("X-Seen-Custom", r.Header.Get("X-Custom"))
+		w.Header().Set("X-Seen-Private", r.Header.Get("X-Private"))
+		w.Header().Set("X-Seen-Keep-Alive", r.Header.Get("Keep-Alive"))
+		// Hop-by-hop headers of upstream response
+		w.Header().Set("Connection", "X-Upstream-Private")
+		w.Header().Set("X-Upstream-Private", "secret")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer upstream.Close()
+	proxyURL := startProxy(t, upstream.URL+"/api", Options{StripPrefix: "/backend"})
+
+	req, err := http.NewRequest("POST", proxyURL+"/backend/items?id=7", strings.NewReader("payload"))
+	require.NoError(t, err)
+	req.Host = "public.example"
+	req.Header.Set("X-Custom", "value")
+	req.Header.Set("Connection", "X-Private")
+	req.Header.Set("X-Private", "hidden")
+	req.Header.Set("Keep-Alive", "timeout=5")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	// Test: Status, body and headers of upstream
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, `{"ok":true}`, string(body))
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Empty(t, resp.Header.Get("X-Upstream-Private"))
+
+	// Test: Method, path, query and body forwarded
+	assert.Equal(t, "POST", resp.Header.Get("X-Method"))
+	assert.Equal(t, "/api/items?id=7", resp.Header.Get("X-Path"))
+	assert.Equal(t, "payload", resp.Header.Get("X-Body"))
+
+	// Test: Hop-by-hop headers dropped, end-to-end kept
+	assert.Equal(t, "value", resp.Header.Get("X-Seen-Custom"))
+	assert.Empty(t, resp.Header.Get("X-Seen-Private"))
+	assert.Empty(t, resp.Header.Get("X-Seen-Keep-Alive"))
+
+	// Test: Forwarding headers
+	assert.Equal(t, "127.0.0.1", resp.Header.Get("X-Seen-Forwarded-For"))
+	assert.Equal(t, "public.example", resp.Header.Get("X-Seen-Forwarded-Host"))
+	assert.Equal(t, "http", resp.Header.Get("X-Seen-Forwarded-Proto"))
+	assert.Equal(t, "for=127.0.0.1;proto=http;host=public.example", resp.Header.Get("X-Seen-Forwarded"))
+}
+
+func TestProxyStreaming(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Write([]byte("first"))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("second"))
+		w.Header().Set("X-Checksum", "abc")
+	}))
+	defer upstream.Close()
+	defer close(release)
+	proxyURL := startProxy(t, upstream.URL, Options{})
+
+	resp, err := http.Get(proxyURL + "/stream")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+
+	// First part arrives before upstream finished
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(resp.Body, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(buf))
+
+	release <- struct{}{}
+	rest, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(rest))
+	assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+}
+
+func TestProxyNoBody(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cached" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Length", "4")
+		w.Write([]byte("body"))
+	}))
+	defer upstream.Close()
+	proxyURL := startProxy(t, upstream.URL, Options{})
+
+	// Test: HEAD keeps upstream Content-Length
+	resp, err := http.Head(proxyURL + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int64(4), resp.ContentLength)
+
+	// Test: Not Modified
+	resp, err = http.Get(proxyURL + "/cached")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Empty(t, body)
+}
+
+func TestProxyUpstreamErrors(t *testing.T) {
+	// Test: Upstream not listening
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddress := listener.Addr().String()
+	listener.Close()
+	proxyURL := startProxy(t, "http://"+closedAddress, Options{})
+	resp, err := http.Get(proxyURL + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+
+	// Test: Upstream too slow
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	proxyURL = startProxy(t, slow.URL, Options{ResponseTimeout: 100 * time.Millisecond})
+	resp, err = http.Get(proxyURL + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New("127.0.0.1:8080", Options{})
+	assert.Error(t, err)
+	_, err = New("ftp://example.com", Options{})
+	assert.Error(t, err)
+
+	p, err := New("http://example.com/base/?key=1", Options{})
+	require.NoError(t, err)
+	upstreamURL, err := p.upstreamURL(p.pool.backends[0].url, "/path?q=2")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/base/path?key=1&q=2", upstreamURL)
+
+	_, err = p.upstreamURL(p.pool.backends[0].url, "example.com:443")
+	assert.Error(t, err)
+}
+
+func TestUpstreamURLStripPrefix(t *testing.T) {
+	p, err := New("http://example.com/base", Options{StripPrefix: "/api"})
+	require.NoError(t, err)
+	target := p.pool.backends[0].url
+
+	// Test: Prefix removed at segment boundary
+	upstreamURL, err := p.upstreamURL(target, "/api/users?id=1")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/base/users?id=1", upstreamURL)
+	upstreamURL, err = p.upstreamURL(target, "/api")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/base", upstreamURL)
+
+	// Test: Path only sharing characters with prefix is outside of it
+	_, err = p.upstreamURL(target, "/apiary")
+	assert.Error(t, err)
+}
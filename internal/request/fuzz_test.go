@@ -0,0 +1,130 @@
+package request
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fuzzRequestSeeds = []string{
+	"GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+	"POST /submit HTTP/1.1\r\nHost: localhost\r\nContent-Length: 13\r\n\r\nhello world!\n",
+	"POST /chunks HTTP/1.1\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n5;ext=1\r\nhello\r\n0\r\nX-Sum: 1\r\n\r\n",
+	"GET /a HTTP/1.1\r\n\r\nGET /b HTTP/1.1\r\n\r\n",
+	"GET / HTTP/1.1\r\nX: a\r\nx: b\r\nX:c\r\n\r\n",
+	// Adversarial
+	"POST / HTTP/1.1\r\nContent-Length: 99999999999999999999\r\n\r\n",
+	"POST / HTTP/1.1\r\nContent-Length: 1000000000\r\n\r\nsmall",
+	"POST / HTTP/1.1\r\nContent-Length: 3\r\nContent-Length: 3\r\n\r\nabc",
+	"POST / HTTP/1.1\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+	"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nffffffffffffffff\r\n",
+	"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n1\r\nab\r\n",
+	"GET / HTTP/1.1\r\nHost : x\r\n\r\n",
+	"GET / HTTP/1.1\r\n\x00: x\r\n\r\n",
+	"GET /\r HTTP/1.1\r\n\r\n",
+	"GET / HTTP/1.1\n\n",
+	"\r\n\r\n",
+	"GET / HTTP/1.1\r\nX: " + strings.Repeat("a", 10000) + "\r\n\r\n",
+}
+
+// serialize writes parsed request back in HTTP/1.1 format, body is sent with Content-Length
+func serialize(r *Request) string {
+	var out strings.Builder
+	out.WriteString(r.RequestLine.Method + " " + r.RequestLine.RequestTarget + " HTTP/" + r.RequestLine.HttpVersion + "\r\n")
+	for name, value := range r.Headers {
+		if name == "content-length" || name == "transfer-encoding" {
+			continue
+		}
+		out.WriteString(name + ": " + value + "\r\n")
+	}
+	out.WriteString("Content-Length: " + strconv.Itoa(len(r.Body)) + "\r\n\r\n")
+	out.Write(r.Body)
+	return out.String()
+}
+
+// withoutFraming returns headers without fields describing body framing. Value joined from
+// repeated empty fields ends with ", " and loses the space when reparsed, so values are trimmed.
+func withoutFraming(h headers.Headers) headers.Headers {
+	rest := headers.NewHeaders()
+	for name, value := range h {
+		if name != "content-length" && name != "transfer-encoding" {
+			rest[name] = strings.TrimSpace(value)
+		}
+	}
+	return rest
+}
+
+func FuzzRequestFromReader(f *testing.F) {
+	for _, seed := range fuzzRequestSeeds {
+		f.Add(seed, uint8(0))
+		f.Add(seed, uint8(6))
+	}
+	f.Fuzz(func(t *testing.T, data string, numBytesPerRead uint8) {
+		reader := &chunkReader{data: data, numBytesPerRead: int(numBytesPerRead) + 1}
+		r, err := RequestFromReader(reader)
+		if err != nil {
+			return
+		}
+
+		// Memory stays bounded by input, large Content-Length alone doesn't allocate
+		require.LessOrEqual(t, len(r.Body), len(data))
+		require.LessOrEqual(t, cap(r.Body), max(len(data), maxBodyPrealloc))
+		require.LessOrEqual(t, len(r.Buffered()), len(data))
+		for name, value := range r.Headers {
+			require.True(t, headers.IsValidHeaderName(name))
+			require.Equal(t, strings.ToLower(name), name)
+			require.NotContains(t, value, "\r\n")
+			require.False(t, strings.HasPrefix(value, " "))
+		}
+		// Bytes past the request are left as they were sent
+		rest := unread(r, reader)
+		require.LessOrEqual(t, len(rest), len(data))
+		assert.Equal(t, data[len(data)-len(rest):], rest)
+
+		// Serialized request parses into the same request
+		reparsed, err := RequestFromReader(strings.NewReader(serialize(r)))
+		require.NoError(t, err)
+		assert.Equal(t, r.RequestLine, reparsed.RequestLine)
+		assert.Equal(t, withoutFraming(r.Headers), withoutFraming(reparsed.Headers))
+		assert.Equal(t, r.Body, reparsed.Body)
+		assert.Empty(t, reparsed.Buffered())
+	})
+}
+
+func FuzzRequestLineFromString(f *testing.F) {
+	for _, seed := range []string{
+		"GET / HTTP/1.1",
+		"OPTIONS * HTTP/1.1",
+		"CONNECT example.com:443 HTTP/1.1",
+		"GET http://example.com/a?b=c#d HTTP/1.1",
+		"get / HTTP/1.1",
+		"GET / HTTP/1.0",
+		"GET  / HTTP/1.1",
+		"GET / HTTP/1.1 ",
+		"GET / HTTP/1/1",
+		"ÉTÉ / HTTP/1.1",
+		"G\xffT / HTTP/1.1",
+		" / HTTP/1.1",
+		"",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		requestLine, err := requestLineFromString(line)
+		if err != nil {
+			return
+		}
+		require.Equal(t, "1.1", requestLine.HttpVersion)
+		require.Equal(t, strings.ToUpper(requestLine.Method), requestLine.Method)
+		require.NotContains(t, requestLine.Method+requestLine.RequestTarget, " ")
+
+		// Formatted line parses into the same request line
+		reparsed, err := requestLineFromString(requestLine.Method + " " + requestLine.RequestTarget + " HTTP/1.1")
+		require.NoError(t, err)
+		assert.Equal(t, requestLine, reparsed)
+	})
+}
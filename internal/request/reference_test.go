@@ -0,0 +1,431 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Reference parser is the original straightforward implementation of RequestFromReader:
+// 8 byte buffer doubled when needed, string conversions and splitting of every line.
+// It is kept only for tests, fuzz targets and benchmarks check the optimized parser against it.
+
+const referenceBufferSize = 8
+
+func referenceRequestFromReader(reader io.Reader) (*Request, error) {
+	databuffor := make([]byte, referenceBufferSize, referenceBufferSize)
+	readToIndex := 0
+	received := 0
+	readingRequest := &Request{
+		ParsingState: Initialized,
+		Headers:      headers.NewHeaders(),
+		Body:         make([]byte, 0),
+	}
+
+	for readingRequest.ParsingState != Done {
+
+		if readToIndex >= len(databuffor) {
+			newBuffor := make([]byte, 2*len(databuffor))
+			copy(newBuffor, databuffor)
+			databuffor = newBuffor
+		}
+
+		numOfBytesRead, readError := reader.Read(databuffor[readToIndex:])
+		received += numOfBytesRead
+
+		endReadIndex := readToIndex + numOfBytesRead
+		readToIndex = endReadIndex
+
+		numOfParsedBytes, parseError := referenceParse(readingRequest, databuffor[:endReadIndex])
+		if parseError != nil {
+			return nil, parseError
+		}
+
+		copy(databuffor, databuffor[numOfParsedBytes:])
+		readToIndex -= numOfParsedBytes
+
+		if readError != nil && readingRequest.ParsingState != Done {
+			if errors.Is(readError, io.EOF) {
+				if received == 0 {
+					return nil, io.EOF
+				}
+				return readingRequest, fmt.Errorf("unexpected end of data: request incomplete")
+			}
+			return nil, readError
+		}
+	}
+
+	if readToIndex > 0 {
+		readingRequest.buffered = append([]byte(nil), databuffor[:readToIndex]...)
+	}
+	return readingRequest, nil
+}
+
+func referenceParse(r *Request, data []byte) (int, error) {
+	totalBytesParsed := 0
+	for r.ParsingState != Done {
+		numOfBytes, err := referenceParseSingle(r, data[totalBytesParsed:])
+		if err != nil {
+			return 0, err
+		}
+		totalBytesParsed += numOfBytes
+		if numOfBytes == 0 {
+			break
+		}
+	}
+	return totalBytesParsed, nil
+}
+
+func referenceParseSingle(r *Request, data []byte) (int, error) {
+	switch r.ParsingState {
+
+	case Initialized:
+		numOfBytes, requestLine, err := referenceParseRequestLine(data)
+		if err != nil {
+			return 0, err
+		}
+		if numOfBytes == 0 {
+			return 0, nil
+		}
+		r.RequestLine = *requestLine
+		r.ParsingState = ParsingHeaders
+		return numOfBytes, nil
+
+	case ParsingHeaders:
+		numOfBytes, done, err := referenceParseHeaders(r.Headers, data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			r.ParsingState = ParsingBody
+		}
+		return numOfBytes, nil
+
+	case ParsingBody:
+		contentLengthValue, contentLengthExists := r.Headers.Get("Content-Length")
+		if transferEncoding, exists := r.Headers.Get("Transfer-Encoding"); exists {
+			if !strings.EqualFold(strings.TrimSpace(transferEncoding), "chunked") {
+				return 0, fmt.Errorf("unsupported transfer-encoding: %s", transferEncoding)
+			}
+			if contentLengthExists {
+				return 0, fmt.Errorf("request has both transfer-encoding and content-length")
+			}
+			r.ParsingState = ParsingChunkSize
+			return referenceParseSingle(r, data)
+		}
+		if !contentLengthExists {
+			r.ParsingState = Done
+			return 0, nil
+		}
+
+		// Only digits, no sign (RFC 9110 section 8.6)
+		if strings.Trim(contentLengthValue, "0123456789") != "" {
+			return 0, fmt.Errorf("malformed Content-Length: %s", contentLengthValue)
+		}
+		contentLengthInt, err := strconv.Atoi(contentLengthValue)
+		if err != nil {
+			return 0, fmt.Errorf("malformed Content-Length: %s", err)
+		}
+		remaining := contentLengthInt - len(r.Body)
+		if len(data) > remaining {
+			data = data[:remaining]
+		}
+		r.Body = append(r.Body, data...)
+		r.bodyLengthRead += len(data)
+		if len(r.Body) == contentLengthInt {
+			r.ParsingState = Done
+		}
+		return len(data), nil
+
+	case ParsingChunkSize:
+		idx := bytes.Index(data, []byte(crlf))
+		if idx == -1 {
+			return 0, nil
+		}
+		chunkSize, err := referenceParseChunkSize(data[:idx])
+		if err != nil {
+			return 0, err
+		}
+		if chunkSize == 0 {
+			r.ParsingState = ParsingTrailers
+		} else {
+			r.chunkRemaining = chunkSize
+			r.ParsingState = ParsingChunkData
+		}
+		return idx + len(crlf), nil
+
+	case ParsingChunkData:
+		n := int64(len(data))
+		if n > r.chunkRemaining {
+			n = r.chunkRemaining
+		}
+		r.Body = append(r.Body, data[:n]...)
+		r.bodyLengthRead += int(n)
+		r.chunkRemaining -= n
+		if r.chunkRemaining == 0 {
+			r.ParsingState = ParsingChunkDataEnd
+		}
+		return int(n), nil
+
+	case ParsingChunkDataEnd:
+		if len(data) < len(crlf) {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte(crlf)) {
+			return 0, fmt.Errorf("malformed chunk: missing CRLF after chunk data")
+		}
+		r.ParsingState = ParsingChunkSize
+		return len(crlf), nil
+
+	case ParsingTrailers:
+		if r.Trailers == nil {
+			r.Trailers = headers.NewHeaders()
+		}
+		numOfBytes, done, err := referenceParseHeaders(r.Trailers, data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			for name := range r.Trailers {
+				if headers.IsForbiddenTrailer(name) {
+					delete(r.Trailers, name)
+				}
+			}
+			r.ParsingState = Done
+		}
+		return numOfBytes, nil
+
+	default:
+		return 0, fmt.Errorf("error: trying to read data in a done state")
+	}
+}
+
+func referenceParseChunkSize(line []byte) (int64, error) {
+	sizePart, _, _ := bytes.Cut(line, []byte(";"))
+	sizePart = bytes.TrimRight(sizePart, " \t")
+	if len(sizePart) == 0 {
+		return 0, fmt.Errorf("malformed chunk size: empty")
+	}
+	for _, c := range sizePart {
+		isHex := (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+		if !isHex {
+			return 0, fmt.Errorf("malformed chunk size: %q", sizePart)
+		}
+	}
+	return strconv.ParseInt(string(sizePart), 16, 64)
+}
+
+func referenceParseRequestLine(data []byte) (int, *RequestLine, error) {
+	idx := bytes.Index(data, []byte(crlf))
+	if idx == -1 {
+		return 0, nil, nil
+	}
+	requestLine, err := referenceRequestLineFromString(string(data[:idx]))
+	if err != nil {
+		return 0, nil, err
+	}
+	return idx + 2, requestLine, nil
+}
+
+func referenceRequestLineFromString(requestLineString string) (*RequestLine, error) {
+	requestLineParts := strings.Split(requestLineString, " ")
+	if len(requestLineParts) != 3 {
+		return nil, fmt.Errorf("poorly formatted request-line: %s", requestLineParts)
+	}
+
+	method := requestLineParts[0]
+	requestTarget := requestLineParts[1]
+	httpVersion := requestLineParts[2]
+
+	if method != strings.ToUpper(method) {
+		return nil, fmt.Errorf("invalid http method: %s", method)
+	}
+
+	httpVersionsPart := strings.Split(httpVersion, "/")
+	if len(httpVersionsPart) != 2 {
+		return nil, fmt.Errorf("malformed start-line: %s", requestLineString)
+	}
+	if httpVersionsPart[0] != "HTTP" {
+		return nil, fmt.Errorf("unrecognized HTTP-version: %s", httpVersionsPart[0])
+	}
+	if httpVersionsPart[1] != "1.1" {
+		return nil, fmt.Errorf("bad http version")
+	}
+
+	return &RequestLine{
+		HttpVersion:   httpVersionsPart[1],
+		Method:        method,
+		RequestTarget: requestTarget,
+	}, nil
+}
+
+// referenceParseHeaders is the original headers.Headers.Parse
+func referenceParseHeaders(h headers.Headers, data []byte) (int, bool, error) {
+	index := bytes.Index(data, []byte(crlf))
+	if index == -1 {
+		return 0, false, nil
+	}
+	if index == 0 {
+		return len(crlf), true, nil
+	}
+
+	parts := bytes.SplitN(data[:index], []byte(":"), 2)
+	if len(parts) != 2 {
+		return 0, false, fmt.Errorf("malformed header")
+	}
+	if strings.HasSuffix(string(parts[0]), " ") {
+		return 0, false, fmt.Errorf("whitespace between field name and colon detected. Malformed header")
+	}
+	name := string(bytes.TrimSpace(parts[0]))
+	value := string(bytes.TrimSpace(parts[1]))
+	if !referenceIsValidHeaderName(name) {
+		return 0, false, fmt.Errorf("not allowed character in header key")
+	}
+	name = strings.ToLower(name)
+
+	if _, exists := h[name]; !exists {
+		h[name] = value
+	} else {
+		h[name] += fmt.Sprintf(", %s", value)
+	}
+	return index + len(crlf), false, nil
+}
+
+func referenceIsValidHeaderName(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for _, r := range s {
+		if r > 127 || r <= 31 || r == 127 || r == ' ' {
+			return false
+		}
+		if strings.ContainsRune("()<>@,;:\\\"/[]?={} ", r) {
+			return false
+		}
+	}
+	return true
+}
+
+var referenceCorpus = []string{
+	"GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+	"POST /submit HTTP/1.1\r\nHost: localhost\r\nContent-Length: 13\r\n\r\nhello world!\n",
+	"POST /submit HTTP/1.1\r\nContent-Length: 5\r\n\r\nhelloGET /next HTTP/1.1\r\n\r\n",
+	"POST /chunks HTTP/1.1\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n5;ext=1\r\nhello\r\n0\r\nX-Sum: 1\r\nHost: x\r\n\r\nrest",
+	"GET / HTTP/1.1\r\nSet-Person: a\r\nset-person: b\r\n       Host: spaced\r\n\r\n",
+	"get / HTTP/1.1\r\n\r\n",
+	"GET  / HTTP/1.1\r\n\r\n",
+	"GET / HTTP/1.0\r\n\r\n",
+	"GET / HTTP/1.1/1\r\n\r\n",
+	" / HTTP/1.1\r\n\r\n",
+	"ÉTÉ / HTTP/1.1\r\n\r\n",
+	"G\xffT / HTTP/1.1\r\n\r\n",
+	"GET / HTTP/1.1\r\nHost : localhost\r\n\r\n",
+	"GET / HTTP/1.1\r\nH©st: localhost\r\n\r\n",
+	"GET / HTTP/1.1\r\nNo colon\r\n\r\n",
+	"GET / HTTP/1.1\r\n\tHost\t: tab\r\n\r\n",
+	"POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n",
+	"POST / HTTP/1.1\r\nContent-Length: +3\r\n\r\nabc",
+	"POST / HTTP/1.1\r\nContent-Length: -0\r\n\r\n",
+	"POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nshort",
+	"POST / HTTP/1.1\r\nContent-Length: 1\r\nTransfer-Encoding: chunked\r\n\r\n",
+	"POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n",
+	"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n8000000000000000\r\n",
+	"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n7fffffffffffffff\r\nabc",
+	"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nabc\r\n0\r\n\r\n",
+	"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n \r\n",
+	"",
+	"\r\n",
+	"GET / HTTP/1.1\r\n",
+}
+
+// unread returns bytes past the request, some of them are buffered and rest was not read yet
+func unread(r *Request, reader *chunkReader) string {
+	return string(r.Buffered()) + reader.data[reader.pos:]
+}
+
+// checkMatchesReference parses data with both parsers and fails when results differ
+func checkMatchesReference(t *testing.T, data string, numBytesPerRead int) {
+	t.Helper()
+	wantReader := &chunkReader{data: data, numBytesPerRead: numBytesPerRead}
+	want, wantErr := referenceRequestFromReader(wantReader)
+	gotReader := &chunkReader{data: data, numBytesPerRead: numBytesPerRead}
+	got, gotErr := RequestFromReader(gotReader)
+
+	if wantErr != nil {
+		require.Error(t, gotErr, "reference failed with %v", wantErr)
+		assert.Equal(t, errors.Is(wantErr, io.EOF), errors.Is(gotErr, io.EOF))
+		return
+	}
+	require.NoError(t, gotErr)
+	assert.Equal(t, want.RequestLine, got.RequestLine)
+	assert.Equal(t, want.Headers, got.Headers)
+	assert.Equal(t, want.Body, got.Body)
+	assert.Equal(t, want.Trailers, got.Trailers)
+	assert.Equal(t, unread(want, wantReader), unread(got, gotReader))
+}
+
+func TestParserMatchesReference(t *testing.T) {
+	for _, data := range referenceCorpus {
+		for _, numBytesPerRead := range []int{1, 3, 8, 64, 8192} {
+			checkMatchesReference(t, data, numBytesPerRead)
+		}
+	}
+
+	// Test: Head longer than pooled buffer
+	long := "GET /" + strings.Repeat("a", 3*readBufferSize) + " HTTP/1.1\r\nX-Long: " + strings.Repeat("b", 2*readBufferSize) + "\r\n\r\n"
+	checkMatchesReference(t, long, 1000)
+}
+
+func FuzzParserMatchesReference(f *testing.F) {
+	for _, data := range referenceCorpus {
+		f.Add(data, uint8(3))
+	}
+	f.Fuzz(func(t *testing.T, data string, numBytesPerRead uint8) {
+		checkMatchesReference(t, data, int(numBytesPerRead)+1)
+	})
+}
+
+var benchmarkRequests = map[string]string{
+	"get": "GET /api/items?page=2 HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: Mozilla/5.0 (X11; Linux x86_64)\r\n" +
+		"Accept: text/html,application/xhtml+xml\r\nAccept-Encoding: gzip, deflate, br\r\nAccept-Language: en-US,en;q=0.9\r\n" +
+		"Connection: keep-alive\r\nCookie: session=abc123\r\n\r\n",
+	"post": "POST /submit HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/json\r\nContent-Length: 27\r\n\r\n" +
+		`{"name":"value","count":42}`,
+	"chunked": "POST /upload HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"10\r\n0123456789abcdef\r\n10\r\n0123456789abcdef\r\n0\r\n\r\n",
+}
+
+func BenchmarkRequestFromReader(b *testing.B) {
+	parsers := []struct {
+		name  string
+		parse func(io.Reader) (*Request, error)
+	}{
+		{"optimized", RequestFromReader},
+		{"reference", referenceRequestFromReader},
+	}
+	for _, parser := range parsers {
+		for _, name := range []string{"get", "post", "chunked"} {
+			data := []byte(benchmarkRequests[name])
+			b.Run(parser.name+"/"+name, func(b *testing.B) {
+				reader := bytes.NewReader(data)
+				b.ReportAllocs()
+				b.SetBytes(int64(len(data)))
+				for i := 0; i < b.N; i++ {
+					reader.Reset(data)
+					_, err := parser.parse(reader)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -5,8 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"github.com/MichalGul/http_server_go/internal/headers"
 )
@@ -17,15 +20,35 @@ const (
 	Initialized RequestParsingState = iota
 	ParsingHeaders
 	ParsingBody
+	ParsingChunkSize
+	ParsingChunkData
+	ParsingChunkDataEnd
+	ParsingTrailers
 	Done
 )
 
 type Request struct {
-	RequestLine    RequestLine
-	ParsingState   RequestParsingState
-	Headers        headers.Headers
-	Body           []byte
+	RequestLine  RequestLine
+	ParsingState RequestParsingState
+	Headers      headers.Headers
+	Body         []byte
+	// Trailers holds fields sent after chunked body, fields not allowed in trailers are dropped
+	Trailers headers.Headers
+	// RemoteAddr is client address (ip:port) set by server, empty when request wasn't read from network
+	RemoteAddr string
+	// ID identifies request in logs, server takes it from X-Request-ID header or generates one
+	ID string
+	// Route is pattern of server.Router which matched request, empty when no route matched
+	Route          string
+	logger         *slog.Logger
 	bodyLengthRead int
+	// length of data already searched for end of request line or header section
+	scanned int
+	// bytes read from reader after end of request
+	buffered       []byte
+	chunkRemaining int64
+	// longest body accepted, 0 means no limit
+	maxBodySize int64
 }
 
 type RequestLine struct {
@@ -51,6 +74,12 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	switch r.ParsingState {
 
 	case Initialized:
+		// Only bytes which arrived since last attempt are searched, long line isn't rescanned on every read
+		if bytes.Index(data[min(r.scanned, len(data)):], []byte(crlf)) == -1 {
+			r.scanned = max(len(data)-len(crlf)+1, 0)
+			return 0, nil
+		}
+		r.scanned = 0
 		numOfBytes, requestLine, err := parseRequestLine(data)
 		if err != nil {
 			return 0, err
@@ -68,7 +97,13 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		return numOfBytes, nil
 
 	case ParsingHeaders:
-		numOfBytes, done, err := r.Headers.Parse(data)
+		// Section is parsed once it is complete, until then only new bytes are searched for its end
+		if !bytes.HasPrefix(data, []byte(crlf)) && bytes.Index(data[min(r.scanned, len(data)):], headerSectionEnd) == -1 {
+			r.scanned = max(len(data)-len(headerSectionEnd)+1, 0)
+			return 0, nil
+		}
+		r.scanned = 0
+		numOfBytes, done, err := r.Headers.ParseSection(data)
 		if err != nil {
 			return 0, err
 		}
@@ -79,30 +114,111 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 
 	case ParsingBody:
 		contentLengthValue, contentLengthExists := r.Headers.Get(contentHeader)
+		if transferEncoding, exists := r.Headers.Get(transferEncodingHeader); exists {
+			if !strings.EqualFold(strings.TrimSpace(transferEncoding), "chunked") {
+				return 0, fmt.Errorf("unsupported transfer-encoding: %s", transferEncoding)
+			}
+			// Both framings present is a request smuggling attempt
+			if contentLengthExists {
+				return 0, fmt.Errorf("request has both transfer-encoding and content-length")
+			}
+			r.ParsingState = ParsingChunkSize
+			return r.parseSingle(data)
+		}
 		if !contentLengthExists {
-			// No body finish parsing
+			// No body finish parsing, remaining data belongs to whatever follows the request
 			r.ParsingState = Done
-			return len(data), nil
+			return 0, nil
 		}
 
-		contentLengthInt, err := strconv.Atoi(contentLengthValue)
+		contentLengthInt, err := parseContentLength(contentLengthValue)
 		if err != nil {
-			return 0, fmt.Errorf("malformed Content-Length: %s", err)
+			return 0, err
+		}
+		// Rejected before any body byte is read
+		if r.maxBodySize > 0 && int64(contentLengthInt) > r.maxBodySize {
+			return 0, ErrBodyTooLarge
+		}
+		if cap(r.Body) == 0 {
+			// Large Content-Length alone should not make server allocate, body grows as it arrives then
+			r.Body = make([]byte, 0, min(contentLengthInt, maxBodyPrealloc))
+		}
+		// Appending data to body, bytes past Content-Length are not part of this request
+		remaining := contentLengthInt - len(r.Body)
+		if len(data) > remaining {
+			data = data[:remaining]
 		}
-		// Appending remaining data to body
 		r.Body = append(r.Body, data...)
 		r.bodyLengthRead += len(data)
 
-		if len(r.Body) > contentLengthInt {
-			return 0, fmt.Errorf("request body greater than content-length header")
-		}
-
 		if len(r.Body) == contentLengthInt {
 			r.ParsingState = Done
 		}
 
 		return len(data), nil
 
+	case ParsingChunkSize:
+		idx := bytes.Index(data, []byte(crlf))
+		if idx == -1 {
+			return 0, nil
+		}
+		chunkSize, err := headers.ParseChunkSize(data[:idx])
+		if err != nil {
+			return 0, err
+		}
+		if chunkSize == 0 {
+			// Last chunk, trailer section follows
+			r.ParsingState = ParsingTrailers
+		} else {
+			if r.maxBodySize > 0 && chunkSize > r.maxBodySize-int64(r.bodyLengthRead) {
+				return 0, ErrBodyTooLarge
+			}
+			r.chunkRemaining = chunkSize
+			r.ParsingState = ParsingChunkData
+		}
+		return idx + len(crlf), nil
+
+	case ParsingChunkData:
+		n := int64(len(data))
+		if n > r.chunkRemaining {
+			n = r.chunkRemaining
+		}
+		r.Body = append(r.Body, data[:n]...)
+		r.bodyLengthRead += int(n)
+		r.chunkRemaining -= n
+		if r.chunkRemaining == 0 {
+			r.ParsingState = ParsingChunkDataEnd
+		}
+		return int(n), nil
+
+	case ParsingChunkDataEnd:
+		if len(data) < len(crlf) {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte(crlf)) {
+			return 0, fmt.Errorf("malformed chunk: missing CRLF after chunk data")
+		}
+		r.ParsingState = ParsingChunkSize
+		return len(crlf), nil
+
+	case ParsingTrailers:
+		if r.Trailers == nil {
+			r.Trailers = headers.NewHeaders()
+		}
+		numOfBytes, done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			for name := range r.Trailers {
+				if headers.IsForbiddenTrailer(name) {
+					delete(r.Trailers, name)
+				}
+			}
+			r.ParsingState = Done
+		}
+		return numOfBytes, nil
+
 	case Done:
 		return 0, fmt.Errorf("error: trying to read data in a done state")
 
@@ -136,113 +252,198 @@ func (r *Request) parse(data []byte) (int, error) {
 }
 
 const crlf = "\r\n"
-const streamBufferSize = 8
+
+// Size of pooled read buffer, requests with longer head get bigger buffer which is not pooled
+const readBufferSize = 4096
+
+// Body capacity allocated upfront from Content-Length
+const maxBodyPrealloc = 64 << 10
+
+// Longest request line and header section together, read buffer never grows past it
+const maxHeaderSize = 1 << 20
+
+// ErrHeaderTooLarge is returned (wrapped in ParseError) when request line and headers exceed maxHeaderSize
+var ErrHeaderTooLarge = errors.New("request header section too large")
+
+// ErrBodyTooLarge is returned (wrapped in ParseError) when body is longer than limit passed to RequestFromReaderLimit
+var ErrBodyTooLarge = errors.New("request body too large")
+
+var headerSectionEnd = []byte("\r\n\r\n")
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, readBufferSize)
+		return &buf
+	},
+}
+
+const transferEncodingHeader = "Transfer-Encoding"
+
+// parseContentLength accepts only ASCII digits (RFC 9110 section 8.6), strconv.Atoi would let
+// signs like +5 or -0 through and different parsers could then disagree on body length
+func parseContentLength(value string) (int, error) {
+	if value == "" {
+		return 0, fmt.Errorf("malformed Content-Length: empty")
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return 0, fmt.Errorf("malformed Content-Length: %s", value)
+		}
+	}
+	length, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("malformed Content-Length: %s out of range", value)
+	}
+	return length, nil
+}
 
 func parseRequestLine(data []byte) (int, *RequestLine, error) {
 
 	// Find endline /r/n so everything until first CR on http request
 	idx := bytes.Index(data, []byte(crlf))
 	if idx == -1 {
-		// return nil, fmt.Errorf("could not find CRLF in request-line")\
 		return 0, nil, nil // needs more byte to read
 	}
-	requestLineText := string(data[:idx])
-
-	// Rest of message after first request line, meaning headers and body separaterd wit /r/n
-	// bytesRead := idx + len(crlf)
-	// resetOfMessage := string(data[idx + len(crlf)])
 
-	requestLine, err := requestLineFromString(requestLineText)
+	requestLine, err := requestLineFromBytes(data[:idx])
 	if err != nil {
 		return 0, nil, err
 	}
-	return idx + 2, requestLine, nil
+	return idx + 2, &requestLine, nil
 }
 
 // Parse http request as string to RequestLine object
 // Perform structure checs for HTTP request standard
 func requestLineFromString(requestLineString string) (*RequestLine, error) {
-
-	fmt.Printf("requestLineRaw: %s \n", requestLineString)
-
-	requestLineParts := strings.Split(requestLineString, " ")
-
-	if len(requestLineParts) != 3 {
-		return nil, fmt.Errorf("poorly formatted request-line: %s", requestLineParts)
+	requestLine, err := requestLineFromBytes([]byte(requestLineString))
+	if err != nil {
+		return nil, err
 	}
+	return &requestLine, nil
+}
 
-	method := requestLineParts[0]
-	requestTarget := requestLineParts[1]
-	httpVersion := requestLineParts[2]
+// requestLineFromBytes splits request line on single spaces into method, target and version,
+// only target is copied, method is interned for standard methods
+func requestLineFromBytes(line []byte) (RequestLine, error) {
 
-	if method != strings.ToUpper(method) {
-		return nil, fmt.Errorf("invalid http method: %s", method)
+	methodEnd := bytes.IndexByte(line, ' ')
+	if methodEnd == -1 {
+		return RequestLine{}, fmt.Errorf("poorly formatted request-line: %q", line)
+	}
+	targetEnd := bytes.IndexByte(line[methodEnd+1:], ' ')
+	if targetEnd == -1 {
+		return RequestLine{}, fmt.Errorf("poorly formatted request-line: %q", line)
+	}
+	targetEnd += methodEnd + 1
+	if bytes.IndexByte(line[targetEnd+1:], ' ') != -1 {
+		return RequestLine{}, fmt.Errorf("poorly formatted request-line: %q", line)
 	}
 
-	httpVersionsPart := strings.Split(httpVersion, "/")
+	method := line[:methodEnd]
+	requestTarget := line[methodEnd+1 : targetEnd]
+	httpVersion := line[targetEnd+1:]
 
-	if len(httpVersionsPart) != 2 {
-		return nil, fmt.Errorf("malformed start-line: %s", requestLineString)
+	if !isUpper(method) {
+		return RequestLine{}, fmt.Errorf("invalid http method: %s", method)
 	}
 
-	httpPart := httpVersionsPart[0]
-	if httpPart != "HTTP" {
-		return nil, fmt.Errorf("unrecognized HTTP-version: %s", httpPart)
+	if !bytes.HasPrefix(httpVersion, []byte("HTTP/")) || bytes.IndexByte(httpVersion[len("HTTP/"):], '/') != -1 {
+		return RequestLine{}, fmt.Errorf("malformed start-line: %q", line)
 	}
-
-	version := httpVersionsPart[1]
-	if version != "1.1" {
-		return nil, fmt.Errorf("bad http version")
+	if string(httpVersion) != "HTTP/1.1" {
+		return RequestLine{}, fmt.Errorf("bad http version")
 	}
 
-	fmt.Printf("Method: %s \n requestTarget: %s \n httpVersion: %s \n", method, requestTarget, httpVersion)
+	return RequestLine{
+		HttpVersion:   "1.1",
+		Method:        internMethod(method),
+		RequestTarget: string(requestTarget),
+	}, nil
+}
 
-	parsedRequestLine := RequestLine{
-		HttpVersion:   version,
-		Method:        method,
-		RequestTarget: requestTarget,
+// isUpper reports whether method is unchanged by uppercasing
+func isUpper(method []byte) bool {
+	for _, c := range method {
+		if c >= utf8.RuneSelf {
+			// Rare non-ASCII method is checked the slow way
+			return string(method) == strings.ToUpper(string(method))
+		}
+		if 'a' <= c && c <= 'z' {
+			return false
+		}
 	}
+	return true
+}
 
-	return &parsedRequestLine, nil
-
+// internMethod returns method as string without allocation for standard methods
+func internMethod(method []byte) string {
+	switch string(method) {
+	case "GET":
+		return "GET"
+	case "HEAD":
+		return "HEAD"
+	case "POST":
+		return "POST"
+	case "PUT":
+		return "PUT"
+	case "DELETE":
+		return "DELETE"
+	case "PATCH":
+		return "PATCH"
+	case "OPTIONS":
+		return "OPTIONS"
+	case "CONNECT":
+		return "CONNECT"
+	case "TRACE":
+		return "TRACE"
+	}
+	return string(method)
 }
 
 // Main method to parse incoming data through tcp connection
 // reads from io.Reader that is tcp connection or file
-// It uses []byte as buffor for data with set streamBufferSize
-// Create Request object with Init state, Check for done state in loop,
-// Read bytes from io.Reader, to buffer, acknowledge number of bytes read
-// atempt to parse bytes to RequestLine, and move buffor
+// It uses pooled []byte buffor of readBufferSize, it is doubled only when request line or single
+// header doesn't fit, up to maxHeaderSize. Parsed bytes are dropped from buffor, bytes past the request
+// are copied out to Buffered before buffor goes back to the pool.
 // readingRequest.parse determines if whole Request line and Headers was read and changes state to Done
+// When reader ends before any byte is read io.EOF is returned, connection was closed between requests.
+// Other read errors (e.g. deadline exceeded) are returned as they are.
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	return RequestFromReaderLimit(reader, 0)
+}
 
-	// Buffer chunk size to read data from stream (by streamBufferSize bytes at the time untile streaming data is finished)
-	databuffor := make([]byte, streamBufferSize, streamBufferSize)
+// RequestFromReaderLimit is RequestFromReader which fails with ErrBodyTooLarge when body
+// (Content-Length or sum of chunk sizes) is longer than maxBodySize, 0 means no limit.
+// Body is kept in memory, the limit bounds how much single request can make server allocate.
+func RequestFromReaderLimit(reader io.Reader, maxBodySize int64) (*Request, error) {
+
+	pooled := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(pooled)
+	databuffor := *pooled
 	readToIndex := 0 // track how much data read from io.Reader into the buffer
+	received := 0    // all bytes read from io.Reader
+	headRead := 0    // bytes of request line and headers parsed and dropped from buffer
 	readingRequest := &Request{
 		ParsingState: Initialized,
-		Headers:      headers.NewHeaders(),
-		Body:         make([]byte, 0),
+		Headers:      make(headers.Headers, 8),
+		Body:         []byte{},
+		maxBodySize:  maxBodySize,
 	}
 
 	for readingRequest.ParsingState != Done {
 
 		if readToIndex >= len(databuffor) { // when buffor is full
+			if len(databuffor) >= maxHeaderSize {
+				return nil, &ParseError{Kind: readingRequest.ParsingState.errorKind(), Err: readingRequest.ParsingState.tooLargeError()}
+			}
 			// make new slice with capacity x2 and copy data
-			newBuffor := make([]byte, 2*len(databuffor))
+			newBuffor := make([]byte, min(2*len(databuffor), maxHeaderSize))
 			copy(newBuffor, databuffor)
 			databuffor = newBuffor
 		}
 
 		numOfBytesRead, readError := reader.Read(databuffor[readToIndex:])
-		if readError != nil {
-			if errors.Is(readError, io.EOF) { // Read all
-				if readingRequest.ParsingState != Done {
-					return readingRequest, fmt.Errorf("unexpected end of data: request incomplete")
-				}
-				break
-			}
-		}
+		received += numOfBytesRead
 
 		endReadIndex := readToIndex + numOfBytesRead
 		readToIndex = endReadIndex
@@ -250,14 +451,103 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		// numOfParsedBytes will be 0 untile whole request line present
 		numOfParsedBytes, parseError := readingRequest.parse(databuffor[:endReadIndex])
 		if parseError != nil {
-			return nil, parseError
+			return nil, &ParseError{Kind: readingRequest.ParsingState.errorKind(), Err: parseError}
 		}
 
 		// Move past by read data, w don't need them in buffor.
-		copy(databuffor, databuffor[numOfParsedBytes:])
-		readToIndex -= numOfParsedBytes
+		if numOfParsedBytes > 0 {
+			copy(databuffor, databuffor[numOfParsedBytes:readToIndex])
+			readToIndex -= numOfParsedBytes
+		}
 
+		// Everything parsed so far belongs to head while it is still being read
+		if state := readingRequest.ParsingState; state == Initialized || state == ParsingHeaders {
+			headRead += numOfParsedBytes
+			if headRead+readToIndex > maxHeaderSize {
+				return nil, &ParseError{Kind: state.errorKind(), Err: ErrHeaderTooLarge}
+			}
+		}
+
+		if readError != nil && readingRequest.ParsingState != Done {
+			if errors.Is(readError, io.EOF) { // Read all
+				if received == 0 {
+					// Client closed connection without sending next request
+					return nil, io.EOF
+				}
+				return readingRequest, &ParseError{Kind: "incomplete", Err: errIncomplete}
+			}
+			return nil, readError
+		}
+
+	}
+
+	if readToIndex > 0 {
+		readingRequest.buffered = append([]byte(nil), databuffor[:readToIndex]...)
 	}
 
 	return readingRequest, nil
 }
+
+// discardLogger is used by requests which were not read by server
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// Logger returns logger set by server, its entries carry connection and request fields.
+// It is never nil, without logger set entries are discarded.
+func (r *Request) Logger() *slog.Logger {
+	if r.logger == nil {
+		return discardLogger
+	}
+	return r.logger
+}
+
+// SetLogger sets logger returned by Logger
+func (r *Request) SetLogger(logger *slog.Logger) {
+	r.logger = logger
+}
+
+// ParseError is returned for malformed or incomplete request. Kind tells what was wrong:
+// "request_line", "headers", "body", "trailers" or "incomplete" when reader ended mid-request.
+type ParseError struct {
+	Kind string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+var errIncomplete = errors.New("unexpected end of data: request incomplete")
+
+// errorKind names part of request parsed in the state
+func (s RequestParsingState) errorKind() string {
+	switch s {
+	case Initialized:
+		return "request_line"
+	case ParsingHeaders:
+		return "headers"
+	case ParsingTrailers:
+		return "trailers"
+	default:
+		return "body"
+	}
+}
+
+// tooLargeError is error for element parsed in the state which doesn't fit read buffer
+func (s RequestParsingState) tooLargeError() error {
+	switch s {
+	case Initialized, ParsingHeaders, ParsingTrailers:
+		return ErrHeaderTooLarge
+	default:
+		return errors.New("chunk size line too long")
+	}
+}
+
+// Buffered returns bytes which were read from reader after the end of request,
+// they belong to whatever client sent next (e.g. data of upgraded protocol)
+func (r *Request) Buffered() []byte {
+	return r.buffered
+}
@@ -1,10 +1,13 @@
 package request
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -236,3 +239,236 @@ func TestRequestParsingBody(t *testing.T) {
 	assert.Equal(t, 0, len(r.Body))
 
 }
+
+func TestRequestParsingChunkedBody(t *testing.T) {
+
+	// Test: Chunked body with trailers
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Checksum\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"7;ext=1\r\n world!\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc\r\n" +
+			"Content-Length: 12\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello world!", string(r.Body))
+	assert.Equal(t, "abc", r.Trailers["x-checksum"])
+	// Forbidden trailer fields are dropped
+	_, exists := r.Trailers.Get("Content-Length")
+	assert.False(t, exists)
+
+	// Test: Chunked body without trailers
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"A\r\n0123456789\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(r.Body))
+	assert.Equal(t, 0, len(r.Trailers))
+
+	// Test: Invalid chunk size
+	reader = &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Chunk data longer than chunk size
+	reader = &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Both Transfer-Encoding and Content-Length
+	reader = &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Length: 5\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Missing last chunk
+	reader = &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+}
+
+func TestRequestBuffered(t *testing.T) {
+	// Bytes past request are either buffered or still waiting in reader
+	unparsed := func(r *Request, reader *chunkReader) string {
+		return string(r.Buffered()) + reader.data[reader.pos:]
+	}
+
+	// Test: Data after request without body
+	reader := &chunkReader{
+		data:            "GET /chat HTTP/1.1\r\nUpgrade: websocket\r\n\r\nframe data",
+		numBytesPerRead: 1024,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(r.Body))
+	assert.Equal(t, "frame data", unparsed(r, reader))
+
+	// Test: Data after Content-Length body is not part of the body
+	reader = &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nContent-Length: 5\r\n\r\nhelloGET / HTTP/1.1\r\n",
+		numBytesPerRead: 1024,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+	assert.Equal(t, "GET / HTTP/1.1\r\n", unparsed(r, reader))
+
+	// Test: Data after chunked body
+	reader = &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\nnext",
+		numBytesPerRead: 1024,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+	assert.Equal(t, "next", unparsed(r, reader))
+
+	// Test: Nothing buffered when reads stop at request end
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Empty(t, r.Buffered())
+}
+
+func TestRequestFromReaderPipelined(t *testing.T) {
+	// Test: Pipelined requests are parsed one after another from leftover bytes
+	stream := "GET /first HTTP/1.1\r\n\r\nPOST /second HTTP/1.1\r\nContent-Length: 4\r\n\r\nbodyGET /third HTTP/1.1\r\n\r\n"
+	var reader io.Reader = strings.NewReader(stream)
+	var targets []string
+	for {
+		r, err := RequestFromReader(reader)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		targets = append(targets, r.RequestLine.RequestTarget)
+		reader = io.MultiReader(bytes.NewReader(r.Buffered()), reader)
+	}
+	assert.Equal(t, []string{"/first", "/second", "/third"}, targets)
+
+	// Test: Other read errors are returned
+	_, err := RequestFromReader(iotest.TimeoutReader(strings.NewReader("GET / HTTP/1.1\r\n")))
+	assert.ErrorIs(t, err, iotest.ErrTimeout)
+}
+
+func TestRequestContentLengthDigits(t *testing.T) {
+	// Test: Sign, whitespace inside, hex or list instead of plain digits
+	for _, value := range []string{"+5", "-0", "-5", "5 5", "0x5", "5,5", "99999999999999999999"} {
+		_, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: " + value + "\r\n\r\nhello"))
+		var parseErr *ParseError
+		require.ErrorAs(t, err, &parseErr, value)
+		assert.Equal(t, "body", parseErr.Kind, value)
+	}
+
+	// Test: Leading zeros are still digits
+	r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 005\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+}
+
+func TestParseErrorKind(t *testing.T) {
+	tests := []struct {
+		data string
+		kind string
+	}{
+		{data: "GET / HTTP/2\r\n\r\n", kind: "request_line"},
+		{data: "GET / HTTP/1.1\r\nBad Header: x\r\n\r\n", kind: "headers"},
+		{data: "POST / HTTP/1.1\r\nContent-Length: x\r\n\r\n", kind: "body"},
+		{data: "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nHost : x\r\n\r\n", kind: "trailers"},
+		{data: "POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nshort", kind: "incomplete"},
+	}
+	for _, tt := range tests {
+		_, err := RequestFromReader(strings.NewReader(tt.data))
+		var parseErr *ParseError
+		require.ErrorAs(t, err, &parseErr, tt.data)
+		assert.Equal(t, tt.kind, parseErr.Kind, tt.data)
+	}
+}
+
+// endlessReader returns the same byte forever, like client which never finishes its request
+type endlessReader byte
+
+func (e endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(e)
+	}
+	return len(p), nil
+}
+
+func TestRequestHeaderTooLarge(t *testing.T) {
+	tests := []struct {
+		name   string
+		reader io.Reader
+		kind   string
+	}{
+		{name: "long request line", reader: strings.NewReader("GET /" + strings.Repeat("a", maxHeaderSize) + " HTTP/1.1\r\n\r\n"), kind: "request_line"},
+		{name: "long header", reader: &chunkReader{data: "GET / HTTP/1.1\r\nX-Big: " + strings.Repeat("a", maxHeaderSize) + "\r\n\r\n", numBytesPerRead: 1000}, kind: "headers"},
+		{name: "many headers", reader: strings.NewReader("GET / HTTP/1.1\r\n" + strings.Repeat("X-Header: value\r\n", maxHeaderSize/17+1) + "\r\n"), kind: "headers"},
+		{name: "section never ends", reader: io.MultiReader(strings.NewReader("GET / HTTP/1.1\r\nX-Endless: "), endlessReader('a')), kind: "headers"},
+		{name: "line never ends", reader: endlessReader('G'), kind: "request_line"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := RequestFromReader(tt.reader)
+			require.ErrorIs(t, err, ErrHeaderTooLarge)
+			var parseErr *ParseError
+			require.ErrorAs(t, err, &parseErr)
+			assert.Equal(t, tt.kind, parseErr.Kind)
+		})
+	}
+
+	// Test: Head just under the limit is accepted
+	value := strings.Repeat("a", maxHeaderSize/2)
+	r, err := RequestFromReader(&chunkReader{data: "GET / HTTP/1.1\r\nX-Big: " + value + "\r\n\r\n", numBytesPerRead: 1000})
+	require.NoError(t, err)
+	big, _ := r.Headers.Get("X-Big")
+	assert.Equal(t, value, big)
+}
+
+func TestRequestBodyTooLarge(t *testing.T) {
+	// Test: Content-Length over the limit is rejected before body is read
+	_, err := RequestFromReaderLimit(io.MultiReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 11\r\n\r\n"), endlessReader('a')), 10)
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, "body", parseErr.Kind)
+
+	// Test: Chunks adding up over the limit
+	_, err = RequestFromReaderLimit(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n"), 10)
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Body exactly at the limit
+	r, err := RequestFromReaderLimit(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n5\r\nworld\r\n0\r\n\r\n"), 10)
+	require.NoError(t, err)
+	assert.Equal(t, "helloworld", string(r.Body))
+}
@@ -0,0 +1,107 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrBodyLengthExceeded is returned when handler writes more than announced Content-Length
+var ErrBodyLengthExceeded = errors.New("response body longer than Content-Length")
+
+// ErrBodyClosed is returned when writing to body which was already closed
+var ErrBodyClosed = errors.New("write to closed response body")
+
+// Body returns writer for response body framed according to headers.
+// When headers were not written yet they are written now: with Content-Length from Header()
+// body length is enforced, otherwise Transfer-Encoding: chunked is set and every Write becomes a chunk.
+// Status codes without body (1xx, 204, 304) get no framing headers and body accepting no bytes.
+// Close must be called to terminate the message, for chunked body it writes last chunk and
+// trailer fields from Trailer(), for Content-Length body it reports missing bytes.
+func (w *Writer) Body() (*BodyWriter, error) {
+
+	switch w.WriteState {
+	case StatusLineWrote:
+		h := w.Header()
+		_, exists := h.Get("Content-Length")
+		if !exists && hasBody(w.statusCode) {
+			h.Del("Transfer-Encoding")
+			h.Set("Transfer-Encoding", "chunked")
+		}
+		err := w.WriteHeaders(nil)
+		if err != nil {
+			return nil, err
+		}
+	case HeadersWrote:
+	default:
+		return nil, fmt.Errorf("error: atempt to write body in incorrect state")
+	}
+
+	if !w.chunked && w.contentLength < 0 {
+		return nil, fmt.Errorf("error: response headers do not define body length")
+	}
+
+	w.WriteState = BodyWrote
+	return &BodyWriter{
+		writer:    w,
+		chunked:   w.chunked,
+		remaining: w.contentLength,
+	}, nil
+}
+
+// BodyWriter is io.WriteCloser writing response body with framing selected by Writer.Body
+type BodyWriter struct {
+	writer    *Writer
+	chunked   bool
+	remaining int64
+	closed    bool
+}
+
+func (b *BodyWriter) Write(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrBodyClosed
+	}
+	if b.chunked {
+		return b.writer.writeChunk(p)
+	}
+
+	if int64(len(p)) > b.remaining {
+		n, err := b.writer.bodyOut().Write(p[:b.remaining])
+		b.remaining -= int64(n)
+		b.writer.countBody(n)
+		if err != nil {
+			return n, err
+		}
+		return n, ErrBodyLengthExceeded
+	}
+	n, err := b.writer.bodyOut().Write(p)
+	b.remaining -= int64(n)
+	b.writer.countBody(n)
+	return n, err
+}
+
+// Close terminates the body. Calling it more than once has no effect.
+func (b *BodyWriter) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	if !b.chunked {
+		if b.remaining > 0 {
+			return fmt.Errorf("response body shorter than Content-Length, %s bytes missing", strconv.FormatInt(b.remaining, 10))
+		}
+		return nil
+	}
+
+	if b.writer.discardBody {
+		return nil
+	}
+	_, err := b.writer.out().WriteString("0" + crlf)
+	if err != nil {
+		return err
+	}
+	b.writer.trailersPending = true
+	b.writer.lastChunkWritten = true
+	return b.writer.WriteTrailers(nil)
+}
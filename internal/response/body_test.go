@@ -0,0 +1,266 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBodyChunked(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWritter(&buf)
+	require.NoError(t, w.WriteStatusLine(OkStatusCode))
+	w.Header().Set("Content-Type", "text/plain")
+
+	body, err := w.Body()
+	require.NoError(t, err)
+	_, err = io.Copy(body, strings.NewReader("hello world"))
+	require.NoError(t, err)
+	_, err = body.Write([]byte("!"))
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	require.NoError(t, w.Finish())
+
+	resp := buf.String()
+	assert.Contains(t, resp, "Transfer-Encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nb\r\nhello world\r\n1\r\n!\r\n0\r\n\r\n"))
+
+	// Test: Write after close
+	_, err = body.Write([]byte("late"))
+	assert.ErrorIs(t, err, ErrBodyClosed)
+	assert.NoError(t, body.Close())
+}
+
+func TestBodyChunkedTrailers(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWritter(&buf)
+	w.WriteStatusLine(OkStatusCode)
+	w.Header().Set("Trailer", "X-Checksum")
+
+	body, err := w.Body()
+	require.NoError(t, err)
+	body.Write([]byte("data"))
+	w.Trailer().Set("X-Checksum", "abc")
+	require.NoError(t, body.Close())
+	w.Finish()
+
+	assert.True(t, strings.HasSuffix(buf.String(), "4\r\ndata\r\n0\r\nX-Checksum: abc\r\n\r\n"))
+}
+
+func TestBodyContentLength(t *testing.T) {
+	// Test: Exact length
+	var buf bytes.Buffer
+	w := NewWritter(&buf)
+	w.WriteStatusLine(OkStatusCode)
+	w.Header().Set("Content-Length", "5")
+	body, err := w.Body()
+	require.NoError(t, err)
+	n, err := body.Write([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	require.NoError(t, body.Close())
+	w.Finish()
+	assert.NotContains(t, buf.String(), "Transfer-Encoding")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhello"))
+
+	// Test: Longer than Content-Length is truncated
+	buf.Reset()
+	w = NewWritter(&buf)
+	w.WriteStatusLine(OkStatusCode)
+	w.WriteHeaders(GetDefaultHeaders(3))
+	body, err = w.Body()
+	require.NoError(t, err)
+	n, err = body.Write([]byte("hello"))
+	assert.ErrorIs(t, err, ErrBodyLengthExceeded)
+	assert.Equal(t, 3, n)
+	w.Finish()
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhel"))
+
+	// Test: Shorter than Content-Length
+	w = NewWritter(&buf)
+	w.WriteStatusLine(OkStatusCode)
+	w.WriteHeaders(GetDefaultHeaders(10))
+	body, err = w.Body()
+	require.NoError(t, err)
+	body.Write([]byte("hello"))
+	assert.Error(t, body.Close())
+}
+
+func TestBodyStatusWithoutContent(t *testing.T) {
+	// Test: 204 gets no framing headers and body accepts no bytes
+	var buf bytes.Buffer
+	w := NewWritter(&buf)
+	w.WriteStatusLine(NoContentStatusCode)
+	w.Header().Set("Content-Length", "5")
+	body, err := w.Body()
+	require.NoError(t, err)
+	_, err = body.Write([]byte("x"))
+	assert.ErrorIs(t, err, ErrBodyLengthExceeded)
+	require.NoError(t, body.Close())
+	w.Finish()
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\n\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: 304 keeps Content-Length of representation but is not chunked
+	buf.Reset()
+	w = NewWritter(&buf)
+	w.WriteStatusLine(NotModifiedStatusCode)
+	w.Header().Set("Content-Length", "100")
+	body, err = w.Body()
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	w.Finish()
+	assert.Contains(t, buf.String(), "Content-Length: 100\r\n")
+	assert.NotContains(t, buf.String(), "Transfer-Encoding")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+}
+
+func TestBodyChunkedDropsContentLength(t *testing.T) {
+	// Test: Content-Length is never sent together with chunked encoding
+	var buf bytes.Buffer
+	w := NewWritter(&buf)
+	w.WriteStatusLine(OkStatusCode)
+	w.Header().Set("Content-Length", "5")
+	w.Header().Set("Transfer-Encoding", "chunked")
+	body, err := w.Body()
+	require.NoError(t, err)
+	body.Write([]byte("hello"))
+	require.NoError(t, body.Close())
+	w.Finish()
+	assert.NotContains(t, buf.String(), "Content-Length")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n5\r\nhello\r\n0\r\n\r\n"))
+}
+
+func TestBodyIncorrectState(t *testing.T) {
+	w := NewWritter(io.Discard)
+	_, err := w.Body()
+	require.Error(t, err)
+
+	// Test: Headers written without framing information
+	w.WriteStatusLine(OkStatusCode)
+	w.WriteHeaders(nil)
+	_, err = w.Body()
+	require.Error(t, err)
+}
+
+func TestWriteChunkedBodyDoneTerminatesMessage(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWritter(&buf)
+	w.WriteStatusLine(OkStatusCode)
+	w.WriteHeaders(map[string]string{"Transfer-Encoding": "chunked"})
+	w.WriteChunkedBody([]byte("abc"))
+	w.WriteChunkedBodyDone()
+	w.Finish()
+
+	assert.True(t, strings.HasSuffix(buf.String(), "3\r\nabc\r\n0\r\n\r\n"))
+}
+
+func TestTrailerValidation(t *testing.T) {
+	// Test: Forbidden field announced in Trailer header
+	w := NewWritter(io.Discard)
+	w.WriteStatusLine(OkStatusCode)
+	err := w.WriteHeaders(map[string]string{"Transfer-Encoding": "chunked", "Trailer": "X-Checksum, Content-Length"})
+	require.Error(t, err)
+	require.ErrorContains(t, err, "content-length")
+
+	// Test: SetTrailer before and during body
+	var buf bytes.Buffer
+	w = NewWritter(&buf)
+	w.WriteStatusLine(OkStatusCode)
+	w.Header().Set("Trailer", "X-Checksum, X-Count")
+	require.NoError(t, w.SetTrailer("X-Count", "0"))
+	assert.Error(t, w.SetTrailer("X-Unknown", "1"))
+	assert.Error(t, w.SetTrailer("Content-Type", "text/plain"))
+
+	body, err := w.Body()
+	require.NoError(t, err)
+	body.Write([]byte("abc"))
+	require.NoError(t, w.SetTrailer("x-checksum", "sum"))
+	require.NoError(t, w.SetTrailer("X-Count", "3"))
+	require.NoError(t, body.Close())
+	w.Finish()
+
+	resp := buf.String()
+	assert.Contains(t, resp, "3\r\nabc\r\n0\r\n")
+	assert.Contains(t, resp, "x-checksum: sum\r\n")
+	assert.Contains(t, resp, "X-Count: 3\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+
+	// Test: Invalid trailers passed to WriteTrailers are dropped but message is terminated
+	buf.Reset()
+	w = NewWritter(&buf)
+	w.WriteStatusLine(OkStatusCode)
+	w.WriteHeaders(map[string]string{"Transfer-Encoding": "chunked", "Trailer": "X-Checksum"})
+	w.WriteChunkedBody([]byte("abc"))
+	w.WriteChunkedBodyDone()
+	err = w.WriteTrailers(map[string]string{"X-Checksum": "sum", "Content-Length": "3", "X-Other": "1"})
+	require.Error(t, err)
+	w.Finish()
+	assert.True(t, strings.HasSuffix(buf.String(), "3\r\nabc\r\n0\r\nX-Checksum: sum\r\n\r\n"))
+}
+
+func TestWriterKeepAlive(t *testing.T) {
+	newWriter := func() *Writer {
+		w := NewWritter(io.Discard)
+		w.WriteStatusLine(OkStatusCode)
+		return w
+	}
+
+	// Test: Content-Length body written whole
+	w := newWriter()
+	w.WriteHeaders(GetDefaultHeaders(5))
+	w.WriteBody([]byte("hello"))
+	assert.True(t, w.KeepAlive())
+	assert.Equal(t, int64(5), w.BodyBytes())
+	assert.Equal(t, OkStatusCode, w.StatusCode())
+
+	// Test: Body shorter than Content-Length
+	w = newWriter()
+	w.WriteHeaders(GetDefaultHeaders(5))
+	w.WriteBody([]byte("hel"))
+	assert.False(t, w.KeepAlive())
+
+	// Test: Body without length is delimited by closing connection, which is announced
+	var out bytes.Buffer
+	w = NewWritter(&out)
+	w.WriteStatusLine(OkStatusCode)
+	w.WriteHeaders(nil)
+	w.WriteBody([]byte("hello"))
+	assert.False(t, w.KeepAlive())
+	w.Finish()
+	assert.Contains(t, out.String(), "Connection: close\r\n")
+
+	// Test: Chunked body only after last chunk
+	w = newWriter()
+	body, err := w.Body()
+	require.NoError(t, err)
+	body.Write([]byte("hello"))
+	assert.False(t, w.KeepAlive())
+	body.Close()
+	assert.True(t, w.KeepAlive())
+	assert.Equal(t, int64(5), w.BodyBytes())
+
+	// Test: Connection: close set by handler
+	w = newWriter()
+	w.Header().Set("Connection", "keep-alive, close")
+	w.WriteHeaders(GetDefaultHeaders(0))
+	assert.False(t, w.KeepAlive())
+
+	// Test: Response to HEAD and response without body
+	w = newWriter()
+	w.DiscardBody()
+	w.WriteHeaders(GetDefaultHeaders(5))
+	assert.True(t, w.KeepAlive())
+	assert.Zero(t, w.BodyBytes())
+	w = NewWritter(io.Discard)
+	w.WriteStatusLine(NoContentStatusCode)
+	w.WriteHeaders(nil)
+	assert.True(t, w.KeepAlive())
+
+	// Test: Headers not written
+	assert.False(t, newWriter().KeepAlive())
+}
@@ -0,0 +1,121 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+	"github.com/MichalGul/http_server_go/internal/request"
+)
+
+// StrongETag returns strong entity tag computed from hash of the content
+func StrongETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// WeakETag returns weak entity tag derived from modification time and size,
+// cheap to compute for files but does not guarantee byte for byte equality
+func WeakETag(modtime time.Time, size int64) string {
+	return fmt.Sprintf(`W/"%x-%x"`, modtime.UnixNano(), size)
+}
+
+// EvaluatePreconditions evaluates If-Match, If-Unmodified-Since, If-None-Match and If-Modified-Since
+// request headers against current representation validators in order defined by RFC 9110 section 13.2.2.
+// Returns OkStatusCode when request should be processed normally, NotModifiedStatusCode or
+// PreconditionFailedStatusCode otherwise. Empty etag or zero lastModified mean validator is not available.
+func EvaluatePreconditions(req *request.Request, etag string, lastModified time.Time) StatusCode {
+	method := req.RequestLine.Method
+	lastModified = lastModified.Truncate(time.Second)
+
+	// Step 1 and 2: If-Match takes precedence over If-Unmodified-Since
+	if ifMatch, exists := req.Headers.Get("If-Match"); exists {
+		if !ifMatchPasses(ifMatch, etag) {
+			return PreconditionFailedStatusCode
+		}
+	} else if ifUnmodifiedSince, exists := req.Headers.Get("If-Unmodified-Since"); exists && !isZeroTime(lastModified) {
+		date, err := headers.ParseTime(ifUnmodifiedSince)
+		if err == nil && lastModified.After(date) {
+			return PreconditionFailedStatusCode
+		}
+	}
+
+	// Step 3 and 4: If-None-Match takes precedence over If-Modified-Since
+	if ifNoneMatch, exists := req.Headers.Get("If-None-Match"); exists {
+		if !ifNoneMatchPasses(ifNoneMatch, etag) {
+			if method == "GET" || method == "HEAD" {
+				return NotModifiedStatusCode
+			}
+			return PreconditionFailedStatusCode
+		}
+	} else if ifModifiedSince, exists := req.Headers.Get("If-Modified-Since"); exists && !isZeroTime(lastModified) {
+		if method == "GET" || method == "HEAD" {
+			date, err := headers.ParseTime(ifModifiedSince)
+			if err == nil && !lastModified.After(date) {
+				return NotModifiedStatusCode
+			}
+		}
+	}
+
+	return OkStatusCode
+}
+
+// If-Match uses strong comparison, wildcard matches any current representation
+func ifMatchPasses(value, etag string) bool {
+	for _, candidate := range headers.ParseETagList(value) {
+		if candidate == "*" {
+			return etag != ""
+		}
+		if headers.ETagStrongMatch(candidate, etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// If-None-Match uses weak comparison, wildcard fails when representation exists
+func ifNoneMatchPasses(value, etag string) bool {
+	for _, candidate := range headers.ParseETagList(value) {
+		if candidate == "*" {
+			return etag == ""
+		}
+		if headers.ETagWeakMatch(candidate, etag) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckPreconditions evaluates conditional request headers and when they fail writes
+// 304 Not Modified or 412 Precondition Failed response.
+// Returns true when response was written and handler should stop processing the request.
+func CheckPreconditions(w *Writer, req *request.Request, etag string, lastModified time.Time) bool {
+	statusCode := EvaluatePreconditions(req, etag, lastModified)
+	switch statusCode {
+	case NotModifiedStatusCode:
+		// 304 has no body, it repeats validators so cache can update stored response
+		w.WriteStatusLine(NotModifiedStatusCode)
+		h := GetDefaultHeaders(0)
+		delete(h, "Content-Length")
+		delete(h, "Content-Type")
+		setValidators(h, etag, lastModified)
+		w.WriteHeaders(h)
+		return true
+	case PreconditionFailedStatusCode:
+		writeError(w, PreconditionFailedStatusCode, "Precondition Failed")
+		return true
+	}
+	return false
+}
+
+// setValidators sets ETag and Last-Modified headers when validators are available
+func setValidators(h headers.Headers, etag string, lastModified time.Time) {
+	if etag != "" {
+		h.Set("ETag", etag)
+	}
+	if !isZeroTime(lastModified) {
+		h.Set("Last-Modified", headers.FormatTime(lastModified))
+	}
+}
@@ -0,0 +1,131 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluatePreconditions(t *testing.T) {
+	etag := `"v2"`
+	lastModified := time.Date(2024, time.March, 10, 12, 30, 0, 500, time.UTC)
+	before := "Sat, 09 Mar 2024 12:30:00 GMT"
+	same := "Sun, 10 Mar 2024 12:30:00 GMT"
+
+	tests := []struct {
+		name     string
+		method   string
+		headers  map[string]string
+		expected StatusCode
+	}{
+		{"no conditions", "GET", nil, OkStatusCode},
+		{"if-none-match matches", "GET", map[string]string{"If-None-Match": `"v1", W/"v2"`}, NotModifiedStatusCode},
+		{"if-none-match matches on HEAD", "HEAD", map[string]string{"If-None-Match": etag}, NotModifiedStatusCode},
+		{"if-none-match does not match", "GET", map[string]string{"If-None-Match": `"v1"`}, OkStatusCode},
+		{"if-none-match wildcard", "GET", map[string]string{"If-None-Match": "*"}, NotModifiedStatusCode},
+		{"if-none-match matches on PUT", "PUT", map[string]string{"If-None-Match": etag}, PreconditionFailedStatusCode},
+		{"if-match matches", "PUT", map[string]string{"If-Match": etag}, OkStatusCode},
+		{"if-match does not match", "PUT", map[string]string{"If-Match": `"v1"`}, PreconditionFailedStatusCode},
+		{"if-match weak tag never matches", "PUT", map[string]string{"If-Match": `W/"v2"`}, PreconditionFailedStatusCode},
+		{"if-match wildcard", "DELETE", map[string]string{"If-Match": "*"}, OkStatusCode},
+		{"if-modified-since not modified", "GET", map[string]string{"If-Modified-Since": same}, NotModifiedStatusCode},
+		{"if-modified-since modified", "GET", map[string]string{"If-Modified-Since": before}, OkStatusCode},
+		{"if-modified-since ignored for POST", "POST", map[string]string{"If-Modified-Since": same}, OkStatusCode},
+		{"if-modified-since invalid date ignored", "GET", map[string]string{"If-Modified-Since": "yesterday"}, OkStatusCode},
+		{"if-unmodified-since modified", "PUT", map[string]string{"If-Unmodified-Since": before}, PreconditionFailedStatusCode},
+		{"if-unmodified-since not modified", "PUT", map[string]string{"If-Unmodified-Since": same}, OkStatusCode},
+		// If-None-Match takes precedence, so If-Modified-Since is ignored
+		{"if-none-match over if-modified-since", "GET", map[string]string{"If-None-Match": `"v1"`, "If-Modified-Since": same}, OkStatusCode},
+		// If-Match takes precedence, so If-Unmodified-Since is ignored
+		{"if-match over if-unmodified-since", "PUT", map[string]string{"If-Match": etag, "If-Unmodified-Since": before}, OkStatusCode},
+		// If-Match failure wins over If-None-Match
+		{"if-match evaluated first", "GET", map[string]string{"If-Match": `"v1"`, "If-None-Match": etag}, PreconditionFailedStatusCode},
+	}
+
+	for _, test := range tests {
+		req := newTestRequest(test.method, "/", test.headers)
+		assert.Equal(t, test.expected, EvaluatePreconditions(req, etag, lastModified), test.name)
+	}
+
+	// Test: Missing validators
+	req := newTestRequest("GET", "/", map[string]string{"If-Modified-Since": same})
+	assert.Equal(t, OkStatusCode, EvaluatePreconditions(req, "", time.Time{}))
+	req = newTestRequest("PUT", "/", map[string]string{"If-Match": "*"})
+	assert.Equal(t, PreconditionFailedStatusCode, EvaluatePreconditions(req, "", time.Time{}))
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	etag := StrongETag([]byte("content"))
+	lastModified := time.Date(2024, time.March, 10, 12, 30, 0, 0, time.UTC)
+
+	// Test: Not modified response carries validators and no body
+	var buf bytes.Buffer
+	req := newTestRequest("GET", "/", map[string]string{"If-None-Match": etag})
+	w := NewWritter(&buf)
+	written := CheckPreconditions(w, req, etag, lastModified)
+	w.Finish()
+	assert.True(t, written)
+	resp := buf.String()
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.Contains(t, resp, "ETag: "+etag+"\r\n")
+	assert.Contains(t, resp, "Last-Modified: Sun, 10 Mar 2024 12:30:00 GMT\r\n")
+	assert.NotContains(t, resp, "Content-Length")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+
+	// Test: Precondition failed
+	buf.Reset()
+	req = newTestRequest("PUT", "/", map[string]string{"If-Match": `"other"`})
+	w = NewWritter(&buf)
+	written = CheckPreconditions(w, req, etag, lastModified)
+	w.Finish()
+	assert.True(t, written)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 412 Precondition Failed\r\n"))
+
+	// Test: Passing conditions write nothing
+	buf.Reset()
+	req = newTestRequest("GET", "/", map[string]string{"If-None-Match": `"other"`})
+	w = NewWritter(&buf)
+	written = CheckPreconditions(w, req, etag, lastModified)
+	w.Finish()
+	assert.False(t, written)
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestETags(t *testing.T) {
+	assert.Equal(t, StrongETag([]byte("a")), StrongETag([]byte("a")))
+	assert.NotEqual(t, StrongETag([]byte("a")), StrongETag([]byte("b")))
+	assert.False(t, strings.HasPrefix(StrongETag([]byte("a")), "W/"))
+
+	modtime := time.Unix(1700000000, 0)
+	assert.True(t, strings.HasPrefix(WeakETag(modtime, 10), `W/"`))
+	assert.NotEqual(t, WeakETag(modtime, 10), WeakETag(modtime, 11))
+}
+
+func TestServeContentConditional(t *testing.T) {
+	modtime := time.Date(2024, time.March, 10, 12, 30, 0, 0, time.UTC)
+	etag := `"abc"`
+
+	serve := func(req map[string]string) string {
+		var buf bytes.Buffer
+		w := NewWritter(&buf)
+		ServeContent(w, newTestRequest("GET", "/", req), "file.txt", modtime, etag, strings.NewReader(rangeContent))
+		w.Finish()
+		return buf.String()
+	}
+
+	resp := serve(nil)
+	assert.Contains(t, resp, "ETag: \"abc\"\r\n")
+
+	resp = serve(map[string]string{"If-None-Match": etag})
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 304 Not Modified\r\n"))
+
+	// Test: If-Range with matching strong entity tag
+	resp = serve(map[string]string{"Range": "bytes=0-1", "If-Range": etag})
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 206 Partial Content\r\n"))
+
+	resp = serve(map[string]string{"Range": "bytes=0-1", "If-Range": `"old"`})
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+}
@@ -0,0 +1,218 @@
+package response
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+	"github.com/MichalGul/http_server_go/internal/request"
+)
+
+// ServeContent replies to request with content of the seekable reader.
+// It sets Content-Type based on name extension or sniffed data, ETag (when not empty) and Last-Modified
+// from modtime (when not zero), answers conditional requests with 304 Not Modified or 412 Precondition Failed,
+// advertises Accept-Ranges and handles Range/If-Range requests answering with 206 Partial Content
+// (single range or multipart/byteranges) or 416 Range Not Satisfiable.
+func ServeContent(w *Writer, req *request.Request, name string, modtime time.Time, etag string, content io.ReadSeeker) {
+	if CheckPreconditions(w, req, etag, modtime) {
+		return
+	}
+
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		writeError(w, InternalServerErrorStatusCode, "seeker can't seek")
+		return
+	}
+	_, err = content.Seek(0, io.SeekStart)
+	if err != nil {
+		writeError(w, InternalServerErrorStatusCode, "seeker can't seek")
+		return
+	}
+
+	contentType, err := detectContentType(content, name)
+	if err != nil {
+		writeError(w, InternalServerErrorStatusCode, "error reading content")
+		return
+	}
+
+	h := GetDefaultHeaders(int(size))
+	h.Set("Content-Type", contentType)
+	h.Set("Accept-Ranges", "bytes")
+	setValidators(h, etag, modtime)
+
+	ranges, err := requestedRanges(req, etag, modtime, size)
+	if errors.Is(err, headers.ErrUnsatisfiableRange) {
+		message := []byte("Range Not Satisfiable")
+		w.WriteStatusLine(RangeNotSatisfiableStatusCode)
+		h := GetDefaultHeaders(len(message))
+		h.Set("Accept-Ranges", "bytes")
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		w.WriteHeaders(h)
+		w.WriteBody(message)
+		return
+	}
+
+	var body io.Reader
+	sendRange := headers.ByteRange{Start: 0, Length: size}
+	switch {
+	case len(ranges) == 0:
+		w.WriteStatusLine(OkStatusCode)
+
+	case len(ranges) == 1:
+		sendRange = ranges[0]
+		w.WriteStatusLine(PartialContentStatusCode)
+		h.Set("Content-Length", strconv.FormatInt(sendRange.Length, 10))
+		h.Set("Content-Range", sendRange.ContentRange(size))
+
+	default:
+		boundary := randomBoundary()
+		w.WriteStatusLine(PartialContentStatusCode)
+		h.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+		var contentLength int64
+		body, contentLength = multipartRanges(content, ranges, size, contentType, boundary)
+		h.Set("Content-Length", strconv.FormatInt(contentLength, 10))
+	}
+
+	w.WriteHeaders(h)
+	if req.RequestLine.Method == "HEAD" {
+		return
+	}
+
+	if body != nil {
+		w.WriteBodyFrom(body)
+		return
+	}
+	// Files go through WriteFile so connection can use sendfile
+	if file, ok := content.(*os.File); ok {
+		w.WriteFile(file, sendRange.Start, sendRange.Length)
+		return
+	}
+	w.WriteBodyFrom(&sectionReader{content: content, offset: sendRange.Start, remaining: sendRange.Length})
+}
+
+// requestedRanges returns ranges which should be sent or empty slice when whole representation should be sent
+func requestedRanges(req *request.Request, etag string, modtime time.Time, size int64) ([]headers.ByteRange, error) {
+	if req.RequestLine.Method != "GET" {
+		return nil, nil
+	}
+	rangeValue, exists := req.Headers.Get("Range")
+	if !exists {
+		return nil, nil
+	}
+
+	if ifRangeValue, exists := req.Headers.Get("If-Range"); exists {
+		ifRange, err := headers.ParseIfRange(ifRangeValue)
+		if err != nil || !ifRangeMatches(ifRange, etag, modtime) {
+			return nil, nil
+		}
+	}
+
+	ranges, err := headers.ParseRange(rangeValue, size)
+	if errors.Is(err, headers.ErrInvalidRange) {
+		// Syntactically invalid Range is ignored
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Many small or overlapping ranges could make response bigger than representation itself,
+	// send whole content in such case
+	if len(ranges) > 1 {
+		var total int64
+		for _, byteRange := range ranges {
+			total += byteRange.Length
+		}
+		if total > size {
+			return nil, nil
+		}
+	}
+	return ranges, nil
+}
+
+// If-Range requires strong validator match, otherwise whole representation is sent
+func ifRangeMatches(ifRange headers.IfRange, etag string, modtime time.Time) bool {
+	if ifRange.ETag != "" {
+		return headers.ETagStrongMatch(ifRange.ETag, etag)
+	}
+	if isZeroTime(modtime) {
+		return false
+	}
+	return modtime.Truncate(time.Second).Equal(ifRange.Date)
+}
+
+func isZeroTime(t time.Time) bool {
+	return t.IsZero() || t.Equal(time.Unix(0, 0))
+}
+
+// sectionReader reads remaining bytes from offset, seek is postponed until first read
+// so multiple sections of the same content can be chained one after another
+type sectionReader struct {
+	content   io.ReadSeeker
+	offset    int64
+	remaining int64
+	seeked    bool
+}
+
+func (s *sectionReader) Read(p []byte) (int, error) {
+	if !s.seeked {
+		_, err := s.content.Seek(s.offset, io.SeekStart)
+		if err != nil {
+			return 0, err
+		}
+		s.seeked = true
+	}
+	if s.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err := s.content.Read(p)
+	s.remaining -= int64(n)
+	if err == io.EOF && s.remaining > 0 {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// multipartRanges builds multipart/byteranges body and computes its exact length
+func multipartRanges(content io.ReadSeeker, ranges []headers.ByteRange, size int64, contentType, boundary string) (io.Reader, int64) {
+	var parts []io.Reader
+	var length int64
+	for _, byteRange := range ranges {
+		var partHeader strings.Builder
+		partHeader.WriteString(crlf + "--" + boundary + crlf)
+		partHeader.WriteString("Content-Type: " + contentType + crlf)
+		partHeader.WriteString("Content-Range: " + byteRange.ContentRange(size) + crlf)
+		partHeader.WriteString(crlf)
+
+		parts = append(parts, strings.NewReader(partHeader.String()))
+		parts = append(parts, &sectionReader{content: content, offset: byteRange.Start, remaining: byteRange.Length})
+		length += int64(partHeader.Len()) + byteRange.Length
+	}
+	closing := crlf + "--" + boundary + "--" + crlf
+	parts = append(parts, strings.NewReader(closing))
+	length += int64(len(closing))
+
+	return io.MultiReader(parts...), length
+}
+
+func randomBoundary() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func writeError(w *Writer, statusCode StatusCode, message string) {
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(GetDefaultHeaders(len(message)))
+	w.WriteBody([]byte(message))
+}
@@ -0,0 +1,127 @@
+package response
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRequest(method, target string, h map[string]string) *request.Request {
+	reqHeaders := headers.NewHeaders()
+	for name, value := range h {
+		reqHeaders[strings.ToLower(name)] = value
+	}
+	return &request.Request{
+		RequestLine: request.RequestLine{
+			HttpVersion:   "1.1",
+			RequestTarget: target,
+			Method:        method,
+		},
+		ParsingState: request.Done,
+		Headers:      reqHeaders,
+	}
+}
+
+func serveContentTest(req *request.Request, modtime time.Time, content string) string {
+	var buf bytes.Buffer
+	w := NewWritter(&buf)
+	ServeContent(w, req, "file.txt", modtime, "", strings.NewReader(content))
+	w.Finish()
+	return buf.String()
+}
+
+const rangeContent = "0123456789abcdefghij"
+
+func TestServeContentRange(t *testing.T) {
+	modtime := time.Date(2024, time.March, 10, 12, 30, 0, 0, time.UTC)
+
+	// Test: No range sends whole content
+	resp := serveContentTest(newTestRequest("GET", "/", nil), modtime, rangeContent)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "Accept-Ranges: bytes\r\n")
+	assert.Contains(t, resp, "Last-Modified: Sun, 10 Mar 2024 12:30:00 GMT\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"+rangeContent))
+
+	// Test: Single range
+	resp = serveContentTest(newTestRequest("GET", "/", map[string]string{"Range": "bytes=2-5"}), modtime, rangeContent)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Contains(t, resp, "Content-Range: bytes 2-5/20\r\n")
+	assert.Contains(t, resp, "Content-Length: 4\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n2345"))
+
+	// Test: Suffix range
+	resp = serveContentTest(newTestRequest("GET", "/", map[string]string{"Range": "bytes=-3"}), modtime, rangeContent)
+	assert.Contains(t, resp, "Content-Range: bytes 17-19/20\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nhij"))
+
+	// Test: Unsatisfiable range
+	resp = serveContentTest(newTestRequest("GET", "/", map[string]string{"Range": "bytes=50-60"}), modtime, rangeContent)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 416 Range Not Satisfiable\r\n"))
+	assert.Contains(t, resp, "Content-Range: bytes */20\r\n")
+	assert.Contains(t, resp, "Accept-Ranges: bytes\r\n")
+
+	// Test: Invalid range is ignored
+	resp = serveContentTest(newTestRequest("GET", "/", map[string]string{"Range": "lines=1-2"}), modtime, rangeContent)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Range is ignored for HEAD
+	resp = serveContentTest(newTestRequest("HEAD", "/", map[string]string{"Range": "bytes=0-1"}), modtime, rangeContent)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+
+	// Test: If-Range with matching date
+	resp = serveContentTest(newTestRequest("GET", "/", map[string]string{"Range": "bytes=0-1", "If-Range": "Sun, 10 Mar 2024 12:30:00 GMT"}), modtime, rangeContent)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 206 Partial Content\r\n"))
+
+	// Test: If-Range with outdated date sends whole content
+	resp = serveContentTest(newTestRequest("GET", "/", map[string]string{"Range": "bytes=0-1", "If-Range": "Sat, 09 Mar 2024 12:30:00 GMT"}), modtime, rangeContent)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(resp, rangeContent))
+}
+
+func TestServeContentMultipleRanges(t *testing.T) {
+	resp := serveContentTest(newTestRequest("GET", "/", map[string]string{"Range": "bytes=0-1, 10-12"}), time.Time{}, rangeContent)
+	require.True(t, strings.HasPrefix(resp, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.NotContains(t, resp, "Last-Modified")
+
+	headerEnd := strings.Index(resp, "\r\n\r\n")
+	require.NotEqual(t, -1, headerEnd)
+	head := resp[:headerEnd+2]
+	body := resp[headerEnd+4:]
+
+	_, boundary, found := strings.Cut(head, "Content-Type: multipart/byteranges; boundary=")
+	require.True(t, found)
+	boundary, _, _ = strings.Cut(boundary, "\r\n")
+
+	expected := "\r\n--" + boundary + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Range: bytes 0-1/20\r\n\r\n" +
+		"01" +
+		"\r\n--" + boundary + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Range: bytes 10-12/20\r\n\r\n" +
+		"abc" +
+		"\r\n--" + boundary + "--\r\n"
+	assert.Equal(t, expected, body)
+	assert.Contains(t, head, "Content-Length: "+strconv.Itoa(len(expected))+"\r\n")
+
+	// Test: Ranges covering more than content send it whole
+	resp = serveContentTest(newTestRequest("GET", "/", map[string]string{"Range": "bytes=0-15, 5-19"}), time.Time{}, rangeContent)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestDetectContentType(t *testing.T) {
+	assert.Equal(t, "image/png", DetectContentType([]byte("\x89PNG\r\n\x1a\n0000")))
+	assert.Equal(t, "application/pdf", DetectContentType([]byte("%PDF-1.4")))
+	assert.Equal(t, "video/mp4", DetectContentType([]byte("\x00\x00\x00\x18ftypmp42")))
+	assert.Equal(t, "text/html; charset=utf-8", DetectContentType([]byte("  <!DOCTYPE html><html>")))
+	assert.Equal(t, "text/plain; charset=utf-8", DetectContentType([]byte("just text\n")))
+	assert.Equal(t, "application/octet-stream", DetectContentType([]byte{0x00, 0x01, 0x02}))
+}
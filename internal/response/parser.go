@@ -0,0 +1,451 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+)
+
+type ResponseParsingState int
+
+const (
+	ParsingStatusLine ResponseParsingState = iota
+	ParsingHeaders
+	ParsingBody
+	ParsingBodyUntilClose
+	ParsingChunkSize
+	ParsingChunkData
+	ParsingChunkDataEnd
+	ParsingTrailers
+	ParsingDone
+)
+
+const readBufferSize = 4096
+
+// Status line and header section bigger than this are rejected, so is trailer section.
+// Read buffer never grows past it, single chunk-size line has to fit as well.
+const maxHeaderSize = 1 << 20
+
+var ErrHeaderTooLarge = errors.New("response header section too large")
+
+var errChunkSizeLineTooLong = errors.New("chunk size line too long")
+
+type StatusLine struct {
+	HttpVersion  string
+	StatusCode   StatusCode
+	ReasonPhrase string
+}
+
+// Response is response parsed from reader, counterpart of request.Request
+type Response struct {
+	StatusLine   StatusLine
+	ParsingState ResponseParsingState
+	Headers      headers.Headers
+	// Body holds parsed body bytes. ResponseFromReader returns whole body,
+	// when body is read with Reader.Read it holds only bytes not read yet.
+	Body []byte
+	// ContentLength is length of the body, -1 when unknown (chunked or close-delimited)
+	ContentLength int64
+	// Trailers holds fields sent after chunked body, fields not allowed in trailers are dropped
+	Trailers headers.Headers
+
+	// request method, responses to HEAD have no body
+	method         string
+	bodyRemaining  int64
+	chunkRemaining int64
+	closeDelimited bool
+	// bytes read from reader after end of response
+	buffered []byte
+	// bytes of status line and headers, and of trailers, parsed so far
+	headSize    int
+	trailerSize int
+}
+
+// parseSingle parses single element of response and moves state machine, like request.Request does
+//
+//	HTTP/1.1 200 OK           # status-line CRLF
+//	Content-Length: 5         # *( field-line CRLF )
+//	                          # CRLF
+//	hello                     # [ message-body ]
+func (r *Response) parseSingle(data []byte) (int, error) {
+
+	switch r.ParsingState {
+
+	case ParsingStatusLine:
+		idx := bytes.Index(data, []byte(crlf))
+		if idx == -1 {
+			// needs more data from the stream
+			return 0, nil
+		}
+		statusLine, err := parseStatusLine(string(data[:idx]))
+		if err != nil {
+			return 0, err
+		}
+		r.StatusLine = *statusLine
+		r.ParsingState = ParsingHeaders
+		r.headSize += idx + len(crlf)
+		return idx + len(crlf), nil
+
+	case ParsingHeaders:
+		numOfBytes, done, err := r.Headers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		r.headSize += numOfBytes
+		if done {
+			err = r.startBody()
+			if err != nil {
+				return 0, err
+			}
+		}
+		return numOfBytes, nil
+
+	case ParsingBody:
+		n := int64(len(data))
+		if n > r.bodyRemaining {
+			n = r.bodyRemaining
+		}
+		r.Body = append(r.Body, data[:n]...)
+		r.bodyRemaining -= n
+		if r.bodyRemaining == 0 {
+			r.ParsingState = ParsingDone
+		}
+		return int(n), nil
+
+	case ParsingBodyUntilClose:
+		// Body ends when connection is closed, reader marks response done on EOF
+		r.Body = append(r.Body, data...)
+		return len(data), nil
+
+	case ParsingChunkSize:
+		idx := bytes.Index(data, []byte(crlf))
+		if idx == -1 {
+			return 0, nil
+		}
+		chunkSize, err := headers.ParseChunkSize(data[:idx])
+		if err != nil {
+			return 0, err
+		}
+		if chunkSize == 0 {
+			// Last chunk, trailer section follows
+			r.ParsingState = ParsingTrailers
+		} else {
+			r.chunkRemaining = chunkSize
+			r.ParsingState = ParsingChunkData
+		}
+		return idx + len(crlf), nil
+
+	case ParsingChunkData:
+		n := int64(len(data))
+		if n > r.chunkRemaining {
+			n = r.chunkRemaining
+		}
+		r.Body = append(r.Body, data[:n]...)
+		r.chunkRemaining -= n
+		if r.chunkRemaining == 0 {
+			r.ParsingState = ParsingChunkDataEnd
+		}
+		return int(n), nil
+
+	case ParsingChunkDataEnd:
+		if len(data) < len(crlf) {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte(crlf)) {
+			return 0, fmt.Errorf("malformed chunk: missing CRLF after chunk data")
+		}
+		r.ParsingState = ParsingChunkSize
+		return len(crlf), nil
+
+	case ParsingTrailers:
+		if r.Trailers == nil {
+			r.Trailers = headers.NewHeaders()
+		}
+		numOfBytes, done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		r.trailerSize += numOfBytes
+		if done {
+			for name := range r.Trailers {
+				if headers.IsForbiddenTrailer(name) {
+					delete(r.Trailers, name)
+				}
+			}
+			r.ParsingState = ParsingDone
+		}
+		return numOfBytes, nil
+
+	case ParsingDone:
+		return 0, fmt.Errorf("error: trying to read data in a done state")
+
+	default:
+		return 0, fmt.Errorf("error: unknown response parsing state")
+	}
+}
+
+// startBody selects body framing once headers are parsed (RFC 9112 6.3)
+func (r *Response) startBody() error {
+	r.ContentLength = -1
+	statusCode := r.StatusLine.StatusCode
+	if r.method == "HEAD" || statusCode < 200 || statusCode == NoContentStatusCode || statusCode == NotModifiedStatusCode {
+		// Content-Length of HEAD response describes body GET would get
+		if contentLength, err := r.contentLength(); err == nil && r.method == "HEAD" {
+			r.ContentLength = contentLength
+		}
+		r.ParsingState = ParsingDone
+		return nil
+	}
+
+	if transferEncoding, exists := r.Headers.Get("Transfer-Encoding"); exists {
+		if !strings.EqualFold(strings.TrimSpace(transferEncoding), "chunked") {
+			return fmt.Errorf("unsupported transfer-encoding: %s", transferEncoding)
+		}
+		r.ParsingState = ParsingChunkSize
+		return nil
+	}
+
+	contentLength, err := r.contentLength()
+	if err != nil {
+		return err
+	}
+	if contentLength < 0 {
+		r.closeDelimited = true
+		r.ParsingState = ParsingBodyUntilClose
+		return nil
+	}
+	r.ContentLength = contentLength
+	r.bodyRemaining = contentLength
+	r.ParsingState = ParsingBody
+	if contentLength == 0 {
+		r.ParsingState = ParsingDone
+	}
+	return nil
+}
+
+// contentLength returns Content-Length value, -1 when header is missing
+func (r *Response) contentLength() (int64, error) {
+	value, exists := r.Headers.Get("Content-Length")
+	if !exists {
+		return -1, nil
+	}
+	contentLength, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || contentLength < 0 {
+		return 0, fmt.Errorf("malformed Content-Length: %s", value)
+	}
+	return contentLength, nil
+}
+
+func (r *Response) parse(data []byte) (int, error) {
+	totalBytesParsed := 0
+	for r.ParsingState != ParsingDone {
+		numOfBytes, err := r.parseSingle(data[totalBytesParsed:])
+		if err != nil {
+			return 0, err
+		}
+		totalBytesParsed += numOfBytes
+		if numOfBytes == 0 {
+			break
+		}
+	}
+	return totalBytesParsed, nil
+}
+
+// tooLargeError is error for element parsed in the state which doesn't fit read buffer
+func (s ResponseParsingState) tooLargeError() error {
+	switch s {
+	case ParsingStatusLine, ParsingHeaders, ParsingTrailers:
+		return ErrHeaderTooLarge
+	default:
+		return errChunkSizeLineTooLong
+	}
+}
+
+// CloseDelimited reports whether body ends with connection close, connection can't be reused then
+func (r *Response) CloseDelimited() bool {
+	return r.closeDelimited
+}
+
+// Buffered returns bytes which were read from reader after the end of response
+func (r *Response) Buffered() []byte {
+	return r.buffered
+}
+
+// parseStatusLine parses e.g. "HTTP/1.1 404 Not Found", reason phrase may be empty
+func parseStatusLine(line string) (*StatusLine, error) {
+	version, rest, found := strings.Cut(line, " ")
+	if !found {
+		return nil, fmt.Errorf("malformed status-line: %s", line)
+	}
+	if version != "HTTP/1.1" && version != "HTTP/1.0" {
+		return nil, fmt.Errorf("unsupported HTTP-version: %s", version)
+	}
+	code, reason, _ := strings.Cut(rest, " ")
+	if len(code) != 3 {
+		return nil, fmt.Errorf("malformed status code: %s", code)
+	}
+	statusCode, err := strconv.Atoi(code)
+	if err != nil || statusCode < 100 {
+		return nil, fmt.Errorf("malformed status code: %s", code)
+	}
+	return &StatusLine{
+		HttpVersion:  strings.TrimPrefix(version, "HTTP/"),
+		StatusCode:   StatusCode(statusCode),
+		ReasonPhrase: reason,
+	}, nil
+}
+
+// Reader reads consecutive responses from connection. Head is parsed with ReadHead,
+// body is then streamed with Read as it arrives instead of being buffered whole.
+type Reader struct {
+	reader   io.Reader
+	buffer   []byte
+	readTo   int
+	received int
+	response *Response
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{reader: reader, buffer: make([]byte, readBufferSize)}
+}
+
+// ReadHead reads status line and headers of next response. Method of request decides
+// whether response has body. Interim 1xx responses are returned like any other.
+func (r *Reader) ReadHead(method string) (*Response, error) {
+	r.response = &Response{
+		ParsingState: ParsingStatusLine,
+		Headers:      headers.NewHeaders(),
+		method:       method,
+	}
+	for r.response.ParsingState == ParsingStatusLine || r.response.ParsingState == ParsingHeaders {
+		err := r.fill()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r.response, nil
+}
+
+// Read reads body of response returned by last ReadHead, io.EOF is returned once body ends
+func (r *Reader) Read(p []byte) (int, error) {
+	resp := r.response
+	if resp == nil {
+		return 0, io.EOF
+	}
+	for len(resp.Body) == 0 {
+		if resp.ParsingState == ParsingDone {
+			return 0, io.EOF
+		}
+		err := r.fill()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, resp.Body)
+	resp.Body = resp.Body[n:]
+	if len(resp.Body) == 0 {
+		resp.Body = nil
+	}
+	return n, nil
+}
+
+// Buffered returns number of bytes read from connection which don't belong to parsed responses yet
+func (r *Reader) Buffered() int {
+	return r.readTo
+}
+
+// Received returns number of bytes read from connection, 0 means peer sent nothing
+func (r *Reader) Received() int {
+	return r.received
+}
+
+// fill parses buffered data, when nothing could be parsed it reads more from reader
+func (r *Reader) fill() error {
+	if r.readTo > 0 {
+		parsed, err := r.consume()
+		if err != nil || parsed > 0 {
+			return err
+		}
+	}
+
+	if r.readTo >= len(r.buffer) {
+		if len(r.buffer) >= maxHeaderSize {
+			return r.response.ParsingState.tooLargeError()
+		}
+		// make new slice with capacity x2 and copy data
+		newBuffer := make([]byte, min(2*len(r.buffer), maxHeaderSize))
+		copy(newBuffer, r.buffer)
+		r.buffer = newBuffer
+	}
+	n, readErr := r.reader.Read(r.buffer[r.readTo:])
+	r.readTo += n
+	r.received += n
+	if n > 0 {
+		_, err := r.consume()
+		if err != nil {
+			return err
+		}
+	}
+	if readErr != nil {
+		if !errors.Is(readErr, io.EOF) {
+			return readErr
+		}
+		if r.response.ParsingState == ParsingBodyUntilClose {
+			r.response.ParsingState = ParsingDone
+		}
+		if r.response.ParsingState != ParsingDone {
+			return io.ErrUnexpectedEOF
+		}
+	}
+	return nil
+}
+
+// consume parses buffered data and drops parsed bytes from buffer
+func (r *Reader) consume() (int, error) {
+	parsed, err := r.response.parse(r.buffer[:r.readTo])
+	if err != nil {
+		return 0, err
+	}
+	copy(r.buffer, r.buffer[parsed:r.readTo])
+	r.readTo -= parsed
+
+	// Sections are parsed line by line, what is left in buffer while they are parsed is their unfinished line
+	resp := r.response
+	headSize, trailerSize := resp.headSize, resp.trailerSize
+	switch resp.ParsingState {
+	case ParsingStatusLine, ParsingHeaders:
+		headSize += r.readTo
+	case ParsingTrailers:
+		trailerSize += r.readTo
+	}
+	if headSize > maxHeaderSize || trailerSize > maxHeaderSize {
+		return 0, ErrHeaderTooLarge
+	}
+	return parsed, nil
+}
+
+// ResponseFromReader parses whole response with body from reader, e.g. response written
+// by server to connection or recorded from handler. Response is expected to answer
+// request other than HEAD, use Reader for responses to HEAD.
+func ResponseFromReader(reader io.Reader) (*Response, error) {
+	r := NewReader(reader)
+	resp, err := r.ReadHead("")
+	if err != nil {
+		return nil, err
+	}
+	for resp.ParsingState != ParsingDone {
+		err = r.fill()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.readTo > 0 {
+		resp.buffered = append([]byte(nil), r.buffer[:r.readTo]...)
+	}
+	return resp, nil
+}
@@ -0,0 +1,221 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+// Read reads up to len(p) or numBytesPerRead bytes from the string per call,
+// simulating network connection delivering data in small pieces
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+	endIndex := cr.pos + cr.numBytesPerRead
+	if endIndex > len(cr.data) {
+		endIndex = len(cr.data)
+	}
+	n = copy(p, cr.data[cr.pos:endIndex])
+	cr.pos += n
+	return n, nil
+}
+
+func TestResponseFromReader(t *testing.T) {
+	// Test: Content-Length body
+	r, err := ResponseFromReader(&chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 13\r\n\r\nhello, world!",
+		numBytesPerRead: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "1.1", r.StatusLine.HttpVersion)
+	assert.Equal(t, OkStatusCode, r.StatusLine.StatusCode)
+	assert.Equal(t, "OK", r.StatusLine.ReasonPhrase)
+	assert.Equal(t, "text/plain", r.Headers["content-type"])
+	assert.Equal(t, "hello, world!", string(r.Body))
+	assert.Equal(t, int64(13), r.ContentLength)
+
+	// Test: Chunked body with trailers
+	r, err = ResponseFromReader(&chunkReader{
+		data: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n" +
+			"5;name=value\r\nhello\r\n7\r\n, world\r\n0\r\nX-Checksum: abc\r\nHost: forbidden\r\n\r\n",
+		numBytesPerRead: 2,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(r.Body))
+	assert.Equal(t, int64(-1), r.ContentLength)
+	assert.Equal(t, headers.Headers{"x-checksum": "abc"}, r.Trailers)
+
+	// Test: Close-delimited body
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.0 200 OK\r\n\r\nuntil close"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.StatusLine.HttpVersion)
+	assert.Equal(t, "until close", string(r.Body))
+	assert.True(t, r.CloseDelimited())
+
+	// Test: Statuses without body, unknown reason phrase
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 304 \r\nETag: \"v1\"\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, NotModifiedStatusCode, r.StatusLine.StatusCode)
+	assert.Empty(t, r.StatusLine.ReasonPhrase)
+	assert.Empty(t, r.Body)
+
+	// Test: Bytes after response are kept
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 204 No Content\r\n\r\nHTTP/1.1 200 OK\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", string(r.Buffered()))
+}
+
+func TestResponseFromReaderErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "bad version", data: "HTTP/2 200 OK\r\n\r\n"},
+		{name: "bad status code", data: "HTTP/1.1 20 OK\r\n\r\n"},
+		{name: "no status code", data: "HTTP/1.1\r\n\r\n"},
+		{name: "malformed header", data: "HTTP/1.1 200 OK\r\nBad Header: x\r\n\r\n"},
+		{name: "malformed content-length", data: "HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n"},
+		{name: "unsupported transfer-encoding", data: "HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip\r\n\r\n"},
+		{name: "malformed chunk size", data: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n"},
+		{name: "missing chunk crlf", data: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nabc\r\n0\r\n\r\n"},
+		{name: "body shorter than content-length", data: "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort"},
+		{name: "incomplete head", data: "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ResponseFromReader(&chunkReader{data: tt.data, numBytesPerRead: 4})
+			assert.Error(t, err)
+		})
+	}
+
+	// Test: Header section size is limited
+	huge := "HTTP/1.1 200 OK\r\nX-Big: " + strings.Repeat("a", maxHeaderSize+1)
+	_, err := ResponseFromReader(strings.NewReader(huge))
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+}
+
+// endlessReader returns the same byte forever, like peer which never finishes its response
+type endlessReader byte
+
+func (e endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(e)
+	}
+	return len(p), nil
+}
+
+// manyFields returns field lines with distinct names which together are longer than maxHeaderSize
+func manyFields(prefix string) string {
+	var fields strings.Builder
+	for i := 0; fields.Len() <= maxHeaderSize; i++ {
+		fields.WriteString(prefix + "-" + strconv.Itoa(i) + ": value\r\n")
+	}
+	return fields.String()
+}
+
+func TestResponseFromReaderLimits(t *testing.T) {
+	chunked := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"
+	tests := []struct {
+		name   string
+		reader io.Reader
+		err    error
+	}{
+		{name: "status line never ends", reader: endlessReader('H'), err: ErrHeaderTooLarge},
+		{name: "many headers", reader: strings.NewReader("HTTP/1.1 200 OK\r\n" + manyFields("X-Header") + "\r\n"), err: ErrHeaderTooLarge},
+		{name: "chunk size line never ends", reader: io.MultiReader(strings.NewReader(chunked+"5;ext="), endlessReader('a')), err: errChunkSizeLineTooLong},
+		{name: "many trailers", reader: strings.NewReader(chunked + "0\r\n" + manyFields("X-Trailer") + "\r\n"), err: ErrHeaderTooLarge},
+		{name: "trailer never ends", reader: io.MultiReader(strings.NewReader(chunked+"0\r\nX-Trailer: "), endlessReader('a')), err: ErrHeaderTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ResponseFromReader(tt.reader)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	// Test: Long body is not limited
+	body := strings.Repeat("a", 2*maxHeaderSize)
+	resp, err := ResponseFromReader(strings.NewReader(chunked + strconv.FormatInt(int64(len(body)), 16) + "\r\n" + body + "\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Len(t, resp.Body, len(body))
+}
+
+func TestReader(t *testing.T) {
+	stream := "HTTP/1.1 100 Continue\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nfirst" +
+		"HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nsecond\r\n0\r\n\r\n"
+	reader := NewReader(&chunkReader{data: stream, numBytesPerRead: 7})
+
+	// Test: Interim response is returned on its own
+	r, err := reader.ReadHead("POST")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(100), r.StatusLine.StatusCode)
+	assert.Equal(t, ParsingDone, r.ParsingState)
+
+	r, err = reader.ReadHead("POST")
+	require.NoError(t, err)
+	body, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(body))
+
+	// Test: Response to HEAD has no body
+	r, err = reader.ReadHead("HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), r.ContentLength)
+	body, err = io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Empty(t, body)
+
+	// Test: Body is streamed
+	r, err = reader.ReadHead("GET")
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	n, err := reader.Read(buf)
+	require.NoError(t, err)
+	assert.Less(t, n, len("second"))
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(buf[:n])+string(rest))
+	assert.Equal(t, ParsingDone, r.ParsingState)
+	assert.Equal(t, 0, reader.Buffered())
+	assert.Equal(t, len(stream), reader.Received())
+
+	_, err = reader.ReadHead("GET")
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestResponseFromWriter(t *testing.T) {
+	// Test: Parser reads what Writer writes
+	var buf bytes.Buffer
+	w := NewWritter(&buf)
+	w.WriteStatusLine(NotFoundStatusCode)
+	w.Header().Set("Trailer", "X-Done")
+	body, err := w.Body()
+	require.NoError(t, err)
+	body.Write([]byte("not "))
+	body.Write([]byte("found"))
+	w.Trailer().Set("X-Done", "yes")
+	require.NoError(t, body.Close())
+	require.NoError(t, w.Finish())
+
+	r, err := ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, NotFoundStatusCode, r.StatusLine.StatusCode)
+	assert.Equal(t, "Not Found", r.StatusLine.ReasonPhrase)
+	assert.Equal(t, "not found", string(r.Body))
+	assert.Equal(t, "yes", r.Trailers["x-done"])
+}
@@ -1,9 +1,14 @@
 package response
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/MichalGul/http_server_go/internal/headers"
 )
@@ -11,11 +16,56 @@ import (
 type StatusCode int
 
 const (
-	OkStatusCode                  StatusCode = 200
-	BadRequestStatusCode          StatusCode = 400
-	InternalServerErrorStatusCode StatusCode = 500
+	SwitchingProtocolsStatusCode   StatusCode = 101
+	OkStatusCode                   StatusCode = 200
+	NoContentStatusCode            StatusCode = 204
+	PartialContentStatusCode       StatusCode = 206
+	MovedPermanentlyStatusCode     StatusCode = 301
+	NotModifiedStatusCode          StatusCode = 304
+	BadRequestStatusCode           StatusCode = 400
+	UnauthorizedStatusCode         StatusCode = 401
+	ForbiddenStatusCode            StatusCode = 403
+	NotFoundStatusCode             StatusCode = 404
+	MethodNotAllowedStatusCode     StatusCode = 405
+	PreconditionFailedStatusCode   StatusCode = 412
+	ContentTooLargeStatusCode      StatusCode = 413
+	RangeNotSatisfiableStatusCode  StatusCode = 416
+	UpgradeRequiredStatusCode      StatusCode = 426
+	HeaderFieldsTooLargeStatusCode StatusCode = 431
+	InternalServerErrorStatusCode  StatusCode = 500
+	BadGatewayStatusCode           StatusCode = 502
+	ServiceUnavailableStatusCode   StatusCode = 503
+	GatewayTimeoutStatusCode       StatusCode = 504
 )
 
+var statusText = map[StatusCode]string{
+	SwitchingProtocolsStatusCode:   "Switching Protocols",
+	OkStatusCode:                   "OK",
+	NoContentStatusCode:            "No Content",
+	PartialContentStatusCode:       "Partial Content",
+	MovedPermanentlyStatusCode:     "Moved Permanently",
+	NotModifiedStatusCode:          "Not Modified",
+	BadRequestStatusCode:           "Bad Request",
+	UnauthorizedStatusCode:         "Unauthorized",
+	ForbiddenStatusCode:            "Forbidden",
+	NotFoundStatusCode:             "Not Found",
+	MethodNotAllowedStatusCode:     "Method Not Allowed",
+	PreconditionFailedStatusCode:   "Precondition Failed",
+	ContentTooLargeStatusCode:      "Content Too Large",
+	RangeNotSatisfiableStatusCode:  "Range Not Satisfiable",
+	UpgradeRequiredStatusCode:      "Upgrade Required",
+	HeaderFieldsTooLargeStatusCode: "Request Header Fields Too Large",
+	InternalServerErrorStatusCode:  "Internal Server Error",
+	BadGatewayStatusCode:           "Bad Gateway",
+	ServiceUnavailableStatusCode:   "Service Unavailable",
+	GatewayTimeoutStatusCode:       "Gateway Timeout",
+}
+
+// StatusText returns reason phrase for status code, empty string if code is unknown
+func StatusText(statusCode StatusCode) string {
+	return statusText[statusCode]
+}
+
 type WriteState int
 
 const (
@@ -27,16 +77,287 @@ const (
 
 const crlf = "\r\n"
 
+// Size of pooled buffer response is written through before reaching connection
+const writeBufferSize = 4096
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		return bufio.NewWriterSize(nil, writeBufferSize)
+	},
+}
+
+// Writer writes response through pooled buffer, data reaches connection when buffer fills up,
+// on Flush or when response is finished with Finish.
 type Writer struct {
 	Connection io.Writer
 	WriteState WriteState
+	buffer     *bufio.Writer
+	scratch    [32]byte
+
+	// headers sent with WriteHeaders, handlers can prepare them upfront with Header()
+	header  headers.Headers
+	trailer headers.Headers
+	// body framing resolved from headers when they were written, contentLength is -1 when not set
+	contentLength int64
+	chunked       bool
+	// last chunk was written but trailer section is still open
+	trailersPending bool
+	// lowercased field names announced in Trailer header
+	announcedTrailers []string
+	// body bytes are dropped, response to HEAD request
+	discardBody bool
+	statusCode  StatusCode
+	// body bytes written to connection, without chunk framing
+	bodyWritten int64
+	// last chunk of chunked body was written
+	lastChunkWritten bool
+
+	closeNotifyOnce sync.Once
+	closeNotify     chan struct{}
+
+	// connection was taken over by handler
+	hijacked bool
+	// bytes already read from connection past the request, handed over on Hijack
+	buffered []byte
+
+	// run by Finish before response is completed
+	finishHooks []func()
 }
 
 func NewWritter(conn io.Writer) *Writer {
 	return &Writer{
-		Connection: conn,
-		WriteState: Initialize,
+		Connection:    conn,
+		WriteState:    Initialize,
+		contentLength: -1,
+	}
+}
+
+// Header returns headers which will be sent with response. Handlers can modify them until
+// headers are written, values passed to WriteHeaders are merged in and take precedence.
+func (w *Writer) Header() headers.Headers {
+	if w.header == nil {
+		w.header = headers.NewHeaders()
+	}
+	return w.header
+}
+
+// Trailer returns trailer fields sent after last chunk of chunked body.
+// Only fields announced in Trailer header and allowed in trailer section are sent, use SetTrailer
+// to get that validated immediately.
+func (w *Writer) Trailer() headers.Headers {
+	if w.trailer == nil {
+		w.trailer = headers.NewHeaders()
+	}
+	return w.trailer
+}
+
+// SetTrailer sets trailer field value, it can be called any time before body is finished.
+// Field has to be announced in Trailer header and must not be one of fields forbidden in trailers.
+func (w *Writer) SetTrailer(name, value string) error {
+	err := w.validateTrailer(name)
+	if err != nil {
+		return err
+	}
+	w.Trailer().Set(name, value)
+	return nil
+}
+
+func (w *Writer) validateTrailer(name string) error {
+	if headers.IsForbiddenTrailer(name) {
+		return fmt.Errorf("error: %s is not allowed in trailer section", name)
+	}
+
+	announced := w.announcedTrailers
+	if w.WriteState < HeadersWrote {
+		// headers not sent yet, Trailer can still be set by handler
+		trailerValue, _ := w.Header().Get("Trailer")
+		announced = headers.ParseTrailerNames(trailerValue)
+	}
+	for _, announcedName := range announced {
+		if strings.EqualFold(announcedName, name) {
+			return nil
+		}
+	}
+	return fmt.Errorf("error: trailer %s was not announced in Trailer header", name)
+}
+
+// DiscardBody makes writer drop everything written as body while status line and headers
+// (including Content-Length) are still sent. Server uses it for responses to HEAD requests.
+func (w *Writer) DiscardBody() {
+	w.discardBody = true
+}
+
+// CloseNotify returns channel closed when client closes the connection.
+// It starts reading from the connection in background, so it is meant for long running responses
+// after which connection is closed (streams), anything client sends meanwhile is discarded.
+// When writer is not writing to network connection, returned channel is never closed.
+func (w *Writer) CloseNotify() <-chan struct{} {
+	w.closeNotifyOnce.Do(func() {
+		w.closeNotify = make(chan struct{})
+		reader, ok := w.Connection.(net.Conn)
+		if !ok {
+			return
+		}
+		go func() {
+			defer close(w.closeNotify)
+			buf := make([]byte, 512)
+			for {
+				_, err := reader.Read(buf)
+				if err != nil {
+					return
+				}
+			}
+		}()
+	})
+	return w.closeNotify
+}
+
+// ErrHijacked is returned when response is written after connection was hijacked
+var ErrHijacked = errors.New("response: connection has been hijacked")
+
+// hijackedConnection replaces connection of hijacked writer so nothing reaches the client anymore
+type hijackedConnection struct{}
+
+func (hijackedConnection) Write(p []byte) (int, error) {
+	return 0, ErrHijacked
+}
+
+// SetBuffered stores bytes read from connection after the request which Hijack hands over to handler.
+// Server sets it from request.Request.Buffered before calling handler.
+func (w *Writer) SetBuffered(data []byte) {
+	w.buffered = data
+}
+
+// Hijack lets handler take over the connection, for protocols switching away from HTTP (WebSocket,
+// CONNECT tunnels). It returns connection and bytes client already sent after the request, they have
+// to be consumed before reading from connection.
+// Response written so far (e.g. 101 Switching Protocols) is flushed first. After Hijack the writer
+// can't be used anymore and server neither finishes the response nor closes the connection,
+// handler is responsible for closing it.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.hijacked {
+		return nil, nil, ErrHijacked
+	}
+	conn, ok := w.Connection.(net.Conn)
+	if !ok {
+		return nil, nil, fmt.Errorf("error: connection can't be hijacked")
+	}
+
+	if w.buffer != nil {
+		err := w.buffer.Flush()
+		w.buffer.Reset(nil)
+		bufferPool.Put(w.buffer)
+		w.buffer = nil
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	w.Connection = hijackedConnection{}
+	w.hijacked = true
+	buffered := w.buffered
+	w.buffered = nil
+	return conn, buffered, nil
+}
+
+// Hijacked reports whether connection was taken over with Hijack
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
+
+// bodyOut returns destination for body bytes
+func (w *Writer) bodyOut() io.Writer {
+	if w.discardBody {
+		return io.Discard
+	}
+	return w.out()
+}
+
+// countBody records body bytes written through bodyOut, discarded bytes are not counted
+func (w *Writer) countBody(n int) {
+	if !w.discardBody {
+		w.bodyWritten += int64(n)
+	}
+}
+
+// out returns buffered writer for connection, buffer is taken from pool on first write
+func (w *Writer) out() *bufio.Writer {
+	if w.buffer == nil {
+		w.buffer = bufferPool.Get().(*bufio.Writer)
+		w.buffer.Reset(w.Connection)
+	}
+	return w.buffer
+}
+
+// Flush sends buffered data to the connection.
+// Streaming handlers call it when client should receive data written so far.
+func (w *Writer) Flush() error {
+	if w.buffer == nil {
+		return nil
+	}
+	return w.buffer.Flush()
+}
+
+// StatusCode returns status code written with WriteStatusLine, 0 when nothing was written
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// BodyBytes returns number of body bytes sent to the connection, chunk framing is not counted
+// and body of response to HEAD request is not sent at all
+func (w *Writer) BodyBytes() int64 {
+	return w.bodyWritten
+}
+
+// KeepAlive reports whether connection can serve next request after this response is finished.
+// It is true only when whole response was written with body length client can tell,
+// Connection: close was not sent and nothing else (Hijack, CloseNotify) uses the connection.
+func (w *Writer) KeepAlive() bool {
+	if w.hijacked || w.closeNotify != nil || w.WriteState < HeadersWrote {
+		return false
+	}
+	if connection, exists := w.header.Get("Connection"); exists && headers.HasToken(connection, "close") {
+		return false
+	}
+	if w.discardBody || !hasBody(w.statusCode) {
+		return true
+	}
+	if w.chunked {
+		return w.lastChunkWritten
+	}
+	return w.contentLength >= 0 && w.bodyWritten == w.contentLength
+}
+
+// hasBody reports whether response with status code carries body
+func hasBody(statusCode StatusCode) bool {
+	return statusCode >= 200 && statusCode != NoContentStatusCode && statusCode != NotModifiedStatusCode
+}
+
+// OnFinish registers f to be called at the start of Finish, once handler returned.
+// Background goroutines writing to the response use it to stop before server completes the response.
+func (w *Writer) OnFinish(f func()) {
+	w.finishHooks = append(w.finishHooks, f)
+}
+
+// Finish terminates chunked message left without trailer section, flushes remaining data
+// and returns buffer to the pool.
+// Server calls it after handler returns, Writer used outside of server has to call it as well.
+func (w *Writer) Finish() error {
+	hooks := w.finishHooks
+	w.finishHooks = nil
+	for _, hook := range hooks {
+		hook()
+	}
+	if w.trailersPending {
+		w.WriteTrailers(nil)
 	}
+	if w.buffer == nil {
+		return nil
+	}
+	err := w.buffer.Flush()
+	w.buffer.Reset(nil)
+	bufferPool.Put(w.buffer)
+	w.buffer = nil
+	return err
 }
 
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
@@ -45,22 +366,45 @@ func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 		return fmt.Errorf("error: atempt to write to response in incorrect state")
 	}
 
-	byteStatusLine := getStatusLine(statusCode)
-	_, err := w.Connection.Write(byteStatusLine)
+	_, err := w.out().Write(appendStatusLine(w.scratch[:0], statusCode))
 	if err != nil {
 		return err
 	}
+	w.statusCode = statusCode
 	w.WriteState = StatusLineWrote
 	return nil
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
+func (w *Writer) WriteHeaders(h headers.Headers) error {
 
 	if w.WriteState != StatusLineWrote {
 		return fmt.Errorf("error: atempt to write headers in incorrect state")
 	}
 
-	err := WriteHeaders(w.Connection, headers)
+	pending := w.Header()
+	for name, value := range h {
+		pending.Set(name, value)
+	}
+	w.resolveFraming()
+	// Client has to be told when connection ends with this response (RFC 9112 section 9.6)
+	if w.closeNotify != nil || w.closeDelimited() {
+		if connection, _ := pending.Get("Connection"); !headers.HasToken(connection, "close") {
+			pending.Set("Connection", "close")
+		}
+	}
+
+	w.announcedTrailers = nil
+	if trailerValue, exists := pending.Get("Trailer"); exists {
+		names := headers.ParseTrailerNames(trailerValue)
+		for _, name := range names {
+			if headers.IsForbiddenTrailer(name) {
+				return fmt.Errorf("error: %s can not be announced as trailer field", name)
+			}
+		}
+		w.announcedTrailers = names
+	}
+
+	err := writeFields(w.out(), pending)
 	if err != nil {
 		return err
 	}
@@ -69,6 +413,38 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 	return nil
 }
 
+// resolveFraming checks how body will be delimited based on headers about to be sent
+func (w *Writer) resolveFraming() {
+	w.contentLength = -1
+	w.chunked = false
+	if !hasBody(w.statusCode) {
+		// 1xx, 204 and 304 never carry body, only 304 may tell length of representation (RFC 9110 section 8.6)
+		w.header.Del("Transfer-Encoding")
+		if w.statusCode != NotModifiedStatusCode {
+			w.header.Del("Content-Length")
+		}
+		w.contentLength = 0
+		return
+	}
+	if transferEncoding, exists := w.header.Get("Transfer-Encoding"); exists {
+		w.chunked = strings.EqualFold(strings.TrimSpace(transferEncoding), "chunked")
+		// Content-Length must not be sent together with Transfer-Encoding (RFC 9112 section 6.2)
+		w.header.Del("Content-Length")
+		return
+	}
+	if contentLength, exists := w.header.Get("Content-Length"); exists {
+		length, err := strconv.ParseInt(strings.TrimSpace(contentLength), 10, 64)
+		if err == nil && length >= 0 {
+			w.contentLength = length
+		}
+	}
+}
+
+// closeDelimited reports whether body has no framing and ends when connection is closed
+func (w *Writer) closeDelimited() bool {
+	return !w.chunked && w.contentLength < 0 && !w.discardBody && hasBody(w.statusCode)
+}
+
 func (w *Writer) WriteBody(p []byte) (int, error) {
 
 	if w.WriteState != HeadersWrote {
@@ -76,80 +452,142 @@ func (w *Writer) WriteBody(p []byte) (int, error) {
 	}
 
 	w.WriteState = BodyWrote
-	return w.Connection.Write(p)
+	if w.discardBody {
+		return len(p), nil
+	}
+	n, err := w.out().Write(p)
+	w.bodyWritten += int64(n)
+	return n, err
+}
+
+// WriteBodyFrom streams body from reader to the connection instead of buffering it whole in memory.
+// Caller is responsible for setting Content-Length matching number of bytes reader will produce
+func (w *Writer) WriteBodyFrom(r io.Reader) (int64, error) {
+	return w.ReadFrom(r)
 }
 
+// WriteChunkedBody writes p as single chunk of chunked body, returns number of bytes of p written
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 
 	if w.WriteState != HeadersWrote {
 		return 0, fmt.Errorf("error: atempt to write body in incorrect state")
 	}
 
-	chunkLength := len(p)
-	chunkLengthHex := fmt.Sprintf("%x", chunkLength)
-
-	chunkMessage := []byte(fmt.Sprintf("%s\r\n%s\r\n", chunkLengthHex, string(p)))
-	return w.Connection.Write(chunkMessage)
+	return w.writeChunk(p)
+}
 
+func (w *Writer) writeChunk(p []byte) (int, error) {
+	if len(p) == 0 {
+		// empty chunk would terminate the body
+		return 0, nil
+	}
+	if w.discardBody {
+		return len(p), nil
+	}
+	out := w.out()
+	out.Write(strconv.AppendInt(w.scratch[:0], int64(len(p)), 16))
+	out.WriteString(crlf)
+	n, err := out.Write(p)
+	w.bodyWritten += int64(n)
+	if err != nil {
+		return n, err
+	}
+	_, err = out.WriteString(crlf)
+	return n, err
 }
 
+// WriteChunkedBodyDone writes last chunk. Trailer section is written with WriteTrailers,
+// when handler does not call it message is terminated when response is finished.
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
 
 	w.WriteState = BodyWrote
-	return w.Connection.Write([]byte("0\r\n"))
+	if w.discardBody {
+		return 0, nil
+	}
+	w.trailersPending = true
+	w.lastChunkWritten = true
+	return w.out().WriteString("0\r\n")
 }
 
+// WriteTrailers writes trailer section terminating chunked message. Fields from h are merged
+// with ones set with Trailer/SetTrailer. Fields not announced in Trailer header or forbidden
+// in trailers are dropped and reported as error, message is terminated correctly anyway.
 func (w *Writer) WriteTrailers(h headers.Headers) error {
 
-	if w.WriteState != BodyWrote{
+	if w.WriteState != BodyWrote || !w.trailersPending {
 		return fmt.Errorf("error: Body was not send fully. Cannot write trailers")
 	}
+	w.trailersPending = false
 
-
+	fields := headers.NewHeaders()
+	for name, value := range w.trailer {
+		fields[name] = value
+	}
 	for name, value := range h {
-		_, err := w.Connection.Write([]byte(fmt.Sprintf("%s: %s\r\n", name, value)))
-		if err != nil {
-			return err
+		fields.Set(name, value)
+	}
+
+	var rejected []string
+	for name := range fields {
+		if w.validateTrailer(name) != nil {
+			rejected = append(rejected, name)
+			delete(fields, name)
 		}
 	}
-	w.Connection.Write([]byte("\r\n"))
+
+	err := writeFields(w.out(), fields)
+	if err != nil {
+		return err
+	}
+	if len(rejected) > 0 {
+		return fmt.Errorf("error: trailer fields not announced or not allowed: %s", strings.Join(rejected, ", "))
+	}
 	return nil
+}
 
+func appendStatusLine(dst []byte, statusCode StatusCode) []byte {
+	dst = append(dst, "HTTP/1.1 "...)
+	dst = strconv.AppendInt(dst, int64(statusCode), 10)
+	dst = append(dst, ' ')
+	dst = append(dst, StatusText(statusCode)...)
+	return append(dst, crlf...)
 }
 
-func getStatusLine(statusCode StatusCode) []byte {
-	reasonPhrase := ""
-	switch statusCode {
-	case OkStatusCode:
-		reasonPhrase = "OK"
-	case BadRequestStatusCode:
-		reasonPhrase = "Bad Request"
-	case InternalServerErrorStatusCode:
-		reasonPhrase = "Internal Server Error"
+// writeFields writes field lines followed by empty line ending the section
+func writeFields(out *bufio.Writer, fields headers.Headers) error {
+	for name, value := range fields {
+		out.WriteString(name)
+		out.WriteString(": ")
+		out.WriteString(value)
+		out.WriteString(crlf)
 	}
-	return []byte(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, reasonPhrase))
+	_, err := out.WriteString(crlf)
+	return err
 }
 
+// GetDefaultHeaders returns headers describing plain text body of contentLen bytes.
+// Connection management, Date and Server headers are added by the server.
 func GetDefaultHeaders(contentLen int) headers.Headers {
 
 	headers := headers.NewHeaders()
 	headers["Content-Length"] = strconv.Itoa(contentLen)
-	headers["Connection"] = "close"
 	headers["Content-Type"] = "text/plain"
 
 	return headers
 }
 
+// WriteHeaders writes header section to w with single Write call
 func WriteHeaders(w io.Writer, headers headers.Headers) error {
 
+	var section []byte
 	for name, value := range headers {
-		_, err := w.Write([]byte(fmt.Sprintf("%s: %s\r\n", name, value)))
-		if err != nil {
-			return err
-		}
+		section = append(section, name...)
+		section = append(section, ": "...)
+		section = append(section, value...)
+		section = append(section, crlf...)
 	}
-	w.Write([]byte("\r\n"))
+	section = append(section, crlf...)
 
-	return nil
+	_, err := w.Write(section)
+	return err
 }
-
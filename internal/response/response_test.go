@@ -0,0 +1,60 @@
+package response
+
+import (
+	"bytes"
+	"testing"
+)
+
+// countingWriter counts Write calls, each of them would be separate syscall on network connection
+type countingWriter struct {
+	writes int
+	bytes  int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.writes++
+	c.bytes += len(p)
+	return len(p), nil
+}
+
+var benchmarkBody = bytes.Repeat([]byte("x"), 1024)
+
+func BenchmarkWriteResponse(b *testing.B) {
+	conn := &countingWriter{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := NewWritter(conn)
+		w.WriteStatusLine(OkStatusCode)
+		h := GetDefaultHeaders(len(benchmarkBody))
+		h.Set("Content-Type", "text/html")
+		h.Set("ETag", `"abc"`)
+		h.Set("Cache-Control", "max-age=60")
+		w.WriteHeaders(h)
+		w.WriteBody(benchmarkBody)
+		w.Finish()
+	}
+	b.ReportMetric(float64(conn.writes)/float64(b.N), "writes/op")
+}
+
+func BenchmarkWriteChunkedResponse(b *testing.B) {
+	conn := &countingWriter{}
+	chunk := benchmarkBody[:256]
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := NewWritter(conn)
+		w.WriteStatusLine(OkStatusCode)
+		h := GetDefaultHeaders(0)
+		delete(h, "Content-Length")
+		h.Set("Transfer-Encoding", "chunked")
+		w.WriteHeaders(h)
+		for j := 0; j < 16; j++ {
+			w.WriteChunkedBody(chunk)
+		}
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(nil)
+		w.Finish()
+	}
+	b.ReportMetric(float64(conn.writes)/float64(b.N), "writes/op")
+}
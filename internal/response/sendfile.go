@@ -0,0 +1,51 @@
+package response
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// ReadFrom implements io.ReaderFrom, it writes body read from r until EOF.
+// When connection implements io.ReaderFrom (*net.TCPConn does) copying is delegated to it,
+// so file or socket sources are transmitted by kernel with sendfile/splice without passing through user space.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+
+	if w.WriteState != HeadersWrote {
+		return 0, fmt.Errorf("error: atempt to write body in incorrect state")
+	}
+	w.WriteState = BodyWrote
+	if w.discardBody {
+		return 0, nil
+	}
+
+	// Status line and headers have to reach connection before body bypasses the buffer
+	err := w.Flush()
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	if readerFrom, ok := w.Connection.(io.ReaderFrom); ok {
+		n, err = readerFrom.ReadFrom(r)
+	} else {
+		n, err = io.Copy(w.Connection, r)
+	}
+	w.bodyWritten += n
+	return n, err
+}
+
+// WriteFile writes length bytes of file starting at offset as response body.
+// Passing file wrapped only in io.LimitedReader keeps it recognizable for the sendfile fast path.
+func (w *Writer) WriteFile(f *os.File, offset, length int64) (int64, error) {
+
+	if w.WriteState != HeadersWrote {
+		return 0, fmt.Errorf("error: atempt to write body in incorrect state")
+	}
+
+	_, err := f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	return w.ReadFrom(&io.LimitedReader{R: f, N: length})
+}
@@ -0,0 +1,153 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tcpPair returns connected server and client side of loopback TCP connection
+func tcpPair(tb testing.TB) (net.Conn, net.Conn) {
+	tb.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(tb, err)
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(tb, err)
+	serverConn, err := listener.Accept()
+	require.NoError(tb, err)
+
+	tb.Cleanup(func() {
+		client.Close()
+		serverConn.Close()
+	})
+	return serverConn, client
+}
+
+func writeTestFile(tb testing.TB, size int) (string, []byte) {
+	tb.Helper()
+	data := bytes.Repeat([]byte("0123456789abcdef"), size/16)
+	name := filepath.Join(tb.TempDir(), "data.bin")
+	require.NoError(tb, os.WriteFile(name, data, 0o644))
+	return name, data
+}
+
+// bodyWriter returns writer in state ready for body
+func bodyWriter(conn io.Writer) *Writer {
+	w := NewWritter(conn)
+	w.WriteState = HeadersWrote
+	return w
+}
+
+func TestWriteFile(t *testing.T) {
+	name, data := writeTestFile(t, 1<<16)
+	file, err := os.Open(name)
+	require.NoError(t, err)
+	defer file.Close()
+
+	// Test: Plain writer falls back to copying
+	var buf bytes.Buffer
+	n, err := bodyWriter(&buf).WriteFile(file, 100, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), n)
+	assert.Equal(t, data[100:1100], buf.Bytes())
+
+	// Test: TCP connection
+	serverConn, client := tcpPair(t)
+	received := make(chan []byte)
+	go func() {
+		got, _ := io.ReadAll(client)
+		received <- got
+	}()
+	n, err = bodyWriter(serverConn).WriteFile(file, 0, int64(len(data)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	serverConn.Close()
+	assert.Equal(t, data, <-received)
+
+	// Test: Incorrect state
+	_, err = NewWritter(&buf).WriteFile(file, 0, 1)
+	require.Error(t, err)
+}
+
+const benchmarkFileSize = 8 << 20
+
+// benchmarkTransfer sends benchmark file over loopback TCP connection b.N times using send function
+func benchmarkTransfer(b *testing.B, send func(w *Writer, name string) error) {
+	name, _ := writeTestFile(b, benchmarkFileSize)
+	serverConn, client := tcpPair(b)
+
+	done := make(chan int64)
+	go func() {
+		n, _ := io.Copy(io.Discard, client)
+		done <- n
+	}()
+
+	b.SetBytes(benchmarkFileSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := bodyWriter(serverConn)
+		err := send(w, name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		// flush and return pooled buffer like server does after every response
+		if err := w.Finish(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	serverConn.Close()
+	received := <-done
+	b.StopTimer()
+
+	if received != int64(b.N)*benchmarkFileSize {
+		b.Fatalf("received %d bytes, expected %d", received, int64(b.N)*benchmarkFileSize)
+	}
+}
+
+// Previous videoHandler approach, whole file read into memory and written from user space
+func BenchmarkWriteBodyReadFile(b *testing.B) {
+	benchmarkTransfer(b, func(w *Writer, name string) error {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		_, err = w.WriteBody(data)
+		return err
+	})
+}
+
+// Streaming copy through user space buffer, connection hidden behind plain io.Writer
+func BenchmarkWriteBodyFromCopy(b *testing.B) {
+	benchmarkTransfer(b, func(w *Writer, name string) error {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w.Connection = struct{ io.Writer }{w.Connection}
+		_, err = w.WriteBodyFrom(file)
+		return err
+	})
+}
+
+// Zero copy path, kernel sends file with sendfile
+func BenchmarkWriteFile(b *testing.B) {
+	benchmarkTransfer(b, func(w *Writer, name string) error {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = w.WriteFile(file, 0, benchmarkFileSize)
+		return err
+	})
+}
@@ -0,0 +1,117 @@
+package response
+
+import (
+	"io"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// Number of bytes from beginning of the content used for sniffing
+const sniffLen = 512
+
+// detectContentType checks file extension first, when unknown sniffs beginning of the content
+// and rewinds it back so it can be streamed from the start
+func detectContentType(content io.ReadSeeker, name string) (string, error) {
+	contentType := contentTypeByExtension(filepath.Ext(name))
+	if contentType != "" {
+		return contentType, nil
+	}
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(content, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	_, err = content.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	return DetectContentType(buf[:n]), nil
+}
+
+// Types commonly served that are missing from mime package builtin table
+var extensionTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".mp3":  "audio/mpeg",
+	".ogg":  "audio/ogg",
+	".txt":  "text/plain; charset=utf-8",
+	".md":   "text/markdown; charset=utf-8",
+	".ico":  "image/x-icon",
+}
+
+func contentTypeByExtension(ext string) string {
+	ext = strings.ToLower(ext)
+	if contentType, ok := extensionTypes[ext]; ok {
+		return contentType
+	}
+	if ext == "" {
+		return ""
+	}
+	return mime.TypeByExtension(ext)
+}
+
+type signature struct {
+	prefix      string
+	offset      int
+	contentType string
+}
+
+var signatures = []signature{
+	{prefix: "%PDF-", contentType: "application/pdf"},
+	{prefix: "\x89PNG\r\n\x1a\n", contentType: "image/png"},
+	{prefix: "\xff\xd8\xff", contentType: "image/jpeg"},
+	{prefix: "GIF87a", contentType: "image/gif"},
+	{prefix: "GIF89a", contentType: "image/gif"},
+	{prefix: "ftypisom", offset: 4, contentType: "video/mp4"},
+	{prefix: "ftypmp4", offset: 4, contentType: "video/mp4"},
+	{prefix: "\x1a\x45\xdf\xa3", contentType: "video/webm"},
+	{prefix: "ID3", contentType: "audio/mpeg"},
+	{prefix: "OggS", contentType: "application/ogg"},
+	{prefix: "PK\x03\x04", contentType: "application/zip"},
+	{prefix: "\x1f\x8b\x08", contentType: "application/x-gzip"},
+	{prefix: "\x00asm", contentType: "application/wasm"},
+}
+
+var htmlPrefixes = []string{"<!doctype html", "<html", "<head", "<body", "<title", "<script", "<p", "<h1", "<div"}
+
+// DetectContentType sniffs content type from the beginning of the data, only first 512 bytes are considered.
+// Returns "application/octet-stream" when no match was found.
+func DetectContentType(data []byte) string {
+	if len(data) > sniffLen {
+		data = data[:sniffLen]
+	}
+
+	for _, sig := range signatures {
+		if len(data) >= sig.offset+len(sig.prefix) && string(data[sig.offset:sig.offset+len(sig.prefix)]) == sig.prefix {
+			return sig.contentType
+		}
+	}
+
+	trimmed := strings.ToLower(strings.TrimLeft(string(data), " \t\r\n"))
+	for _, prefix := range htmlPrefixes {
+		if strings.HasPrefix(trimmed, prefix) {
+			return "text/html; charset=utf-8"
+		}
+	}
+	if strings.HasPrefix(trimmed, "<?xml") {
+		return "text/xml; charset=utf-8"
+	}
+
+	if isText(data) {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+// isText reports whether data does not contain binary control bytes
+func isText(data []byte) bool {
+	for _, b := range data {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != 0x1b {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+	"github.com/MichalGul/http_server_go/internal/metrics"
+)
+
+// Config configures server started with ServeConfig
+type Config struct {
+	// Port to listen on, 0 picks random free port (see Server.Addr)
+	Port    int
+	Handler Handler
+
+	// ServerName is sent in Server header, header is omitted when empty
+	ServerName string
+	// DisableDate stops server from adding Date header to responses
+	DisableDate bool
+	// DefaultHeaders are added to every response. Handler overrides them by writing
+	// header with the same name or removes them with Writer.Header().Del before writing headers.
+	DefaultHeaders headers.Headers
+	// IdleTimeout limits how long persistent connection waits for next request,
+	// defaultIdleTimeout is used when 0
+	IdleTimeout time.Duration
+	// Logger receives server events, server logs nothing when it is nil. Entries carry conn_id
+	// and remote_addr fields, entries about request also request_id. Handlers log with
+	// the same fields through request.Request.Logger.
+	Logger *slog.Logger
+	// Metrics is registry server records its metrics in, nothing is recorded when nil.
+	// Expose it by mounting Registry.Serve, registry can't be shared by two servers.
+	Metrics *metrics.Registry
+
+	// MaxConnections limits number of connections served at once, 0 means no limit.
+	// What happens to connections over the limit is chosen by OverloadPolicy.
+	MaxConnections int
+	// MaxConnectionsPerIP limits connections from single client IP, 0 means no limit.
+	// Connections over this limit are always rejected, blocking would stall other clients.
+	MaxConnectionsPerIP int
+	// OverloadPolicy says what server does when MaxConnections is reached
+	OverloadPolicy OverloadPolicy
+	// RetryAfter is sent in Retry-After header of rejected connections, defaultRetryAfter
+	// is used when 0. It is rounded up to whole seconds.
+	RetryAfter time.Duration
+	// MaxBodySize limits request body, longer requests are answered with 413 Content Too Large
+	// and connection is closed. defaultMaxBodySize is used when 0, negative value disables the limit.
+	MaxBodySize int64
+}
+
+// OverloadPolicy is server behaviour when connection limit is reached
+type OverloadPolicy int
+
+const (
+	// OverloadBlock stops accepting connections until one of served connections closes,
+	// new connections wait in listen backlog of the kernel
+	OverloadBlock OverloadPolicy = iota
+	// OverloadReject accepts connection and answers 503 Service Unavailable with Retry-After
+	OverloadReject
+)
+
+const (
+	defaultIdleTimeout = 2 * time.Minute
+	defaultRetryAfter  = 5 * time.Second
+	defaultMaxBodySize = 10 << 20
+)
+
+func (c Config) retryAfter() time.Duration {
+	if c.RetryAfter > 0 {
+		return c.RetryAfter
+	}
+	return defaultRetryAfter
+}
+
+func (c Config) maxBodySize() int64 {
+	switch {
+	case c.MaxBodySize > 0:
+		return c.MaxBodySize
+	case c.MaxBodySize < 0:
+		return 0
+	}
+	return defaultMaxBodySize
+}
+
+func (c Config) idleTimeout() time.Duration {
+	if c.IdleTimeout > 0 {
+		return c.IdleTimeout
+	}
+	return defaultIdleTimeout
+}
+
+// defaultHeaders fills response headers every handler starts with
+func (s *Server) defaultHeaders(h headers.Headers) {
+	if !s.config.DisableDate {
+		h.Set("Date", s.date.get())
+	}
+	if s.config.ServerName != "" {
+		h.Set("Server", s.config.ServerName)
+	}
+	for name, value := range s.config.DefaultHeaders {
+		h.Set(name, value)
+	}
+	// Connection won't serve another request once server is closing
+	if s.isClosed.Load() {
+		h.Set("Connection", "close")
+	}
+}
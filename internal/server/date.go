@@ -0,0 +1,38 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+)
+
+// dateCache keeps formatted Date header value, it is formatted at most once per second
+// instead of for every response
+type dateCache struct {
+	current atomic.Pointer[cachedDate]
+	now     func() time.Time
+}
+
+type cachedDate struct {
+	second int64
+	value  string
+}
+
+func (d *dateCache) get() string {
+	now := time.Now
+	if d.now != nil {
+		now = d.now
+	}
+	t := now()
+	second := t.Unix()
+
+	cached := d.current.Load()
+	if cached != nil && cached.second == second {
+		return cached.value
+	}
+
+	fresh := &cachedDate{second: second, value: headers.FormatTime(t)}
+	d.current.Store(fresh)
+	return fresh.value
+}
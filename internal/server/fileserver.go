@@ -0,0 +1,275 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/response"
+)
+
+const indexPage = "index.html"
+
+type FileServerOptions struct {
+	// ListDirectories enables generated HTML listing for directories without index.html
+	ListDirectories bool
+	// StripPrefix is removed from request target before resolving it inside root
+	StripPrefix string
+}
+
+type fileServer struct {
+	root    string
+	options FileServerOptions
+}
+
+// FileServer returns Handler serving files from root directory.
+// Directory listing is disabled, use NewFileServer to enable it.
+func FileServer(root string) Handler {
+	return NewFileServer(root, FileServerOptions{})
+}
+
+// NewFileServer returns Handler serving files from root directory configured with options.
+// Request paths are confined to root, attempts to escape it (.. segments or symlinks
+// pointing outside) are rejected.
+func NewFileServer(root string, options FileServerOptions) Handler {
+	fileServer := &fileServer{
+		root:    root,
+		options: options,
+	}
+	return fileServer.serve
+}
+
+// ServeFile streams single named file as response, content type is detected from
+// extension or from file content.
+func ServeFile(w *response.Writer, req *request.Request, name string) {
+	if !allowedFileMethod(w, req) {
+		return
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	if info.IsDir() {
+		HandlerError{StatusCode: response.ForbiddenStatusCode, Message: "Forbidden"}.Write(w)
+		return
+	}
+
+	serveContent(w, req, file, info)
+}
+
+func (s *fileServer) serve(w *response.Writer, req *request.Request) {
+	if !allowedFileMethod(w, req) {
+		return
+	}
+
+	urlPath, err := s.resolvePath(req.RequestLine.RequestTarget)
+	if err != nil {
+		HandlerError{StatusCode: response.BadRequestStatusCode, Message: err.Error()}.Write(w)
+		return
+	}
+
+	root, err := os.OpenRoot(s.root)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	defer root.Close()
+
+	// os.Root does not accept absolute paths, "." is the root itself
+	name := strings.TrimPrefix(urlPath, "/")
+	if name == "" {
+		name = "."
+	}
+
+	file, err := root.Open(name)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+
+	if !info.IsDir() {
+		serveContent(w, req, file, info)
+		return
+	}
+
+	// Relative links inside directory require trailing slash
+	if !strings.HasSuffix(urlPath, "/") {
+		location := path.Base(urlPath) + "/"
+		if _, query, ok := strings.Cut(req.RequestLine.RequestTarget, "?"); ok {
+			location += "?" + query
+		}
+		redirect(w, location)
+		return
+	}
+
+	index, err := root.Open(path.Join(name, indexPage))
+	if err == nil {
+		defer index.Close()
+		indexInfo, err := index.Stat()
+		if err == nil && !indexInfo.IsDir() {
+			serveContent(w, req, index, indexInfo)
+			return
+		}
+	}
+
+	if !s.options.ListDirectories {
+		HandlerError{StatusCode: response.ForbiddenStatusCode, Message: "Forbidden"}.Write(w)
+		return
+	}
+
+	serveDirectoryListing(w, req, file, urlPath)
+}
+
+// resolvePath extracts path from request target, strips configured prefix, decodes percent encoding
+// and rejects paths trying to traverse above root
+func (s *fileServer) resolvePath(target string) (string, error) {
+	rawPath, _, _ := strings.Cut(target, "?")
+	if !strings.HasPrefix(rawPath, "/") {
+		return "", fmt.Errorf("request target is not an absolute path: %s", target)
+	}
+
+	if s.options.StripPrefix != "" {
+		stripped, found := strings.CutPrefix(rawPath, s.options.StripPrefix)
+		// Prefix has to end at segment boundary, /assetsfoo is not inside /assets
+		if !found || (stripped != "" && stripped[0] != '/' && !strings.HasSuffix(s.options.StripPrefix, "/")) {
+			return "", fmt.Errorf("request target outside of served prefix: %s", target)
+		}
+		rawPath = "/" + strings.TrimPrefix(stripped, "/")
+	}
+
+	decoded, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return "", fmt.Errorf("malformed request path: %v", err)
+	}
+
+	if strings.ContainsRune(decoded, 0) || strings.Contains(decoded, "\\") {
+		return "", fmt.Errorf("invalid character in request path")
+	}
+
+	for _, segment := range strings.Split(decoded, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("path traversal is not allowed")
+		}
+	}
+
+	cleaned := path.Clean(decoded)
+	if strings.HasSuffix(decoded, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned, nil
+}
+
+func allowedFileMethod(w *response.Writer, req *request.Request) bool {
+	method := req.RequestLine.Method
+	if method == "GET" || method == "HEAD" {
+		return true
+	}
+
+	message := []byte("Method Not Allowed")
+	w.WriteStatusLine(response.MethodNotAllowedStatusCode)
+	h := response.GetDefaultHeaders(len(message))
+	h.Set("Allow", "GET, HEAD")
+	w.WriteHeaders(h)
+	w.WriteBody(message)
+	return false
+}
+
+// fileError maps filesystem errors to response status codes
+func fileError(w *response.Writer, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		HandlerError{StatusCode: response.NotFoundStatusCode, Message: "Not Found"}.Write(w)
+	case errors.Is(err, fs.ErrPermission):
+		HandlerError{StatusCode: response.ForbiddenStatusCode, Message: "Forbidden"}.Write(w)
+	case isRootEscape(err), errors.Is(err, syscall.ENOTDIR):
+		// path leaving root or going through regular file does not exist for client
+		HandlerError{StatusCode: response.NotFoundStatusCode, Message: "Not Found"}.Write(w)
+	default:
+		HandlerError{StatusCode: response.InternalServerErrorStatusCode, Message: "Internal Server Error"}.Write(w)
+	}
+}
+
+// os.Root error for path or symlink leading outside of it, error value itself is not exported
+const rootEscapeMessage = "path escapes from parent"
+
+func isRootEscape(err error) bool {
+	var pathErr *fs.PathError
+	return errors.As(err, &pathErr) && pathErr.Err != nil && pathErr.Err.Error() == rootEscapeMessage
+}
+
+func redirect(w *response.Writer, location string) {
+	w.WriteStatusLine(response.MovedPermanentlyStatusCode)
+	h := response.GetDefaultHeaders(0)
+	h.Set("Location", location)
+	w.WriteHeaders(h)
+}
+
+// serveContent streams file content with range and conditional requests support
+// without reading it whole into memory. Weak ETag is used so file does not have to be hashed,
+// If-Range needs strong validator so it only matches by Last-Modified date, entity tag in it
+// always sends whole file
+func serveContent(w *response.Writer, req *request.Request, file *os.File, info os.FileInfo) {
+	etag := response.WeakETag(info.ModTime(), info.Size())
+	response.ServeContent(w, req, info.Name(), info.ModTime(), etag, file)
+}
+
+func serveDirectoryListing(w *response.Writer, req *request.Request, dir *os.File, urlPath string) {
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var listing strings.Builder
+	title := html.EscapeString(urlPath)
+	listing.WriteString("<!doctype html>\n<html>\n<head>\n<title>Index of " + title + "</title>\n</head>\n<body>\n")
+	listing.WriteString("<h1>Index of " + title + "</h1>\n<ul>\n")
+	if urlPath != "/" {
+		listing.WriteString("<li><a href=\"../\">../</a></li>\n")
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		link := (&url.URL{Path: name}).EscapedPath()
+		listing.WriteString("<li><a href=\"" + html.EscapeString(link) + "\">" + html.EscapeString(name) + "</a></li>\n")
+	}
+	listing.WriteString("</ul>\n</body>\n</html>\n")
+
+	body := []byte(listing.String())
+	w.WriteStatusLine(response.OkStatusCode)
+	h := response.GetDefaultHeaders(len(body))
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeaders(h)
+
+	if req.RequestLine.Method == "HEAD" {
+		return
+	}
+	w.WriteBody(body)
+}
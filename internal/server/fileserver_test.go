@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRequest(method, target string) *request.Request {
+	return &request.Request{
+		RequestLine: request.RequestLine{
+			HttpVersion:   "1.1",
+			RequestTarget: target,
+			Method:        method,
+		},
+		ParsingState: request.Done,
+		Headers:      headers.NewHeaders(),
+	}
+}
+
+// serveTest runs handler with request and returns raw response written to connection
+func serveTest(handler Handler, req *request.Request) string {
+	var buf bytes.Buffer
+	w := response.NewWritter(&buf)
+	handler(w, req)
+	w.Finish()
+	return buf.String()
+}
+
+func setupFileServerRoot(t *testing.T) string {
+	t.Helper()
+	base := t.TempDir()
+	root := filepath.Join(base, "public")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "docs"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "site"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "hello.txt"), []byte("hello world"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "noext"), []byte("<html><body>hi</body></html>"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "a b.txt"), []byte("a"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "site", "index.html"), []byte("<h1>index</h1>"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(base, "secret.txt"), []byte("secret"), 0o644))
+	require.NoError(t, os.Symlink(filepath.Join(base, "secret.txt"), filepath.Join(root, "escape.txt")))
+	return root
+}
+
+func TestFileServer(t *testing.T) {
+	root := setupFileServerRoot(t)
+	handler := NewFileServer(root, FileServerOptions{ListDirectories: true})
+
+	// Test: Regular file with content type from extension
+	resp := serveTest(handler, newTestRequest("GET", "/hello.txt"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "Content-Type: text/plain; charset=utf-8\r\n")
+	assert.Contains(t, resp, "Content-Length: 11\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nhello world"))
+
+	// Test: Content type sniffed when extension is missing
+	resp = serveTest(handler, newTestRequest("GET", "/noext"))
+	assert.Contains(t, resp, "Content-Type: text/html; charset=utf-8\r\n")
+
+	// Test: Query string is ignored
+	resp = serveTest(handler, newTestRequest("GET", "/hello.txt?download=1"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: HEAD sends headers only
+	resp = serveTest(handler, newTestRequest("HEAD", "/hello.txt"))
+	assert.Contains(t, resp, "Content-Length: 11\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+
+	// Test: Missing file
+	resp = serveTest(handler, newTestRequest("GET", "/missing.txt"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Not allowed method
+	resp = serveTest(handler, newTestRequest("POST", "/hello.txt"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, resp, "Allow: GET, HEAD\r\n")
+
+	// Test: Directory index.html
+	resp = serveTest(handler, newTestRequest("GET", "/site/"))
+	assert.True(t, strings.HasSuffix(resp, "<h1>index</h1>"))
+
+	// Test: Directory without trailing slash is redirected
+	resp = serveTest(handler, newTestRequest("GET", "/site"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Contains(t, resp, "Location: site/\r\n")
+
+	// Test: Redirect keeps query string
+	resp = serveTest(handler, newTestRequest("GET", "/site?page=2&sort=name"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Contains(t, resp, "Location: site/?page=2&sort=name\r\n")
+
+	// Test: Directory listing with escaped names
+	resp = serveTest(handler, newTestRequest("GET", "/docs/"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, `<a href="a%20b.txt">a b.txt</a>`)
+	assert.Contains(t, resp, `<a href="../">../</a>`)
+
+	// Test: Percent encoded path
+	resp = serveTest(handler, newTestRequest("GET", "/docs/a%20b.txt"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestFileServerTraversal(t *testing.T) {
+	root := setupFileServerRoot(t)
+	handler := FileServer(root)
+
+	// Test: Dot dot segments
+	resp := serveTest(handler, newTestRequest("GET", "/../secret.txt"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"))
+	assert.NotContains(t, resp, "secret\n")
+
+	// Test: Encoded dot dot segments
+	resp = serveTest(handler, newTestRequest("GET", "/docs/%2e%2e/%2e%2e/secret.txt"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"))
+
+	// Test: Backslash separators
+	resp = serveTest(handler, newTestRequest("GET", "/..%5csecret.txt"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"))
+
+	// Test: Symlink pointing outside of root
+	resp = serveTest(handler, newTestRequest("GET", "/escape.txt"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"))
+	assert.False(t, strings.HasSuffix(resp, "secret"))
+
+	// Test: Path going through regular file
+	resp = serveTest(handler, newTestRequest("GET", "/hello.txt/secret.txt"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Listing disabled by default
+	resp = serveTest(handler, newTestRequest("GET", "/docs/"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 403 Forbidden\r\n"))
+}
+
+func TestFileServerIfRange(t *testing.T) {
+	root := setupFileServerRoot(t)
+	modtime := time.Date(2024, time.March, 10, 12, 30, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(root, "hello.txt"), modtime, modtime))
+	handler := FileServer(root)
+
+	resp := serveTest(handler, newTestRequest("GET", "/hello.txt"))
+	etag := headerValue(resp, "ETag")
+	require.True(t, strings.HasPrefix(etag, "W/"), etag)
+
+	// Test: If-Range with file's weak ETag never matches, whole file is sent
+	req := newTestRequest("GET", "/hello.txt")
+	req.Headers.Set("Range", "bytes=0-4")
+	req.Headers.Set("If-Range", etag)
+	resp = serveTest(handler, req)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(resp, "hello world"))
+
+	// Test: If-Range with Last-Modified date serves range
+	req = newTestRequest("GET", "/hello.txt")
+	req.Headers.Set("Range", "bytes=0-4")
+	req.Headers.Set("If-Range", "Sun, 10 Mar 2024 12:30:00 GMT")
+	resp = serveTest(handler, req)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nhello"))
+}
+
+func TestFileServerStripPrefix(t *testing.T) {
+	root := setupFileServerRoot(t)
+	handler := NewFileServer(root, FileServerOptions{StripPrefix: "/assets"})
+
+	resp := serveTest(handler, newTestRequest("GET", "/assets/hello.txt"))
+	assert.True(t, strings.HasSuffix(resp, "hello world"))
+
+	resp = serveTest(handler, newTestRequest("GET", "/other/hello.txt"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"))
+
+	// Test: Prefix matches only whole path segments
+	resp = serveTest(handler, newTestRequest("GET", "/assetshello.txt"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"), resp)
+}
+
+func TestFileError(t *testing.T) {
+	// Test: Unexpected filesystem error is not hidden behind 404
+	var buf bytes.Buffer
+	w := response.NewWritter(&buf)
+	fileError(w, &fs.PathError{Op: "read", Path: "hello.txt", Err: syscall.EIO})
+	w.Finish()
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 500 Internal Server Error\r\n"))
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/response"
+)
+
+// connLimiter counts connections served at once, in total and per client IP
+type connLimiter struct {
+	// one token per served connection, nil when total number is not limited
+	slots chan struct{}
+	perIP int
+
+	mu  sync.Mutex
+	ips map[string]int
+}
+
+func newConnLimiter(maxConnections, maxPerIP int) *connLimiter {
+	limiter := &connLimiter{perIP: maxPerIP}
+	if maxConnections > 0 {
+		limiter.slots = make(chan struct{}, maxConnections)
+	}
+	if maxPerIP > 0 {
+		limiter.ips = make(map[string]int)
+	}
+	return limiter
+}
+
+// wait blocks until connection can be served or done is closed, it reports whether slot was taken
+func (l *connLimiter) wait(done <-chan struct{}) bool {
+	if l.slots == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// tryAcquire takes slot without waiting, it reports false when limit is reached
+func (l *connLimiter) tryAcquire() bool {
+	if l.slots == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *connLimiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// acquireIP counts connection from ip, it reports false when ip has too many connections
+func (l *connLimiter) acquireIP(ip string) bool {
+	if l.perIP <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ips[ip] >= l.perIP {
+		return false
+	}
+	l.ips[ip]++
+	return true
+}
+
+func (l *connLimiter) releaseIP(ip string) {
+	if l.perIP <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ips[ip] <= 1 {
+		delete(l.ips, ip)
+		return
+	}
+	l.ips[ip]--
+}
+
+// remoteIP returns IP part of connection remote address
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// Accept errors (e.g. EMFILE when process ran out of file descriptors) are retried after
+// delay which doubles from minAcceptDelay up to maxAcceptDelay
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+func nextAcceptDelay(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return minAcceptDelay
+	}
+	return min(delay*2, maxAcceptDelay)
+}
+
+// How long rejected client gets to receive the 503
+const rejectTimeout = time.Second
+
+// Most connections answered with 503 at once, rejecting holds goroutine and fd until client got it
+const maxPendingRejects = 64
+
+// reject answers connection over the limit with 503 in background and closes it. When maxPendingRejects
+// connections are already being answered it is closed right away, flood must not pile them up.
+func (s *Server) reject(conn net.Conn, reason string) {
+	s.logger().Warn("connection rejected", "remote_addr", conn.RemoteAddr().String(), "reason", reason)
+	s.metrics.connectionRejected(reason)
+	select {
+	case s.rejecting <- struct{}{}:
+	default:
+		conn.Close()
+		return
+	}
+	go func() {
+		defer func() { <-s.rejecting }()
+		s.writeRejection(conn)
+	}()
+}
+
+// writeRejection sends 503 with Retry-After and closes connection
+func (s *Server) writeRejection(conn net.Conn) {
+	conn.SetWriteDeadline(time.Now().Add(rejectTimeout))
+	w := response.NewWritter(conn)
+	s.defaultHeaders(w.Header())
+	retryAfter := (s.config.retryAfter() + time.Second - 1) / time.Second
+	w.Header().Set("Retry-After", strconv.FormatInt(int64(retryAfter), 10))
+	w.Header().Set("Connection", "close")
+	HandlerError{StatusCode: response.ServiceUnavailableStatusCode, Message: "server is overloaded, try again later"}.Write(w)
+	w.Finish()
+	closeAfterResponse(conn)
+}
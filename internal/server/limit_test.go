@@ -0,0 +1,215 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/metrics"
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingHandler reports every request on entered and answers once release is closed
+func blockingHandler(entered chan<- struct{}, release <-chan struct{}) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		entered <- struct{}{}
+		<-release
+		textHandler(w, req)
+	}
+}
+
+// holdConnection opens connection and sends request which stays in handler until it is released
+func holdConnection(t *testing.T, server *Server, entered <-chan struct{}) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	select {
+	case <-entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request didn't reach handler")
+	}
+	return conn
+}
+
+func TestServerMaxConnectionsReject(t *testing.T) {
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+	server := startTestServer(t, Config{
+		Handler:        blockingHandler(entered, release),
+		MaxConnections: 1,
+		OverloadPolicy: OverloadReject,
+		RetryAfter:     1500 * time.Millisecond,
+	})
+	held := holdConnection(t, server, entered)
+
+	// Test: Connection over the limit gets 503 with Retry-After rounded up to seconds
+	resp := roundTrip(t, server, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 503 Service Unavailable\r\n"), resp)
+	assert.Equal(t, "2", headerValue(resp, "Retry-After"))
+	assert.Equal(t, "close", headerValue(resp, "Connection"))
+
+	// Test: Held connection is still served
+	close(release)
+	body, err := io.ReadAll(held)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(body), "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Slot is free again once connection closed
+	assert.Eventually(t, func() bool {
+		resp, err := tryRoundTrip(server, "GET / HTTP/1.1\r\n\r\n")
+		return err == nil && strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n")
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestServerRejectionsBounded(t *testing.T) {
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+	defer close(release)
+	server := startTestServer(t, Config{
+		Handler:        blockingHandler(entered, release),
+		MaxConnections: 1,
+		OverloadPolicy: OverloadReject,
+	})
+	holdConnection(t, server, entered)
+
+	// Test: Connection is closed without answer when too many rejections are in progress
+	for range maxPendingRejects {
+		server.rejecting <- struct{}{}
+	}
+	resp, err := tryRoundTrip(server, "GET / HTTP/1.1\r\n\r\n")
+	if err == nil {
+		assert.Empty(t, resp)
+	}
+
+	// Test: 503 is sent again once rejections finished
+	for range maxPendingRejects {
+		<-server.rejecting
+	}
+	resp = roundTrip(t, server, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 503 Service Unavailable\r\n"), resp)
+}
+
+func TestServerMaxConnectionsBlock(t *testing.T) {
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+	server := startTestServer(t, Config{
+		Handler:        blockingHandler(entered, release),
+		MaxConnections: 1,
+	})
+	held := holdConnection(t, server, entered)
+
+	// Test: Connection over the limit waits and is not answered
+	conn, err := net.Dial("tcp", server.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 1))
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+	select {
+	case <-entered:
+		t.Fatal("waiting connection reached handler")
+	default:
+	}
+
+	// Test: Waiting connection is served after held one finished
+	close(release)
+	body, err := io.ReadAll(held)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(body), "HTTP/1.1 200 OK\r\n"))
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	body, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(body), "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Close stops server waiting for free slot
+	entered = make(chan struct{}, 2)
+	release = make(chan struct{})
+	defer close(release)
+	server = startTestServer(t, Config{
+		Handler:        blockingHandler(entered, release),
+		MaxConnections: 1,
+	})
+	holdConnection(t, server, entered)
+	require.NoError(t, server.Close())
+	_, err = net.DialTimeout("tcp", server.Addr().String(), time.Second)
+	assert.Error(t, err)
+}
+
+func TestServerMaxConnectionsPerIP(t *testing.T) {
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+	registry := metrics.NewRegistry()
+	server := startTestServer(t, Config{
+		Handler:             blockingHandler(entered, release),
+		MaxConnectionsPerIP: 1,
+		Metrics:             registry,
+	})
+	held := holdConnection(t, server, entered)
+
+	// Test: Second connection from the same IP is rejected even in blocking mode
+	resp := roundTrip(t, server, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 503 Service Unavailable\r\n"), resp)
+	assert.Equal(t, "5", headerValue(resp, "Retry-After"))
+
+	var exposition bytes.Buffer
+	_, err := registry.WriteTo(&exposition)
+	require.NoError(t, err)
+	assert.Contains(t, exposition.String(), `http_server_rejected_connections_total{reason="max_connections_per_ip"} 1`)
+
+	close(release)
+	body, err := io.ReadAll(held)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(body), "HTTP/1.1 200 OK\r\n"))
+
+	// Test: IP can connect again once its connection closed
+	assert.Eventually(t, func() bool {
+		resp, err := tryRoundTrip(server, "GET / HTTP/1.1\r\n\r\n")
+		return err == nil && strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n")
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestNextAcceptDelay(t *testing.T) {
+	// Test: Delay starts small, doubles and stops growing at maxAcceptDelay
+	delay := nextAcceptDelay(0)
+	assert.Equal(t, minAcceptDelay, delay)
+	assert.Equal(t, 2*minAcceptDelay, nextAcceptDelay(delay))
+	for range 20 {
+		delay = nextAcceptDelay(delay)
+	}
+	assert.Equal(t, maxAcceptDelay, delay)
+}
+
+// tryRoundTrip is roundTrip which returns error instead of failing test, for use in Eventually
+func tryRoundTrip(server *Server, rawRequest string) (string, error) {
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte(rawRequest)); err != nil {
+		return "", err
+	}
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		return "", err
+	}
+	resp, err := io.ReadAll(conn)
+	return string(resp), err
+}
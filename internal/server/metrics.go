@@ -0,0 +1,110 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/metrics"
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/response"
+)
+
+// serverMetrics are recorded when Config.Metrics is set, methods of nil serverMetrics do nothing
+type serverMetrics struct {
+	activeConnections *metrics.Gauge
+	connections       *metrics.Counter
+	rejected          *metrics.Counter
+	requests          *metrics.Counter
+	duration          *metrics.Histogram
+	requestBytes      *metrics.Histogram
+	responseBytes     *metrics.Histogram
+	parseErrors       *metrics.Counter
+	reusedConnections *metrics.Counter
+}
+
+// Body size buckets from 64 B to 16 MiB
+var sizeBuckets = metrics.ExponentialBuckets(64, 4, 10)
+
+func newServerMetrics(registry *metrics.Registry) *serverMetrics {
+	if registry == nil {
+		return nil
+	}
+	return &serverMetrics{
+		activeConnections: registry.Gauge("http_server_active_connections", "Connections currently open."),
+		connections:       registry.Counter("http_server_connections_total", "Connections accepted."),
+		rejected: registry.Counter("http_server_rejected_connections_total", "Connections rejected by connection limit.",
+			"reason"),
+		requests: registry.Counter("http_server_requests_total", "Requests served by method, route and status.",
+			"method", "route", "status"),
+		duration: registry.Histogram("http_server_request_duration_seconds", "Time handler took to serve request.",
+			nil, "method", "route"),
+		requestBytes: registry.Histogram("http_server_request_body_bytes", "Size of request bodies.",
+			sizeBuckets, "method", "route"),
+		responseBytes: registry.Histogram("http_server_response_body_bytes", "Size of response bodies sent.",
+			sizeBuckets, "method", "route"),
+		parseErrors: registry.Counter("http_server_parse_errors_total", "Malformed requests by part which was invalid.",
+			"type"),
+		reusedConnections: registry.Counter("http_server_keepalive_requests_total",
+			"Requests served on connection which already served previous request."),
+	}
+}
+
+func (m *serverMetrics) connectionOpened() {
+	if m == nil {
+		return
+	}
+	m.connections.Inc()
+	m.activeConnections.Inc()
+}
+
+func (m *serverMetrics) connectionClosed() {
+	if m == nil {
+		return
+	}
+	m.activeConnections.Dec()
+}
+
+func (m *serverMetrics) connectionRejected(reason string) {
+	if m == nil {
+		return
+	}
+	m.rejected.Inc(reason)
+}
+
+func (m *serverMetrics) parseError(err error) {
+	if m == nil {
+		return
+	}
+	kind := "read"
+	var parseErr *request.ParseError
+	if errors.As(err, &parseErr) {
+		kind = parseErr.Kind
+	}
+	m.parseErrors.Inc(kind)
+}
+
+// requestServed records request after handler returned, served is number of request on connection
+func (m *serverMetrics) requestServed(req *request.Request, w *response.Writer, duration time.Duration, served int) {
+	if m == nil {
+		return
+	}
+	method := methodLabel(req.RequestLine.Method)
+	status := strconv.Itoa(int(w.StatusCode()))
+	m.requests.Inc(method, req.Route, status)
+	m.duration.Observe(duration.Seconds(), method, req.Route)
+	m.requestBytes.Observe(float64(len(req.Body)), method, req.Route)
+	m.responseBytes.Observe(float64(w.BodyBytes()), method, req.Route)
+	if served > 1 {
+		m.reusedConnections.Inc()
+	}
+}
+
+// methodLabel keeps number of label values bounded, unknown methods are counted together
+func methodLabel(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "CONNECT", "TRACE":
+		return method
+	}
+	return "OTHER"
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	router := NewRouter()
+	router.Handle("GET", "/items/", textHandler)
+	router.Handle("POST", "/items/", textHandler)
+	router.Handle("GET", "/metrics", registry.Serve)
+	server := startTestServer(t, Config{Handler: router.Serve, Metrics: registry})
+
+	roundTrip(t, server, "GET /items/1 HTTP/1.1\r\n\r\n"+
+		"POST /items/2 HTTP/1.1\r\nContent-Length: 4\r\n\r\ndata"+
+		"BREW /items/3 HTTP/1.1\r\n\r\n"+
+		"GET /unknown HTTP/1.1\r\n\r\n")
+	roundTrip(t, server, "GET / HTTP/1.0\r\n\r\n")
+	roundTrip(t, server, "GET / HTTP/1.1\r\nBad Header: x\r\n\r\n")
+
+	// Connection is counted closed after client saw it closed
+	assert.Eventually(t, func() bool {
+		var out strings.Builder
+		registry.WriteTo(&out)
+		return strings.Contains(out.String(), "\nhttp_server_active_connections 0\n")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	body := string(fetch(t, server, "GET /metrics HTTP/1.1\r\n\r\n").Body)
+	assert.Contains(t, body, "\nhttp_server_active_connections 1\n")
+
+	for _, line := range []string{
+		"http_server_connections_total 4",
+		`http_server_requests_total{method="GET",route="/items/",status="200"} 1`,
+		`http_server_requests_total{method="POST",route="/items/",status="200"} 1`,
+		// Test: Unknown methods and unmatched paths keep label values bounded
+		`http_server_requests_total{method="OTHER",route="/items/",status="405"} 1`,
+		`http_server_requests_total{method="GET",route="",status="404"} 1`,
+		`http_server_request_duration_seconds_count{method="GET",route="/items/"} 1`,
+		`http_server_request_body_bytes_sum{method="POST",route="/items/"} 4`,
+		`http_server_response_body_bytes_sum{method="POST",route="/items/"} 5`,
+		`http_server_parse_errors_total{type="request_line"} 1`,
+		`http_server_parse_errors_total{type="headers"} 1`,
+		// Test: Keep-alive reuse
+		"http_server_keepalive_requests_total 3",
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/response"
+)
+
+// Router dispatches requests to handlers by method and path.
+// Pattern ending with "/" matches every path with that prefix, other patterns match path exactly,
+// the longest matching pattern wins. HEAD requests are served by GET handler when no HEAD handler
+// is registered and OPTIONS requests (including OPTIONS *) are answered with allowed methods.
+type Router struct {
+	routes map[string]map[string]Handler // pattern -> method -> handler
+	// NotFound handles requests no pattern matches, plain 404 is sent when nil
+	NotFound Handler
+}
+
+func NewRouter() *Router {
+	return &Router{
+		routes: map[string]map[string]Handler{},
+	}
+}
+
+// Handle registers handler for method and path pattern
+func (r *Router) Handle(method, pattern string, handler Handler) {
+	methods, exists := r.routes[pattern]
+	if !exists {
+		methods = map[string]Handler{}
+		r.routes[pattern] = methods
+	}
+	methods[strings.ToUpper(method)] = handler
+}
+
+// Serve is router Handler, pass it to Serve or ServeConfig
+func (r *Router) Serve(w *response.Writer, req *request.Request) {
+	method := req.RequestLine.Method
+	target := req.RequestLine.RequestTarget
+
+	if method == "OPTIONS" && target == "*" {
+		writeAllow(w, r.allMethods())
+		return
+	}
+
+	path, _, _ := strings.Cut(target, "?")
+	pattern, methods := r.match(path)
+	if methods == nil {
+		if r.NotFound != nil {
+			r.NotFound(w, req)
+			return
+		}
+		HandlerError{StatusCode: response.NotFoundStatusCode, Message: "Not Found"}.Write(w)
+		return
+	}
+
+	req.Route = pattern
+
+	handler, exists := methods[method]
+	if !exists && method == "HEAD" {
+		// Server discards body for HEAD, GET handler produces the same headers
+		handler, exists = methods["GET"]
+	}
+	if exists {
+		handler(w, req)
+		return
+	}
+
+	if method == "OPTIONS" {
+		writeAllow(w, allowedMethods(methods))
+		return
+	}
+
+	message := []byte("Method Not Allowed")
+	w.WriteStatusLine(response.MethodNotAllowedStatusCode)
+	h := response.GetDefaultHeaders(len(message))
+	h.Set("Allow", strings.Join(allowedMethods(methods), ", "))
+	w.WriteHeaders(h)
+	w.WriteBody(message)
+}
+
+// match returns the longest pattern matching path and its handlers
+func (r *Router) match(path string) (string, map[string]Handler) {
+	if methods, exists := r.routes[path]; exists {
+		return path, methods
+	}
+
+	var best string
+	for pattern := range r.routes {
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern) && len(pattern) > len(best) {
+			best = pattern
+		}
+	}
+	if best == "" {
+		return "", nil
+	}
+	return best, r.routes[best]
+}
+
+func (r *Router) allMethods() []string {
+	union := map[string]Handler{}
+	for _, methods := range r.routes {
+		for method, handler := range methods {
+			union[method] = handler
+		}
+	}
+	return allowedMethods(union)
+}
+
+// allowedMethods lists registered methods, HEAD is implied by GET and OPTIONS is always answered
+func allowedMethods(methods map[string]Handler) []string {
+	allowed := []string{"OPTIONS"}
+	for method := range methods {
+		if method != "OPTIONS" {
+			allowed = append(allowed, method)
+		}
+	}
+	if _, hasGet := methods["GET"]; hasGet {
+		if _, hasHead := methods["HEAD"]; !hasHead {
+			allowed = append(allowed, "HEAD")
+		}
+	}
+	sort.Strings(allowed)
+	return allowed
+}
+
+func writeAllow(w *response.Writer, methods []string) {
+	w.WriteStatusLine(response.NoContentStatusCode)
+	h := headers.NewHeaders()
+	h.Set("Allow", strings.Join(methods, ", "))
+	w.WriteHeaders(h)
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func namedHandler(name string) Handler {
+	return func(w *response.Writer, _ *request.Request) {
+		body := []byte(name)
+		w.WriteStatusLine(response.OkStatusCode)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+}
+
+func newTestRouter() *Router {
+	router := NewRouter()
+	router.Handle("GET", "/", namedHandler("root"))
+	router.Handle("GET", "/items", namedHandler("items"))
+	router.Handle("POST", "/items", namedHandler("create"))
+	router.Handle("GET", "/static/", namedHandler("static"))
+	router.Handle("GET", "/static/css/", namedHandler("css"))
+	router.Handle("DELETE", "/admin", namedHandler("admin"))
+	return router
+}
+
+func TestRouterDispatch(t *testing.T) {
+	router := newTestRouter()
+
+	// Test: Exact match
+	resp := serveTest(router.Serve, newTestRequest("GET", "/items"))
+	assert.True(t, strings.HasSuffix(resp, "items"))
+	resp = serveTest(router.Serve, newTestRequest("POST", "/items?x=1"))
+	assert.True(t, strings.HasSuffix(resp, "create"))
+
+	// Test: Longest prefix wins
+	resp = serveTest(router.Serve, newTestRequest("GET", "/static/css/site.css"))
+	assert.True(t, strings.HasSuffix(resp, "css"))
+	req := newTestRequest("GET", "/static/app.js")
+	resp = serveTest(router.Serve, req)
+	assert.True(t, strings.HasSuffix(resp, "static"))
+	// Test: Matched pattern is recorded on request
+	assert.Equal(t, "/static/", req.Route)
+	resp = serveTest(router.Serve, newTestRequest("GET", "/other"))
+	assert.True(t, strings.HasSuffix(resp, "root"))
+
+	// Test: HEAD falls back to GET handler
+	resp = serveTest(router.Serve, newTestRequest("HEAD", "/items"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Method not allowed
+	resp = serveTest(router.Serve, newTestRequest("PUT", "/items"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, resp, "Allow: GET, HEAD, OPTIONS, POST\r\n")
+
+	// Test: Not found without catch all
+	router = NewRouter()
+	router.Handle("GET", "/items", namedHandler("items"))
+	req = newTestRequest("GET", "/missing")
+	resp = serveTest(router.Serve, req)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"))
+	assert.Empty(t, req.Route)
+
+	router.NotFound = namedHandler("custom")
+	resp = serveTest(router.Serve, newTestRequest("GET", "/missing"))
+	assert.True(t, strings.HasSuffix(resp, "custom"))
+}
+
+func TestRouterOptions(t *testing.T) {
+	router := newTestRouter()
+
+	// Test: OPTIONS for path
+	resp := serveTest(router.Serve, newTestRequest("OPTIONS", "/items"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 204 No Content\r\n"))
+	assert.Contains(t, resp, "Allow: GET, HEAD, OPTIONS, POST\r\n")
+	assert.NotContains(t, resp, "Content-Length")
+
+	resp = serveTest(router.Serve, newTestRequest("OPTIONS", "/admin"))
+	assert.Contains(t, resp, "Allow: DELETE, OPTIONS\r\n")
+
+	// Test: OPTIONS * lists every method server supports
+	resp = serveTest(router.Serve, newTestRequest("OPTIONS", "*"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 204 No Content\r\n"))
+	assert.Contains(t, resp, "Allow: DELETE, GET, HEAD, OPTIONS, POST\r\n")
+
+	// Test: Registered OPTIONS handler takes precedence
+	router.Handle("OPTIONS", "/items", namedHandler("preflight"))
+	resp = serveTest(router.Serve, newTestRequest("OPTIONS", "/items"))
+	assert.True(t, strings.HasSuffix(resp, "preflight"))
+}
+
+func TestServerHead(t *testing.T) {
+	router := NewRouter()
+	router.Handle("GET", "/text", textHandler)
+	router.Handle("GET", "/chunked", func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.OkStatusCode)
+		body, _ := w.Body()
+		body.Write([]byte("streamed"))
+		body.Close()
+	})
+	server := startTestServer(t, Config{Handler: router.Serve})
+
+	// Test: Headers of GET response are kept but body is dropped
+	resp := roundTrip(t, server, "HEAD /text HTTP/1.1\r\n\r\n")
+	require.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, "5", headerValue(resp, "Content-Length"))
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+
+	resp = roundTrip(t, server, "HEAD /chunked HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "chunked", headerValue(resp, "Transfer-Encoding"))
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n"))
+	assert.NotContains(t, resp, "streamed")
+
+	// Test: GET still gets the body
+	resp = roundTrip(t, server, "GET /text HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nhello"))
+
+	resp = roundTrip(t, server, "OPTIONS * HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 204 No Content\r\n"))
+	assert.Equal(t, "GET, HEAD, OPTIONS", headerValue(resp, "Allow"))
+}
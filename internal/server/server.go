@@ -1,11 +1,18 @@
 package server
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
 	"strconv"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/MichalGul/http_server_go/internal/headers"
 	"github.com/MichalGul/http_server_go/internal/request"
 	"github.com/MichalGul/http_server_go/internal/response"
 )
@@ -13,7 +20,22 @@ import (
 type Server struct {
 	isClosed           atomic.Bool
 	connectionListener net.Listener
-	handler 		   Handler
+	handler            Handler
+	config             Config
+	date               dateCache
+
+	// last assigned connection ID
+	connectionID atomic.Uint64
+	metrics      *serverMetrics
+	limiter      *connLimiter
+	// closed by Close, stops listen waiting for free connection slot or accept retry
+	done chan struct{}
+	// one token per connection being answered with 503
+	rejecting chan struct{}
+
+	mu sync.Mutex
+	// connections waiting for next request
+	idle map[net.Conn]struct{}
 }
 
 type Handler func(w *response.Writer, req *request.Request)
@@ -23,33 +45,49 @@ type HandlerError struct {
 	Message    string
 }
 
-// func (he HandlerError) Write(w io.Writer) {
-
-// 	response.WriteStatusLine(w, he.StatusCode)
-// 	messageBytes := []byte(he.Message)
-// 	headers := response.GetDefaultHeaders(len(messageBytes))
-// 	response.WriteHeaders(w, headers)
+// Write sends handler error as plain text response with error message as body
+func (he HandlerError) Write(w *response.Writer) error {
 
-// 	// Error message
-// 	w.Write(messageBytes)
+	err := w.WriteStatusLine(he.StatusCode)
+	if err != nil {
+		return err
+	}
+	messageBytes := []byte(he.Message)
+	headers := response.GetDefaultHeaders(len(messageBytes))
+	err = w.WriteHeaders(headers)
+	if err != nil {
+		return err
+	}
 
-// }
+	// Error message
+	_, err = w.WriteBody(messageBytes)
+	return err
+}
 
 func Serve(port int, handler Handler) (*Server, error) {
+	return ServeConfig(Config{
+		Port:    port,
+		Handler: handler,
+	})
+}
 
-	portStr := strconv.Itoa(port)
+// ServeConfig starts server configured with config, connections are accepted in background
+func ServeConfig(config Config) (*Server, error) {
+
+	portStr := strconv.Itoa(config.Port)
 	listener, err := net.Listen("tcp", ":"+portStr)
 	if err != nil {
 		return nil, fmt.Errorf("error creating listener %v", err)
 	}
 
-	var isClosed atomic.Bool
-	isClosed.Store(false)
-
 	server := &Server{
-		isClosed:           isClosed,
 		connectionListener: listener,
-		handler: handler,
+		handler:            config.Handler,
+		config:             config,
+		metrics:            newServerMetrics(config.Metrics),
+		limiter:            newConnLimiter(config.MaxConnections, config.MaxConnectionsPerIP),
+		done:               make(chan struct{}),
+		rejecting:          make(chan struct{}, maxPendingRejects),
 	}
 
 	// Accept listen for connections in gorutine
@@ -59,48 +97,245 @@ func Serve(port int, handler Handler) (*Server, error) {
 
 }
 
+// Addr returns address server listens on
+func (s *Server) Addr() net.Addr {
+	return s.connectionListener.Addr()
+}
+
 func (s *Server) Close() error {
 
-	s.isClosed.Store(true)
+	if !s.isClosed.Swap(true) {
+		close(s.done)
+	}
+	s.mu.Lock()
+	for conn := range s.idle {
+		conn.Close()
+	}
+	s.mu.Unlock()
 	if s.connectionListener != nil {
 		return s.connectionListener.Close()
 	}
 	return nil
 }
 
+// listen accepts connections until server is closed. Connections over Config.MaxConnections
+// are left in kernel backlog or rejected depending on OverloadPolicy. Connection holds its slot
+// until handle returns, also when it was hijacked, as hijacking handlers run until they are done.
 func (s *Server) listen() {
 
+	var delay time.Duration
 	for {
+		if s.config.OverloadPolicy == OverloadBlock && !s.limiter.wait(s.done) {
+			return
+		}
 		connection, err := s.connectionListener.Accept()
 		if err != nil {
+			if s.config.OverloadPolicy == OverloadBlock {
+				s.limiter.release()
+			}
 			if s.isClosed.Load() {
 				return // if server is closed ignore errors
 			}
-			fmt.Printf("accept error: %v", err)
+			// Retrying right away would spin while e.g. file descriptors are exhausted
+			delay = nextAcceptDelay(delay)
+			s.logger().Error("accept failed", "error", err, "retry_in", delay)
+			select {
+			case <-time.After(delay):
+			case <-s.done:
+				return
+			}
 			continue
 		}
+		delay = 0
 
-		go s.handle(connection)
+		if s.config.OverloadPolicy == OverloadReject && !s.limiter.tryAcquire() {
+			s.reject(connection, "max_connections")
+			continue
+		}
+		ip := remoteIP(connection)
+		if !s.limiter.acquireIP(ip) {
+			s.limiter.release()
+			s.reject(connection, "max_connections_per_ip")
+			continue
+		}
+
+		go func() {
+			defer s.limiter.release()
+			defer s.limiter.releaseIP(ip)
+			s.handle(connection)
+		}()
 
 	}
 
 }
 
+// handle serves requests sent over persistent connection one after another.
+// Bytes read past the end of request are kept and parsed first, so pipelined requests are
+// handled in order they came and every response is finished before next request is read.
 func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
+	connectionID := s.connectionID.Add(1)
+	logger := s.logger()
+	if s.config.Logger != nil {
+		logger = logger.With("conn_id", connectionID, "remote_addr", conn.RemoteAddr().String())
+	}
+	logger.Debug("connection opened")
+	s.metrics.connectionOpened()
+	defer s.metrics.connectionClosed()
 
-	responseWritter := response.NewWritter(conn)
+	var buffered []byte
+	for served := 1; ; served++ {
+		var reader io.Reader = conn
+		if len(buffered) > 0 {
+			reader = io.MultiReader(bytes.NewReader(buffered), conn)
+		}
 
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		responseWritter.WriteStatusLine(response.BadRequestStatusCode)
-		defaultHeaders := response.GetDefaultHeaders(len(err.Error()))
-		responseWritter.WriteHeaders(defaultHeaders)
-		responseWritter.WriteBody([]byte(err.Error()))
-		return
+		conn.SetReadDeadline(time.Now().Add(s.config.idleTimeout()))
+		s.trackIdle(conn, true)
+		req, err := request.RequestFromReaderLimit(reader, s.config.maxBodySize())
+		s.trackIdle(conn, false)
+		conn.SetReadDeadline(time.Time{})
+
+		responseWritter := response.NewWritter(conn)
+		s.defaultHeaders(responseWritter.Header())
+		if err != nil {
+			// Client closed connection or stayed idle for too long, there is nobody to answer
+			var netErr net.Error
+			switch {
+			case errors.Is(err, io.EOF) || s.isClosed.Load():
+				logger.Debug("connection closed")
+			case errors.As(err, &netErr) && netErr.Timeout():
+				logger.Debug("connection closed", "reason", "idle timeout")
+			default:
+				logger.Info("bad request", "error", err)
+				s.metrics.parseError(err)
+				statusCode := response.BadRequestStatusCode
+				switch {
+				case errors.Is(err, request.ErrHeaderTooLarge):
+					statusCode = response.HeaderFieldsTooLargeStatusCode
+				case errors.Is(err, request.ErrBodyTooLarge):
+					statusCode = response.ContentTooLargeStatusCode
+				}
+				responseWritter.Header().Set("Connection", "close")
+				HandlerError{StatusCode: statusCode, Message: err.Error()}.Write(responseWritter)
+				responseWritter.Finish()
+				closeAfterResponse(conn)
+				return
+			}
+			conn.Close()
+			return
+		}
+
+		req.RemoteAddr = conn.RemoteAddr().String()
+		req.ID = requestID(req, connectionID, served)
+		if s.config.Logger != nil {
+			req.SetLogger(logger.With("request_id", req.ID))
+		}
+		responseWritter.SetBuffered(req.Buffered())
+		if connection, _ := req.Headers.Get("Connection"); headers.HasToken(connection, "close") {
+			responseWritter.Header().Set("Connection", "close")
+		}
+
+		// Response to HEAD is the same as to GET without the body
+		if req.RequestLine.Method == "HEAD" {
+			responseWritter.DiscardBody()
+		}
+
+		start := time.Now()
+		s.handler(responseWritter, req)
+
+		// Hijacked connection belongs to handler
+		if responseWritter.Hijacked() {
+			s.metrics.requestServed(req, responseWritter, time.Since(start), served)
+			req.Logger().Debug("connection hijacked")
+			return
+		}
+		// Send whatever handler left in the buffer, background writers are stopped by it
+		err = responseWritter.Finish()
+		s.metrics.requestServed(req, responseWritter, time.Since(start), served)
+		if s.config.Logger != nil {
+			req.Logger().Debug("request served", "method", req.RequestLine.Method, "target", req.RequestLine.RequestTarget,
+				"status", int(responseWritter.StatusCode()), "bytes", responseWritter.BodyBytes())
+			if err != nil {
+				req.Logger().Debug("writing response failed", "error", err)
+			}
+		}
+		if err != nil || !responseWritter.KeepAlive() || s.isClosed.Load() {
+			logger.Debug("connection closed", "requests", served)
+			closeAfterResponse(conn)
+			return
+		}
+		buffered = req.Buffered()
+	}
+}
+
+// Longest X-Request-ID accepted from client
+const maxRequestIDLength = 128
+
+// requestID returns ID sent by client in X-Request-ID header when it is printable ASCII,
+// otherwise ID made of connection ID and number of request on connection
+func requestID(req *request.Request, connectionID uint64, served int) string {
+	if id, exists := req.Headers.Get("X-Request-ID"); exists && id != "" && len(id) <= maxRequestIDLength {
+		printable := true
+		for i := 0; i < len(id); i++ {
+			if id[i] <= ' ' || id[i] >= 127 {
+				printable = false
+				break
+			}
+		}
+		if printable {
+			return id
+		}
+	}
+	return strconv.FormatUint(connectionID, 10) + "-" + strconv.Itoa(served)
+}
+
+// logger returns configured logger, server is silent without one
+func (s *Server) logger() *slog.Logger {
+	if s.config.Logger == nil {
+		return discardLogger
 	}
+	return s.config.Logger
+}
 
-	s.handler(responseWritter, req)
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// How long and how much of client data is drained before connection is closed
+const (
+	lingerTimeout = 500 * time.Millisecond
+	lingerBytes   = 256 << 10
+)
 
-	return
+// closeAfterResponse closes connection once response was sent. Closing socket with unread
+// data (e.g. pipelined requests which won't be served) makes kernel reset the connection
+// and client could lose the response, so sending side is closed first and whatever client
+// still sends is drained for a moment.
+func closeAfterResponse(conn net.Conn) {
+	defer conn.Close()
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if tcpConn.CloseWrite() != nil {
+		return
+	}
+	tcpConn.SetReadDeadline(time.Now().Add(lingerTimeout))
+	io.CopyN(io.Discard, tcpConn, lingerBytes)
+}
+
+// trackIdle marks connection waiting for request, Close closes such connections right away
+func (s *Server) trackIdle(conn net.Conn, idle bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !idle {
+		delete(s.idle, conn)
+		return
+	}
+	if s.isClosed.Load() {
+		conn.Close()
+		return
+	}
+	if s.idle == nil {
+		s.idle = make(map[net.Conn]struct{})
+	}
+	s.idle[conn] = struct{}{}
 }
@@ -0,0 +1,384 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startTestServer(t *testing.T, config Config) *Server {
+	t.Helper()
+	server, err := ServeConfig(config)
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+// roundTrip sends raw request to server and returns everything it answered until connection was closed.
+// Sending side is closed after request, so server closes persistent connection once it answered.
+func roundTrip(t *testing.T, server *Server, rawRequest string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte(rawRequest))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(resp)
+}
+
+// fetch sends raw request to server and parses its response
+func fetch(t *testing.T, server *Server, rawRequest string) *response.Response {
+	t.Helper()
+	resp, err := response.ResponseFromReader(strings.NewReader(roundTrip(t, server, rawRequest)))
+	require.NoError(t, err)
+	return resp
+}
+
+// headerValue returns value of header from raw response or empty string
+func headerValue(resp, name string) string {
+	head, _, _ := strings.Cut(resp, "\r\n\r\n")
+	for _, line := range strings.Split(head, "\r\n")[1:] {
+		fieldName, value, _ := strings.Cut(line, ":")
+		if strings.EqualFold(fieldName, name) {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+func textHandler(w *response.Writer, _ *request.Request) {
+	body := []byte("hello")
+	w.WriteStatusLine(response.OkStatusCode)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func TestServerDefaultHeaders(t *testing.T) {
+	server := startTestServer(t, Config{Handler: textHandler, ServerName: "test-server"})
+
+	resp := fetch(t, server, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.Equal(t, response.OkStatusCode, resp.StatusLine.StatusCode)
+	assert.Equal(t, "hello", string(resp.Body))
+	assert.Equal(t, "test-server", resp.Headers["server"])
+	// Connection is persistent unless client asks otherwise
+	assert.NotContains(t, resp.Headers, "connection")
+
+	date, err := headers.ParseTime(resp.Headers["date"])
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), date, 2*time.Second)
+
+	// Test: Bad request response also carries defaults
+	resp = fetch(t, server, "garbage\r\n\r\n")
+	require.Equal(t, response.BadRequestStatusCode, resp.StatusLine.StatusCode)
+	assert.NotEmpty(t, resp.Headers["date"])
+}
+
+func TestServerDefaultHeadersOverride(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		w.Header().Del("Date")
+		w.WriteStatusLine(response.OkStatusCode)
+		h := response.GetDefaultHeaders(0)
+		h.Set("server", "handler")
+		w.WriteHeaders(h)
+	}
+	server := startTestServer(t, Config{
+		Handler:        handler,
+		ServerName:     "test-server",
+		DefaultHeaders: headers.Headers{"X-Frame-Options": "DENY"},
+	})
+
+	resp := fetch(t, server, "GET / HTTP/1.1\r\n\r\n")
+	// Duplicated header would be joined into single value by parser
+	assert.Equal(t, "handler", resp.Headers["server"])
+	assert.NotContains(t, resp.Headers, "date")
+	assert.Equal(t, "DENY", resp.Headers["x-frame-options"])
+
+	// Test: Date disabled and no server name
+	server = startTestServer(t, Config{Handler: textHandler, DisableDate: true})
+	resp = fetch(t, server, "GET / HTTP/1.1\r\n\r\n")
+	assert.NotContains(t, resp.Headers, "date")
+	assert.NotContains(t, resp.Headers, "server")
+}
+
+func TestDateCache(t *testing.T) {
+	current := time.Date(2024, time.March, 10, 12, 30, 0, 0, time.UTC)
+	cache := dateCache{now: func() time.Time { return current }}
+
+	assert.Equal(t, "Sun, 10 Mar 2024 12:30:00 GMT", cache.get())
+	first := cache.current.Load()
+
+	// Test: Same second reuses formatted value
+	current = current.Add(500 * time.Millisecond)
+	cache.get()
+	assert.Same(t, first, cache.current.Load())
+
+	// Test: Next second formats new value
+	current = current.Add(time.Second)
+	assert.Equal(t, "Sun, 10 Mar 2024 12:30:01 GMT", cache.get())
+}
+
+func TestServerHijack(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.SwitchingProtocolsStatusCode)
+		w.Header().Set("Upgrade", "echo")
+		w.Header().Set("Connection", "Upgrade")
+		w.WriteHeaders(nil)
+
+		conn, buffered, err := w.Hijack()
+		if err != nil {
+			return
+		}
+		// Writer is unusable after hijack
+		_, _, err = w.Hijack()
+		if err == nil {
+			conn.Write([]byte("second hijack succeeded"))
+		}
+
+		go func() {
+			defer conn.Close()
+			conn.Write([]byte("buffered=" + string(buffered) + "\n"))
+			io.Copy(conn, conn)
+		}()
+	}
+	server := startTestServer(t, Config{Handler: handler})
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Protocol data sent together with request ends up in buffered bytes
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nUpgrade: echo\r\n\r\nearly"))
+	require.NoError(t, err)
+
+	expected := "buffered=early\n"
+	received := make([]byte, 0, 1024)
+	buf := make([]byte, 1024)
+	for !strings.HasSuffix(string(received), expected) {
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		received = append(received, buf[:n]...)
+	}
+	resp := string(received)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 101 Switching Protocols\r\n"))
+	assert.NotContains(t, resp, "second hijack succeeded")
+
+	// Connection stays open after handler returned and server doesn't write to it
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	n, err := io.ReadFull(conn, buf[:4])
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf[:n]))
+}
+
+func TestServerPipelining(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		body := []byte(req.RequestLine.Method + " " + req.RequestLine.RequestTarget + " " + string(req.Body))
+		switch req.RequestLine.RequestTarget {
+		case "/chunked":
+			w.WriteStatusLine(response.OkStatusCode)
+			bodyWriter, _ := w.Body()
+			bodyWriter.Write(body[:4])
+			bodyWriter.Write(body[4:])
+			bodyWriter.Close()
+		case "/unframed":
+			// Without Content-Length client reads body until connection is closed
+			w.WriteStatusLine(response.OkStatusCode)
+			w.WriteHeaders(nil)
+			w.WriteBody(body)
+		default:
+			w.WriteStatusLine(response.OkStatusCode)
+			w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+			w.WriteBody(body)
+		}
+	}
+	server := startTestServer(t, Config{Handler: handler})
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Test: Requests sent in a single write are answered in order
+	_, err = conn.Write([]byte("GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"POST /second HTTP/1.1\r\nContent-Length: 4\r\n\r\ndata" +
+		"HEAD /third HTTP/1.1\r\n\r\n" +
+		"POST /chunked HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n" +
+		"GET /last HTTP/1.1\r\nConnection: close\r\n\r\n" +
+		"GET /ignored HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+
+	reader := response.NewReader(conn)
+	expected := []struct {
+		method string
+		body   string
+	}{
+		{"GET", "GET /first "},
+		{"POST", "POST /second data"},
+		{"HEAD", ""},
+		{"POST", "POST /chunked abc"},
+		{"GET", "GET /last "},
+	}
+	for _, e := range expected {
+		resp, err := reader.ReadHead(e.method)
+		require.NoError(t, err)
+		assert.Equal(t, response.OkStatusCode, resp.StatusLine.StatusCode)
+		body, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, e.body, string(body))
+	}
+
+	// Test: Connection: close ends the connection, following requests are not served
+	_, err = reader.ReadHead("GET")
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Response without known body length closes connection
+	resp := roundTrip(t, server, "GET /unframed HTTP/1.1\r\n\r\nGET /ignored HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nGET /unframed "), resp)
+}
+
+func TestServerIdleConnections(t *testing.T) {
+	server := startTestServer(t, Config{Handler: textHandler, IdleTimeout: 50 * time.Millisecond})
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Test: Idle connection is closed after IdleTimeout
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(resp), "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Close closes connections waiting for request
+	server = startTestServer(t, Config{Handler: textHandler})
+	conn, err = net.Dial("tcp", server.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	reader := response.NewReader(conn)
+	_, err = reader.ReadHead("GET")
+	require.NoError(t, err)
+	io.ReadAll(reader)
+
+	require.NoError(t, server.Close())
+	_, err = reader.ReadHead("GET")
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestServerLogging(t *testing.T) {
+	var mu sync.Mutex
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&lockedWriter{mu: &mu, w: &logs}, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	handler := func(w *response.Writer, req *request.Request) {
+		req.Logger().Info("handler called")
+		textHandler(w, req)
+	}
+	server := startTestServer(t, Config{Handler: handler, Logger: logger})
+
+	roundTrip(t, server, "GET /first HTTP/1.1\r\nX-Request-ID: abc-123\r\n\r\nGET /second HTTP/1.1\r\n\r\n")
+	roundTrip(t, server, "garbage\r\n\r\n")
+
+	var entries []map[string]any
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		entries = nil
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			entry := map[string]any{}
+			require.NoError(t, json.Unmarshal([]byte(line), &entry))
+			entries = append(entries, entry)
+		}
+		return len(entries) > 0 && entries[len(entries)-1]["msg"] == "bad request"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	find := func(msg, target string) map[string]any {
+		for _, entry := range entries {
+			if entry["msg"] == msg && (target == "" || entry["target"] == target) {
+				return entry
+			}
+		}
+		t.Fatalf("no %q entry in %v", msg, entries)
+		return nil
+	}
+
+	// Test: Handler logs with connection and request fields
+	handlerEntry := find("handler called", "")
+	assert.Equal(t, "abc-123", handlerEntry["request_id"])
+	assert.NotEmpty(t, handlerEntry["remote_addr"])
+	connectionID := handlerEntry["conn_id"]
+
+	// Test: Request ID is generated when client doesn't send one
+	served := find("request served", "/second")
+	assert.Equal(t, connectionID, served["conn_id"])
+	assert.Equal(t, fmt.Sprintf("%v-2", connectionID), served["request_id"])
+	assert.Equal(t, float64(200), served["status"])
+	assert.Equal(t, float64(5), served["bytes"])
+
+	badRequest := find("bad request", "")
+	assert.Equal(t, "INFO", badRequest["level"])
+	assert.NotEqual(t, connectionID, badRequest["conn_id"])
+
+	// Test: Request not read by server logs nowhere
+	assert.NotNil(t, (&request.Request{}).Logger())
+}
+
+// lockedWriter serializes writes of log entries coming from connection goroutines
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+func TestServerHeaderTooLarge(t *testing.T) {
+	server := startTestServer(t, Config{Handler: textHandler})
+
+	// Test: Oversized header section is answered with 431 and connection is closed
+	resp := roundTrip(t, server, "GET / HTTP/1.1\r\nX-Big: "+strings.Repeat("a", 1<<20)+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 431 Request Header Fields Too Large\r\n"), resp[:min(len(resp), 100)])
+	assert.Equal(t, "close", headerValue(resp, "Connection"))
+}
+
+func TestServerBodyTooLarge(t *testing.T) {
+	server := startTestServer(t, Config{Handler: textHandler, MaxBodySize: 10})
+
+	// Test: Body over MaxBodySize is answered with 413 and connection is closed
+	resp := roundTrip(t, server, "POST / HTTP/1.1\r\nContent-Length: 11\r\n\r\nhello world")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 413 Content Too Large\r\n"), resp)
+	assert.Equal(t, "close", headerValue(resp, "Connection"))
+
+	resp = roundTrip(t, server, "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nb\r\nhello world\r\n0\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 413 Content Too Large\r\n"), resp)
+
+	// Test: Body within the limit is served
+	resp = roundTrip(t, server, "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"), resp)
+}
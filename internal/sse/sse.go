@@ -0,0 +1,212 @@
+// Package sse implements Server-Sent Events (text/event-stream) responses on top of response.Writer
+package sse
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/response"
+)
+
+const defaultKeepAlive = 15 * time.Second
+
+// ErrStreamClosed is returned when sending to stream after client disconnected or stream was closed
+var ErrStreamClosed = errors.New("sse: stream closed")
+
+// Event is single message sent to the client, empty fields are omitted
+type Event struct {
+	// ID is stored by client and sent back in Last-Event-ID header when it reconnects
+	ID string
+	// Event is event type, client dispatches it to listeners of that type ("message" when empty)
+	Event string
+	// Data can contain multiple lines, each is sent as separate data field
+	Data string
+	// Retry tells client how long to wait before reconnecting
+	Retry time.Duration
+}
+
+type Options struct {
+	// KeepAlive is interval of comment lines sent to keep idle connection open,
+	// defaults to 15 seconds, negative value disables keep-alive
+	KeepAlive time.Duration
+}
+
+// Stream writes events to the client. Connection stays open until handler returns,
+// handler loop should stop when Done is closed.
+type Stream struct {
+	writer      *response.Writer
+	body        *response.BodyWriter
+	lastEventID string
+
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewStream writes event stream response headers and starts keep-alive comments.
+// Writer must not have status line written yet. Handler should Close the stream before it returns,
+// stream left open is closed when response is finished so keep-alive never outlives the handler.
+func NewStream(w *response.Writer, req *request.Request, options Options) (*Stream, error) {
+	err := w.WriteStatusLine(response.OkStatusCode)
+	if err != nil {
+		return nil, err
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Del("Content-Length")
+
+	// Taken before headers are written and handler returns, server must not keep connection
+	// alive for next request while background read is waiting on it
+	closeNotify := w.CloseNotify()
+
+	body, err := w.Body()
+	if err != nil {
+		return nil, err
+	}
+	err = w.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	lastEventID, _ := req.Headers.Get("Last-Event-ID")
+	stream := &Stream{
+		writer:      w,
+		body:        body,
+		lastEventID: lastEventID,
+		done:        make(chan struct{}),
+	}
+
+	w.OnFinish(func() { stream.Close() })
+	go stream.watchDisconnect(closeNotify)
+
+	keepAlive := options.KeepAlive
+	if keepAlive == 0 {
+		keepAlive = defaultKeepAlive
+	}
+	if keepAlive > 0 {
+		go stream.keepAlive(keepAlive)
+	}
+
+	return stream, nil
+}
+
+// LastEventID returns ID of last event client received before reconnecting, empty on first connection
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed when client disconnects or stream is closed
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Send writes event and flushes it to the client
+func (s *Stream) Send(event Event) error {
+	if strings.ContainsAny(event.ID, "\r\n\x00") || strings.ContainsAny(event.Event, "\r\n") {
+		return fmt.Errorf("sse: event id and type must be single line")
+	}
+
+	var message strings.Builder
+	if event.ID != "" {
+		message.WriteString("id: " + event.ID + "\n")
+	}
+	if event.Event != "" {
+		message.WriteString("event: " + event.Event + "\n")
+	}
+	if event.Retry > 0 {
+		message.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+	data := strings.ReplaceAll(event.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		message.WriteString("data: " + line + "\n")
+	}
+	message.WriteString("\n")
+
+	return s.write(message.String())
+}
+
+// Comment writes comment line, clients ignore it
+func (s *Stream) Comment(text string) error {
+	var message strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		message.WriteString(": " + strings.TrimRight(line, "\r") + "\n")
+	}
+	message.WriteString("\n")
+	return s.write(message.String())
+}
+
+// Close stops keep-alive and terminates the response body, it is safe to call more than once
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.markDone()
+
+	err := s.body.Close()
+	if err != nil {
+		return err
+	}
+	return s.writer.Flush()
+}
+
+func (s *Stream) write(message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStreamClosed
+	}
+
+	_, err := s.body.Write([]byte(message))
+	if err == nil {
+		err = s.writer.Flush()
+	}
+	if err != nil {
+		// Write failing means client is gone
+		s.closed = true
+		s.markDone()
+		return err
+	}
+	return nil
+}
+
+func (s *Stream) markDone() {
+	s.once.Do(func() { close(s.done) })
+}
+
+func (s *Stream) watchDisconnect(closeNotify <-chan struct{}) {
+	select {
+	case <-closeNotify:
+		s.mu.Lock()
+		s.closed = true
+		s.mu.Unlock()
+		s.markDone()
+	case <-s.done:
+	}
+}
+
+func (s *Stream) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if s.write(": keep-alive\n\n") != nil {
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/response"
+	"github.com/MichalGul/http_server_go/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendFormatsEvents(t *testing.T) {
+	var buf bytes.Buffer
+	w := response.NewWritter(&buf)
+	req := &request.Request{Headers: map[string]string{"last-event-id": "41"}}
+
+	stream, err := NewStream(w, req, Options{KeepAlive: -1})
+	require.NoError(t, err)
+	assert.Equal(t, "41", stream.LastEventID())
+
+	require.NoError(t, stream.Send(Event{ID: "42", Event: "update", Data: "line1\nline2\r\nline3", Retry: 3 * time.Second}))
+	require.NoError(t, stream.Send(Event{Data: "plain"}))
+	require.NoError(t, stream.Comment("ping"))
+	require.Error(t, stream.Send(Event{ID: "bad\nid"}))
+	require.NoError(t, stream.Close())
+	w.Finish()
+
+	resp := buf.String()
+	assert.Contains(t, resp, "Content-Type: text/event-stream\r\n")
+	assert.Contains(t, resp, "Cache-Control: no-cache\r\n")
+	assert.Contains(t, resp, "Transfer-Encoding: chunked\r\n")
+	assert.Contains(t, resp, "id: 42\nevent: update\nretry: 3000\ndata: line1\ndata: line2\ndata: line3\n\n")
+	assert.Contains(t, resp, "data: plain\n\n")
+	assert.Contains(t, resp, ": ping\n\n")
+	assert.True(t, strings.HasSuffix(resp, "0\r\n\r\n"))
+
+	// Test: Send after close
+	assert.ErrorIs(t, stream.Send(Event{Data: "late"}), ErrStreamClosed)
+	select {
+	case <-stream.Done():
+	default:
+		t.Fatal("done not closed after Close")
+	}
+}
+
+func TestStreamKeepAliveAndDisconnect(t *testing.T) {
+	handlerDone := make(chan struct{})
+	handler := func(w *response.Writer, req *request.Request) {
+		defer close(handlerDone)
+		stream, err := NewStream(w, req, Options{KeepAlive: 20 * time.Millisecond})
+		if err != nil {
+			return
+		}
+		defer stream.Close()
+
+		stream.Send(Event{ID: "1", Data: "hello"})
+		// Loop until client goes away
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stream.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+
+	srv, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET /events HTTP/1.1\r\nLast-Event-ID: 0\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Read until both event and keep-alive comment arrived
+	reader := bufio.NewReader(conn)
+	var received strings.Builder
+	for !strings.Contains(received.String(), "keep-alive") {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		received.WriteString(line)
+	}
+	assert.Contains(t, received.String(), "id: 1\ndata: hello\n\n")
+
+	// Test: Handler notices client disconnect
+	conn.Close()
+	select {
+	case <-handlerDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler did not stop after client disconnected")
+	}
+}
+
+func TestStreamHandlerForgetsClose(t *testing.T) {
+	streams := make(chan *Stream, 1)
+	handler := func(w *response.Writer, req *request.Request) {
+		stream, err := NewStream(w, req, Options{KeepAlive: time.Millisecond})
+		if err != nil {
+			return
+		}
+		streams <- stream
+		stream.Send(Event{Data: "only"})
+		// Keep-alive gets chance to run while handler is still here
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	srv, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET /events HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: Response is terminated and connection closed once handler returned without Close
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(resp), "data: only\n\n")
+	assert.True(t, strings.HasSuffix(string(resp), "\r\n0\r\n\r\n"), string(resp))
+
+	// Test: Keep-alive is stopped, stream refuses further writes
+	stream := <-streams
+	select {
+	case <-stream.Done():
+	default:
+		t.Fatal("stream not closed after handler returned")
+	}
+	assert.ErrorIs(t, stream.Comment("late"), ErrStreamClosed)
+}
+
+func TestStreamWithoutNetworkConnection(t *testing.T) {
+	var out bytes.Buffer
+	w := response.NewWritter(&out)
+	stream, err := NewStream(w, &request.Request{Headers: map[string]string{}}, Options{KeepAlive: -1})
+	require.NoError(t, err)
+	assert.Equal(t, "", stream.LastEventID())
+
+	// Test: Connection is not reused after stream, which is announced to client
+	assert.False(t, w.KeepAlive())
+	assert.Contains(t, out.String(), "Connection: close\r\n")
+	require.NoError(t, stream.Close())
+}
@@ -0,0 +1,282 @@
+// Package tunnel handles HTTP CONNECT requests, server acts as forward proxy splicing bytes
+// between client and requested host:port
+package tunnel
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/response"
+	"github.com/MichalGul/http_server_go/internal/server"
+)
+
+const (
+	defaultDialTimeout = 10 * time.Second
+	defaultIdleTimeout = 2 * time.Minute
+)
+
+const copyBufferSize = 32 * 1024
+
+// Proxy opens tunnels for CONNECT requests. Only targets matching Allow list are dialed.
+type Proxy struct {
+	// Allow lists permitted targets as host:port, host can be "*" or "*.example.com"
+	// and port can be "*". Nothing is allowed when empty.
+	Allow []string
+	// DialTimeout of upstream connection, 10 seconds when 0
+	DialTimeout time.Duration
+	// IdleTimeout closes tunnel when no bytes went through in either direction, 2 minutes when 0
+	IdleTimeout time.Duration
+	// OnClose is called with final counters when tunnel is closed
+	OnClose func(stats Stats)
+
+	nextID atomic.Uint64
+	mu     sync.Mutex
+	active map[uint64]*tunnel
+}
+
+// Stats describes single tunnel
+type Stats struct {
+	ID     uint64
+	Target string
+	Client string
+	Start  time.Time
+	// Duration is set for closed tunnels
+	Duration time.Duration
+	// BytesUpstream counts bytes sent from client to target, BytesDownstream from target to client
+	BytesUpstream   int64
+	BytesDownstream int64
+}
+
+type tunnel struct {
+	id           uint64
+	target       string
+	client       string
+	start        time.Time
+	upstream     atomic.Int64
+	downstream   atomic.Int64
+	lastActivity atomic.Int64
+}
+
+func (t *tunnel) stats() Stats {
+	return Stats{
+		ID:              t.id,
+		Target:          t.target,
+		Client:          t.client,
+		Start:           t.start,
+		BytesUpstream:   t.upstream.Load(),
+		BytesDownstream: t.downstream.Load(),
+	}
+}
+
+func (t *tunnel) touch() {
+	t.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (t *tunnel) idleFor() time.Duration {
+	return time.Since(time.Unix(0, t.lastActivity.Load()))
+}
+
+// Serve is server.Handler answering CONNECT requests
+func (p *Proxy) Serve(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method != "CONNECT" {
+		w.Header().Set("Allow", "CONNECT")
+		server.HandlerError{StatusCode: response.MethodNotAllowedStatusCode, Message: "only CONNECT is supported"}.Write(w)
+		return
+	}
+
+	target := req.RequestLine.RequestTarget
+	host, port, err := splitTarget(target)
+	if err != nil {
+		server.HandlerError{StatusCode: response.BadRequestStatusCode, Message: err.Error()}.Write(w)
+		return
+	}
+	if !p.allowed(host, port) {
+		server.HandlerError{StatusCode: response.ForbiddenStatusCode, Message: "tunnel to " + target + " is not allowed"}.Write(w)
+		return
+	}
+
+	dialTimeout := p.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = defaultDialTimeout
+	}
+	upstream, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), dialTimeout)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			server.HandlerError{StatusCode: response.GatewayTimeoutStatusCode, Message: "connecting to " + target + " timed out"}.Write(w)
+			return
+		}
+		server.HandlerError{StatusCode: response.BadGatewayStatusCode, Message: "can't connect to " + target}.Write(w)
+		return
+	}
+	defer upstream.Close()
+
+	client, buffered, err := w.Hijack()
+	if err != nil {
+		server.HandlerError{StatusCode: response.InternalServerErrorStatusCode, Message: "connection can't be hijacked"}.Write(w)
+		return
+	}
+	defer client.Close()
+
+	// Written directly, 2xx answer to CONNECT must not have body framing headers
+	_, err = client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	if err != nil {
+		return
+	}
+
+	t := &tunnel{
+		id:     p.nextID.Add(1),
+		target: target,
+		client: client.RemoteAddr().String(),
+		start:  time.Now(),
+	}
+	t.touch()
+	p.track(t)
+	defer func() {
+		p.untrack(t)
+		if p.OnClose != nil {
+			stats := t.stats()
+			stats.Duration = time.Since(t.start)
+			p.OnClose(stats)
+		}
+	}()
+
+	// Client may start sending (e.g. TLS hello) before reading our answer
+	if len(buffered) > 0 {
+		n, err := upstream.Write(buffered)
+		t.upstream.Add(int64(n))
+		if err != nil {
+			return
+		}
+	}
+
+	idleTimeout := p.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		t.pipe(upstream, client, &t.upstream, idleTimeout)
+	}()
+	go func() {
+		defer wg.Done()
+		t.pipe(client, upstream, &t.downstream, idleTimeout)
+	}()
+	wg.Wait()
+}
+
+// pipe copies src to dst until src is finished. End of stream is passed on as half close so the other
+// direction can still finish, errors and idle timeout close both connections.
+func (t *tunnel) pipe(dst, src net.Conn, counter *atomic.Int64, idleTimeout time.Duration) {
+	buf := make([]byte, copyBufferSize)
+	for {
+		src.SetReadDeadline(time.Now().Add(idleTimeout))
+		n, err := src.Read(buf)
+		if n > 0 {
+			t.touch()
+			counter.Add(int64(n))
+			_, writeErr := dst.Write(buf[:n])
+			if writeErr != nil {
+				src.Close()
+				dst.Close()
+				return
+			}
+		}
+		if err == nil {
+			continue
+		}
+
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() && t.idleFor() < idleTimeout {
+			// Other direction is still active
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			if halfCloser, ok := dst.(interface{ CloseWrite() error }); ok {
+				halfCloser.CloseWrite()
+				return
+			}
+		}
+		src.Close()
+		dst.Close()
+		return
+	}
+}
+
+// Active returns counters of open tunnels ordered by ID
+func (p *Proxy) Active() []Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make([]Stats, 0, len(p.active))
+	for _, t := range p.active {
+		stats = append(stats, t.stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
+
+func (p *Proxy) track(t *tunnel) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.active == nil {
+		p.active = make(map[uint64]*tunnel)
+	}
+	p.active[t.id] = t
+}
+
+func (p *Proxy) untrack(t *tunnel) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.active, t.id)
+}
+
+func (p *Proxy) allowed(host, port string) bool {
+	for _, entry := range p.Allow {
+		allowedHost, allowedPort, err := net.SplitHostPort(entry)
+		if err != nil {
+			continue
+		}
+		if allowedPort != "*" && allowedPort != port {
+			continue
+		}
+		if matchHost(allowedHost, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchHost(pattern, host string) bool {
+	host = strings.ToLower(host)
+	pattern = strings.ToLower(pattern)
+	if pattern == "*" {
+		return true
+	}
+	if suffix, isWildcard := strings.CutPrefix(pattern, "*."); isWildcard {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return pattern == host
+}
+
+// splitTarget validates authority-form request target (host:port)
+func splitTarget(target string) (string, string, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil || host == "" {
+		return "", "", errors.New("CONNECT target must be host:port")
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil || portNumber < 1 || portNumber > 65535 {
+		return "", "", errors.New("invalid CONNECT port: " + port)
+	}
+	return host, port, nil
+}
@@ -0,0 +1,171 @@
+package tunnel
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startEchoServer runs TCP server writing back everything it receives
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func startProxy(t *testing.T, proxy *Proxy) string {
+	t.Helper()
+	srv, err := server.Serve(0, proxy.Serve)
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+	return srv.Addr().String()
+}
+
+// connect sends CONNECT request and returns connection with reader positioned after response head
+func connect(t *testing.T, proxyAddress, rawRequest string) (net.Conn, *bufio.Reader, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", proxyAddress)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte(rawRequest))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	statusLine, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+	return conn, reader, statusLine
+}
+
+func TestTunnel(t *testing.T) {
+	echoAddress := startEchoServer(t)
+	closed := make(chan Stats, 1)
+	proxy := &Proxy{Allow: []string{"127.0.0.1:*"}, OnClose: func(stats Stats) { closed <- stats }}
+	proxyAddress := startProxy(t, proxy)
+
+	// Bytes sent together with request are forwarded as well
+	conn, reader, statusLine := connect(t, proxyAddress, "CONNECT "+echoAddress+" HTTP/1.1\r\nHost: "+echoAddress+"\r\n\r\nearly ")
+	assert.Equal(t, "HTTP/1.1 200 Connection Established\r\n", statusLine)
+
+	_, err := conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	echoed := make([]byte, len("early hello"))
+	_, err = io.ReadFull(reader, echoed)
+	require.NoError(t, err)
+	assert.Equal(t, "early hello", string(echoed))
+
+	// Test: Active tunnel counters
+	active := proxy.Active()
+	require.Len(t, active, 1)
+	assert.Equal(t, echoAddress, active[0].Target)
+	assert.Equal(t, int64(11), active[0].BytesUpstream)
+
+	// Test: Client half close finishes tunnel after upstream is done
+	conn.(*net.TCPConn).CloseWrite()
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+
+	select {
+	case stats := <-closed:
+		assert.Equal(t, int64(11), stats.BytesUpstream)
+		assert.Equal(t, int64(11), stats.BytesDownstream)
+		assert.Greater(t, stats.Duration, time.Duration(0))
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel was not closed")
+	}
+	assert.Empty(t, proxy.Active())
+}
+
+func TestTunnelIdleTimeout(t *testing.T) {
+	echoAddress := startEchoServer(t)
+	proxyAddress := startProxy(t, &Proxy{Allow: []string{"127.0.0.1:*"}, IdleTimeout: 100 * time.Millisecond})
+
+	conn, reader, statusLine := connect(t, proxyAddress, "CONNECT "+echoAddress+" HTTP/1.1\r\n\r\n")
+	require.Equal(t, "HTTP/1.1 200 Connection Established\r\n", statusLine)
+
+	start := time.Now()
+	_, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 3*time.Second)
+
+	// Connection is closed by proxy
+	_, err = conn.Write([]byte("late"))
+	if err == nil {
+		_, err = reader.ReadByte()
+	}
+	assert.Error(t, err)
+}
+
+func TestTunnelErrors(t *testing.T) {
+	echoAddress := startEchoServer(t)
+	proxyAddress := startProxy(t, &Proxy{Allow: []string{"127.0.0.1:*", "*.example.com:443"}})
+
+	// Test: Target not in allow list
+	_, _, statusLine := connect(t, proxyAddress, "CONNECT localhost:22 HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 403 Forbidden\r\n", statusLine)
+
+	// Test: Target which is not host:port
+	_, _, statusLine = connect(t, proxyAddress, "CONNECT /index.html HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\n", statusLine)
+
+	// Test: Other methods
+	_, _, statusLine = connect(t, proxyAddress, "GET / HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 405 Method Not Allowed\r\n", statusLine)
+
+	// Test: Upstream refuses connection
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddress := listener.Addr().String()
+	listener.Close()
+	_, _, statusLine = connect(t, proxyAddress, "CONNECT "+closedAddress+" HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 502 Bad Gateway\r\n", statusLine)
+
+	// Test: Allowed target still works
+	_, _, statusLine = connect(t, proxyAddress, "CONNECT "+echoAddress+" HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(statusLine, "HTTP/1.1 200"))
+}
+
+func TestAllowed(t *testing.T) {
+	proxy := &Proxy{Allow: []string{"example.com:443", "*.internal:*", "[::1]:8080"}}
+
+	assert.True(t, proxy.allowed("example.com", "443"))
+	assert.True(t, proxy.allowed("EXAMPLE.com", "443"))
+	assert.False(t, proxy.allowed("example.com", "80"))
+	assert.False(t, proxy.allowed("evil-example.com", "443"))
+	assert.True(t, proxy.allowed("db.internal", "5432"))
+	assert.False(t, proxy.allowed("internal", "5432"))
+	assert.True(t, proxy.allowed("::1", "8080"))
+
+	// Test: Nothing is allowed by default
+	assert.False(t, (&Proxy{}).allowed("example.com", "443"))
+}
@@ -0,0 +1,432 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Close codes from RFC 6455 section 7.4.1
+const (
+	CloseNormalClosure      = 1000
+	CloseGoingAway          = 1001
+	CloseProtocolError      = 1002
+	CloseUnsupportedData    = 1003
+	CloseNoStatusReceived   = 1005
+	CloseAbnormalClosure    = 1006
+	CloseInvalidPayloadData = 1007
+	ClosePolicyViolation    = 1008
+	CloseMessageTooBig      = 1009
+	CloseMandatoryExtension = 1010
+	CloseInternalServerErr  = 1011
+)
+
+// Control frames payload can't be longer than that
+const maxControlPayload = 125
+
+// ErrCloseSent is returned when writing message after close frame was sent
+var ErrCloseSent = errors.New("websocket: close frame already sent")
+
+// CloseError is returned by ReadMessage when connection was closed. It holds code received in peer close frame
+// or, when protocol violation was detected locally, code which was sent to the peer.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	if e.Text == "" {
+		return "websocket: close " + strconv.Itoa(e.Code)
+	}
+	return "websocket: close " + strconv.Itoa(e.Code) + ": " + e.Text
+}
+
+// IsCloseError reports whether err is *CloseError with one of the codes
+func IsCloseError(err error, codes ...int) bool {
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) {
+		return false
+	}
+	for _, code := range codes {
+		if closeErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is WebSocket connection. ReadMessage has to be called from single goroutine,
+// writes are safe to use concurrently.
+type Conn struct {
+	conn           net.Conn
+	reader         *bufio.Reader
+	isServer       bool
+	maxMessageSize int64
+	fragmentSize   int
+	subprotocol    string
+	pongHandler    func(data []byte)
+
+	// error returned by every read after connection was closed
+	readErr error
+
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+type frame struct {
+	fin     bool
+	rsv     byte
+	opcode  byte
+	payload []byte
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, isServer bool, maxMessageSize int64, fragmentSize int) *Conn {
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefaultMaxMessageSize
+	}
+	return &Conn{
+		conn:           conn,
+		reader:         reader,
+		isServer:       isServer,
+		maxMessageSize: maxMessageSize,
+		fragmentSize:   fragmentSize,
+	}
+}
+
+// Subprotocol returns negotiated subprotocol, empty when none was selected
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// SetPongHandler sets function called from ReadMessage for every received pong
+func (c *Conn) SetPongHandler(handler func(data []byte)) {
+	c.pongHandler = handler
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ReadMessage returns next data message joining its fragments. Pings are answered and pongs passed to
+// pong handler while waiting. When close frame is received it is echoed back and *CloseError returned.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+
+	var messageType MessageType
+	var message []byte
+	for {
+		f, err := c.readFrame(c.maxMessageSize - int64(len(message)))
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+
+		switch f.opcode {
+		case opPing:
+			err = c.writeFrame(opPong, true, f.payload)
+			if err != nil && !errors.Is(err, ErrCloseSent) {
+				return 0, nil, c.fail(err)
+			}
+			continue
+		case opPong:
+			if c.pongHandler != nil {
+				c.pongHandler(f.payload)
+			}
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(f.payload)
+		case opText, opBinary:
+			if messageType != 0 {
+				return 0, nil, c.fail(&CloseError{Code: CloseProtocolError, Text: "expected continuation frame"})
+			}
+			messageType = MessageType(f.opcode)
+		case opContinuation:
+			if messageType == 0 {
+				return 0, nil, c.fail(&CloseError{Code: CloseProtocolError, Text: "unexpected continuation frame"})
+			}
+		}
+
+		message = append(message, f.payload...)
+		if f.fin {
+			if messageType == TextMessage && !utf8.Valid(message) {
+				return 0, nil, c.fail(&CloseError{Code: CloseInvalidPayloadData, Text: "invalid utf-8 in text message"})
+			}
+			return messageType, message, nil
+		}
+	}
+}
+
+// readFrame reads single frame and unmasks its payload, data frame payload longer than limit is rejected
+// before it is read
+func (c *Conn) readFrame(limit int64) (frame, error) {
+	var head [2]byte
+	_, err := io.ReadFull(c.reader, head[:])
+	if err != nil {
+		return frame{}, err
+	}
+
+	f := frame{
+		fin:    head[0]&0x80 != 0,
+		rsv:    head[0] & 0x70,
+		opcode: head[0] & 0x0F,
+	}
+	masked := head[1]&0x80 != 0
+	length := int64(head[1] & 0x7F)
+
+	// Reserved bits are defined only by extensions and none is negotiated
+	if f.rsv != 0 {
+		return frame{}, &CloseError{Code: CloseProtocolError, Text: "reserved bits set without negotiated extension"}
+	}
+	switch f.opcode {
+	case opContinuation, opText, opBinary:
+	case opClose, opPing, opPong:
+		if !f.fin {
+			return frame{}, &CloseError{Code: CloseProtocolError, Text: "fragmented control frame"}
+		}
+		if length > maxControlPayload {
+			return frame{}, &CloseError{Code: CloseProtocolError, Text: "control frame too long"}
+		}
+	default:
+		return frame{}, &CloseError{Code: CloseProtocolError, Text: "unknown opcode " + strconv.Itoa(int(f.opcode))}
+	}
+	// Client has to mask every frame, server must not mask any
+	if masked != c.isServer {
+		if c.isServer {
+			return frame{}, &CloseError{Code: CloseProtocolError, Text: "client frame is not masked"}
+		}
+		return frame{}, &CloseError{Code: CloseProtocolError, Text: "server frame is masked"}
+	}
+
+	switch length {
+	case 126:
+		var extended [2]byte
+		_, err = io.ReadFull(c.reader, extended[:])
+		if err != nil {
+			return frame{}, err
+		}
+		length = int64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		_, err = io.ReadFull(c.reader, extended[:])
+		if err != nil {
+			return frame{}, err
+		}
+		if extended[0]&0x80 != 0 {
+			return frame{}, &CloseError{Code: CloseProtocolError, Text: "invalid payload length"}
+		}
+		length = int64(binary.BigEndian.Uint64(extended[:]))
+	}
+
+	if f.opcode < opClose && length > limit {
+		return frame{}, &CloseError{Code: CloseMessageTooBig, Text: "message too big"}
+	}
+
+	var maskKey [4]byte
+	if masked {
+		_, err = io.ReadFull(c.reader, maskKey[:])
+		if err != nil {
+			return frame{}, err
+		}
+	}
+
+	f.payload = make([]byte, length)
+	_, err = io.ReadFull(c.reader, f.payload)
+	if err != nil {
+		return frame{}, err
+	}
+	if masked {
+		maskBytes(maskKey, f.payload)
+	}
+	return f, nil
+}
+
+// handleClose validates close frame from peer, echoes it and closes connection
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	switch {
+	case len(payload) == 1:
+		return c.fail(&CloseError{Code: CloseProtocolError, Text: "invalid close frame payload"})
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Text = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(&CloseError{Code: CloseProtocolError, Text: "invalid close code " + strconv.Itoa(closeErr.Code)})
+		}
+		if !utf8.ValidString(closeErr.Text) {
+			return c.fail(&CloseError{Code: CloseInvalidPayloadData, Text: "invalid utf-8 in close reason"})
+		}
+	}
+
+	// Echo status code, close frame without code is answered with empty one
+	echo := payload
+	if len(echo) >= 2 {
+		echo = echo[:2]
+	}
+	c.writeFrame(opClose, true, echo)
+	c.conn.Close()
+	c.readErr = closeErr
+	return closeErr
+}
+
+// fail closes connection after read error. Protocol violations are reported to the peer with close frame first.
+func (c *Conn) fail(err error) error {
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		c.WriteClose(closeErr.Code, closeErr.Text)
+	}
+	c.conn.Close()
+	c.readErr = err
+	return err
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003:
+		return true
+	case code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// WriteMessage sends data message, it is split into fragments when fragment size is set
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", messageType)
+	}
+	if messageType == TextMessage && !utf8.Valid(data) {
+		return fmt.Errorf("websocket: text message is not valid utf-8")
+	}
+
+	opcode := byte(messageType)
+	for c.fragmentSize > 0 && len(data) > c.fragmentSize {
+		err := c.writeFrame(opcode, false, data[:c.fragmentSize])
+		if err != nil {
+			return err
+		}
+		data = data[c.fragmentSize:]
+		opcode = opContinuation
+	}
+	return c.writeFrame(opcode, true, data)
+}
+
+// WritePing sends ping, peer answers it with pong carrying the same data
+func (c *Conn) WritePing(data []byte) error {
+	if len(data) > maxControlPayload {
+		return fmt.Errorf("websocket: ping payload too long")
+	}
+	return c.writeFrame(opPing, true, data)
+}
+
+func (c *Conn) WritePong(data []byte) error {
+	if len(data) > maxControlPayload {
+		return fmt.Errorf("websocket: pong payload too long")
+	}
+	return c.writeFrame(opPong, true, data)
+}
+
+// WriteClose sends close frame starting closing handshake, peer answer is returned from ReadMessage as *CloseError.
+// Nothing but control frames can be written after it.
+func (c *Conn) WriteClose(code int, text string) error {
+	payload := make([]byte, 2, 2+len(text))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, text...)
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+	return c.writeFrame(opClose, true, payload)
+}
+
+// Close sends normal closure when close frame wasn't sent yet and closes connection without waiting for peer
+func (c *Conn) Close() error {
+	c.WriteClose(CloseNormalClosure, "")
+	return c.conn.Close()
+}
+
+func (c *Conn) writeFrame(opcode byte, fin bool, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if opcode == opClose {
+		c.closeSent = true
+	}
+
+	buf := make([]byte, 0, 14+len(payload))
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	buf = append(buf, first)
+
+	var maskBit byte
+	if !c.isServer {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		buf = append(buf, maskBit|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(payload)))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(len(payload)))
+	}
+
+	if c.isServer {
+		buf = append(buf, payload...)
+	} else {
+		var maskKey [4]byte
+		rand.Read(maskKey[:])
+		buf = append(buf, maskKey[:]...)
+		start := len(buf)
+		buf = append(buf, payload...)
+		maskBytes(maskKey, buf[start:])
+	}
+
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+func maskBytes(key [4]byte, data []byte) {
+	for i := range data {
+		data[i] ^= key[i%4]
+	}
+}
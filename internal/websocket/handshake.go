@@ -0,0 +1,301 @@
+// Package websocket implements WebSocket protocol (RFC 6455) on top of server handlers
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/response"
+)
+
+// GUID appended to client key when computing Sec-WebSocket-Accept
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const protocolVersion = "13"
+
+// DefaultMaxMessageSize is used when Upgrader or DialOptions don't set MaxMessageSize
+const DefaultMaxMessageSize = 1 << 20
+
+// HandshakeError is returned when request is not valid WebSocket opening handshake,
+// Upgrade has already answered it with StatusCode
+type HandshakeError struct {
+	StatusCode response.StatusCode
+	Message    string
+}
+
+func (e *HandshakeError) Error() string {
+	return "websocket: " + e.Message
+}
+
+// Extension is single entry of Sec-WebSocket-Extensions header
+type Extension struct {
+	Name   string
+	Params map[string]string
+}
+
+// Upgrader switches HTTP requests to WebSocket connections
+type Upgrader struct {
+	// Subprotocols supported by server in order of preference, first one offered by client is selected
+	Subprotocols []string
+	// MaxMessageSize limits size of received message, DefaultMaxMessageSize when 0
+	MaxMessageSize int64
+	// WriteFragmentSize splits written messages into fragments of that size, 0 sends single frame
+	WriteFragmentSize int
+	// CheckOrigin rejects request with 403 when it returns false, all origins are accepted when nil
+	CheckOrigin func(req *request.Request) bool
+}
+
+// Upgrade validates opening handshake, answers with 101 Switching Protocols and takes over the connection.
+// On failure error response is written and *HandshakeError returned. Handler owns returned Conn
+// and has to Close it.
+func (u Upgrader) Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	key, err := u.checkHandshake(req)
+	if err != nil {
+		handshakeErr := err.(*HandshakeError)
+		w.WriteStatusLine(handshakeErr.StatusCode)
+		h := response.GetDefaultHeaders(len(handshakeErr.Message))
+		if handshakeErr.StatusCode == response.UpgradeRequiredStatusCode {
+			h.Set("Sec-WebSocket-Version", protocolVersion)
+		}
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(handshakeErr.Message))
+		return nil, err
+	}
+
+	// No extension is implemented, offered ones are declined by leaving Sec-WebSocket-Extensions out
+	subprotocol := u.selectSubprotocol(req)
+
+	err = w.WriteStatusLine(response.SwitchingProtocolsStatusCode)
+	if err != nil {
+		return nil, err
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Content-Type")
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", AcceptKey(key))
+	if subprotocol != "" {
+		h.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	err = w.WriteHeaders(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	netConn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// Client may send first frames right after handshake, they could be read together with request
+	reader := bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), netConn))
+	conn := newConn(netConn, reader, true, u.MaxMessageSize, u.WriteFragmentSize)
+	conn.subprotocol = subprotocol
+	return conn, nil
+}
+
+// checkHandshake returns client key when request is valid opening handshake
+func (u Upgrader) checkHandshake(req *request.Request) (string, error) {
+	if req.RequestLine.Method != "GET" {
+		return "", &HandshakeError{StatusCode: response.MethodNotAllowedStatusCode, Message: "handshake method must be GET"}
+	}
+	if connection, _ := req.Headers.Get("Connection"); !headers.HasToken(connection, "upgrade") {
+		return "", &HandshakeError{StatusCode: response.BadRequestStatusCode, Message: "missing Connection: upgrade"}
+	}
+	if upgrade, _ := req.Headers.Get("Upgrade"); !headers.HasToken(upgrade, "websocket") {
+		return "", &HandshakeError{StatusCode: response.BadRequestStatusCode, Message: "missing Upgrade: websocket"}
+	}
+	if version, _ := req.Headers.Get("Sec-WebSocket-Version"); strings.TrimSpace(version) != protocolVersion {
+		return "", &HandshakeError{StatusCode: response.UpgradeRequiredStatusCode, Message: "unsupported websocket version"}
+	}
+	key, _ := req.Headers.Get("Sec-WebSocket-Key")
+	key = strings.TrimSpace(key)
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 16 {
+		return "", &HandshakeError{StatusCode: response.BadRequestStatusCode, Message: "invalid Sec-WebSocket-Key"}
+	}
+	if u.CheckOrigin != nil && !u.CheckOrigin(req) {
+		return "", &HandshakeError{StatusCode: response.ForbiddenStatusCode, Message: "origin not allowed"}
+	}
+	return key, nil
+}
+
+func (u Upgrader) selectSubprotocol(req *request.Request) string {
+	offered, _ := req.Headers.Get("Sec-WebSocket-Protocol")
+	offeredList := splitTokens(offered)
+	for _, supported := range u.Subprotocols {
+		for _, protocol := range offeredList {
+			if protocol == supported {
+				return protocol
+			}
+		}
+	}
+	return ""
+}
+
+// AcceptKey computes Sec-WebSocket-Accept value for client Sec-WebSocket-Key
+func AcceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// ParseExtensions parses Sec-WebSocket-Extensions value, e.g. "permessage-deflate; client_max_window_bits, x-foo"
+func ParseExtensions(value string) []Extension {
+	var extensions []Extension
+	for _, part := range strings.Split(value, ",") {
+		params := strings.Split(part, ";")
+		name := strings.TrimSpace(params[0])
+		if name == "" {
+			continue
+		}
+		extension := Extension{Name: name, Params: map[string]string{}}
+		for _, param := range params[1:] {
+			paramName, paramValue, _ := strings.Cut(param, "=")
+			paramName = strings.TrimSpace(paramName)
+			if paramName == "" {
+				continue
+			}
+			extension.Params[paramName] = strings.Trim(strings.TrimSpace(paramValue), `"`)
+		}
+		extensions = append(extensions, extension)
+	}
+	return extensions
+}
+
+// DialOptions configures client side of connection
+type DialOptions struct {
+	// Subprotocols offered to server
+	Subprotocols []string
+	// Header holds additional request headers
+	Header headers.Headers
+	// MaxMessageSize limits size of received message, DefaultMaxMessageSize when 0
+	MaxMessageSize int64
+	// WriteFragmentSize splits written messages into fragments of that size, 0 sends single frame
+	WriteFragmentSize int
+	// Timeout of connecting and handshake, no timeout when 0
+	Timeout time.Duration
+}
+
+// Dial connects to address (host:port) and performs opening handshake for path.
+func Dial(address, path string, options DialOptions) (*Conn, error) {
+	dialer := net.Dialer{Timeout: options.Timeout}
+	netConn, err := dialer.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := Client(netConn, address, path, options)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Client performs opening handshake over already established connection,
+// e.g. one half of net.Pipe in tests
+func Client(netConn net.Conn, host, path string, options DialOptions) (*Conn, error) {
+	if options.Timeout > 0 {
+		netConn.SetDeadline(time.Now().Add(options.Timeout))
+		defer netConn.SetDeadline(time.Time{})
+	}
+
+	keyBytes := make([]byte, 16)
+	rand.Read(keyBytes)
+	key := base64.StdEncoding.EncodeToString(keyBytes)
+
+	var handshake strings.Builder
+	handshake.WriteString("GET " + path + " HTTP/1.1\r\n")
+	handshake.WriteString("Host: " + host + "\r\n")
+	handshake.WriteString("Upgrade: websocket\r\n")
+	handshake.WriteString("Connection: Upgrade\r\n")
+	handshake.WriteString("Sec-WebSocket-Key: " + key + "\r\n")
+	handshake.WriteString("Sec-WebSocket-Version: " + protocolVersion + "\r\n")
+	if len(options.Subprotocols) > 0 {
+		handshake.WriteString("Sec-WebSocket-Protocol: " + strings.Join(options.Subprotocols, ", ") + "\r\n")
+	}
+	for name, value := range options.Header {
+		handshake.WriteString(name + ": " + value + "\r\n")
+	}
+	handshake.WriteString("\r\n")
+	_, err := netConn.Write([]byte(handshake.String()))
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(netConn)
+	statusLine, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	statusParts := strings.SplitN(strings.TrimRight(statusLine, "\r\n"), " ", 3)
+	if len(statusParts) < 2 || statusParts[1] != "101" {
+		return nil, fmt.Errorf("websocket: handshake rejected: %s", strings.TrimSpace(statusLine))
+	}
+
+	responseHeaders := headers.NewHeaders()
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		_, done, err := responseHeaders.Parse([]byte(line))
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			break
+		}
+	}
+
+	upgrade, _ := responseHeaders.Get("Upgrade")
+	connection, _ := responseHeaders.Get("Connection")
+	if !headers.HasToken(upgrade, "websocket") || !headers.HasToken(connection, "upgrade") {
+		return nil, fmt.Errorf("websocket: server did not switch protocols")
+	}
+	if accept, _ := responseHeaders.Get("Sec-WebSocket-Accept"); accept != AcceptKey(key) {
+		return nil, fmt.Errorf("websocket: invalid Sec-WebSocket-Accept")
+	}
+	subprotocol, _ := responseHeaders.Get("Sec-WebSocket-Protocol")
+	if subprotocol != "" && !containsFold(options.Subprotocols, subprotocol) {
+		return nil, fmt.Errorf("websocket: server selected subprotocol %s which was not offered", subprotocol)
+	}
+	// No extension is offered, server must not select any (RFC 6455 section 4.1)
+	extensionsValue, _ := responseHeaders.Get("Sec-WebSocket-Extensions")
+	if extensions := ParseExtensions(extensionsValue); len(extensions) > 0 {
+		return nil, fmt.Errorf("websocket: server selected extension %s which was not offered", extensions[0].Name)
+	}
+
+	conn := newConn(netConn, reader, false, options.MaxMessageSize, options.WriteFragmentSize)
+	conn.subprotocol = subprotocol
+	return conn, nil
+}
+
+func splitTokens(value string) []string {
+	var tokens []string
+	for _, token := range strings.Split(value, ",") {
+		token = strings.TrimSpace(token)
+		if token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
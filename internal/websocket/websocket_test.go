@@ -0,0 +1,297 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichalGul/http_server_go/internal/headers"
+	"github.com/MichalGul/http_server_go/internal/request"
+	"github.com/MichalGul/http_server_go/internal/response"
+	"github.com/MichalGul/http_server_go/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startEchoServer runs server answering every message with the same message
+func startEchoServer(t *testing.T, upgrader Upgrader) string {
+	t.Helper()
+	handler := func(w *response.Writer, req *request.Request) {
+		conn, err := upgrader.Upgrade(w, req)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if conn.WriteMessage(messageType, data) != nil {
+				return
+			}
+		}
+	}
+	srv, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+	return srv.Addr().String()
+}
+
+func TestAcceptKey(t *testing.T) {
+	// Example from RFC 6455 section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestParseExtensions(t *testing.T) {
+	extensions := ParseExtensions(`permessage-deflate; client_max_window_bits; server_max_window_bits="10", x-foo`)
+	require.Len(t, extensions, 2)
+	assert.Equal(t, "permessage-deflate", extensions[0].Name)
+	assert.Equal(t, "10", extensions[0].Params["server_max_window_bits"])
+	_, exists := extensions[0].Params["client_max_window_bits"]
+	assert.True(t, exists)
+	assert.Equal(t, "x-foo", extensions[1].Name)
+
+	assert.Empty(t, ParseExtensions(""))
+}
+
+func TestEcho(t *testing.T) {
+	address := startEchoServer(t, Upgrader{Subprotocols: []string{"chat", "superchat"}})
+
+	conn, err := Dial(address, "/ws", DialOptions{
+		Subprotocols:      []string{"superchat", "chat"},
+		Header:            headers.Headers{"sec-websocket-extensions": "permessage-deflate; client_max_window_bits"},
+		WriteFragmentSize: 4,
+		Timeout:           5 * time.Second,
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// Test: Negotiation uses server preference
+	// Offered extension is declined, Dial fails when server selects extension
+	assert.Equal(t, "chat", conn.Subprotocol())
+
+	// Test: Text message sent in fragments
+	require.NoError(t, conn.WriteMessage(TextMessage, []byte("hello websocket")))
+	messageType, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, messageType)
+	assert.Equal(t, "hello websocket", string(data))
+
+	// Test: Binary message with 16 bit length
+	payload := make([]byte, 300)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	require.NoError(t, conn.WriteMessage(BinaryMessage, payload))
+	messageType, data, err = conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, BinaryMessage, messageType)
+	assert.Equal(t, payload, data)
+
+	// Test: Ping is answered with pong
+	var pong string
+	conn.SetPongHandler(func(data []byte) { pong = string(data) })
+	require.NoError(t, conn.WritePing([]byte("ping")))
+	require.NoError(t, conn.WriteMessage(TextMessage, []byte("after ping")))
+	_, data, err = conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "ping", pong)
+	assert.Equal(t, "after ping", string(data))
+
+	// Test: Closing handshake echoes close code
+	require.NoError(t, conn.WriteClose(CloseGoingAway, "bye"))
+	assert.ErrorIs(t, conn.WriteMessage(TextMessage, []byte("late")), ErrCloseSent)
+	_, _, err = conn.ReadMessage()
+	assert.True(t, IsCloseError(err, CloseGoingAway))
+}
+
+// rawClient performs handshake and returns websocket connection together with underlying connection
+// so tests can write frames breaking the protocol
+func rawClient(t *testing.T, address string) (*Conn, net.Conn) {
+	t.Helper()
+	netConn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	t.Cleanup(func() { netConn.Close() })
+	netConn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn, err := Client(netConn, address, "/", DialOptions{})
+	require.NoError(t, err)
+	return conn, netConn
+}
+
+func TestProtocolViolations(t *testing.T) {
+	address := startEchoServer(t, Upgrader{MaxMessageSize: 16})
+
+	// Test: Unmasked client frame
+	conn, netConn := rawClient(t, address)
+	_, err := netConn.Write([]byte{0x81, 0x02, 'h', 'i'})
+	require.NoError(t, err)
+	_, _, err = conn.ReadMessage()
+	assert.True(t, IsCloseError(err, CloseProtocolError), "got %v", err)
+
+	// Test: Message over size limit
+	conn, _ = rawClient(t, address)
+	require.NoError(t, conn.WriteMessage(BinaryMessage, make([]byte, 17)))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, IsCloseError(err, CloseMessageTooBig), "got %v", err)
+
+	// Test: Fragments together over size limit
+	conn, _ = rawClient(t, address)
+	conn.fragmentSize = 10
+	require.NoError(t, conn.WriteMessage(BinaryMessage, make([]byte, 20)))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, IsCloseError(err, CloseMessageTooBig), "got %v", err)
+
+	// Test: Invalid utf-8 in text message
+	conn, _ = rawClient(t, address)
+	require.NoError(t, conn.writeFrame(opText, true, []byte{0xff, 0xfe}))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, IsCloseError(err, CloseInvalidPayloadData), "got %v", err)
+
+	// Test: Continuation without starting frame
+	conn, _ = rawClient(t, address)
+	require.NoError(t, conn.writeFrame(opContinuation, true, []byte("x")))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, IsCloseError(err, CloseProtocolError), "got %v", err)
+
+	// Test: Reserved bits without negotiated extension
+	conn, netConn = rawClient(t, address)
+	_, err = netConn.Write([]byte{0xC1, 0x80, 0, 0, 0, 0})
+	require.NoError(t, err)
+	_, _, err = conn.ReadMessage()
+	assert.True(t, IsCloseError(err, CloseProtocolError), "got %v", err)
+
+	// Test: Invalid close code
+	conn, _ = rawClient(t, address)
+	require.NoError(t, conn.WriteClose(1005, ""))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, IsCloseError(err, CloseProtocolError), "got %v", err)
+}
+
+func TestClientRejectsExtension(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	defer serverSide.Close()
+	go func() {
+		// Server accepting permessage-deflate which client never offered
+		reader := bufio.NewReader(serverSide)
+		var key string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+			if name, value, _ := strings.Cut(line, ":"); strings.EqualFold(name, "Sec-WebSocket-Key") {
+				key = strings.TrimSpace(value)
+			}
+		}
+		serverSide.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\nSec-WebSocket-Extensions: permessage-deflate\r\n\r\n"))
+	}()
+
+	// Test: Extension selected by server fails the handshake
+	_, err := Client(clientSide, "example.com", "/", DialOptions{})
+	assert.ErrorContains(t, err, "permessage-deflate")
+}
+
+func TestServerFrameMustNotBeMasked(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	defer serverSide.Close()
+	client := newConn(clientSide, nil, false, 0, 0)
+	client.reader = bufio.NewReader(bytes.NewReader([]byte{0x81, 0x80, 1, 2, 3, 4}))
+	go io.Copy(io.Discard, serverSide)
+
+	_, _, err := client.ReadMessage()
+	assert.True(t, IsCloseError(err, CloseProtocolError), "got %v", err)
+}
+
+// rawHandshake sends request to server and returns its response, sending side is closed
+// after request so server closes the connection once it answered
+func rawHandshake(t *testing.T, address, rawRequest string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte(rawRequest))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(resp)
+}
+
+func TestHandshakeErrors(t *testing.T) {
+	address := startEchoServer(t, Upgrader{
+		CheckOrigin: func(req *request.Request) bool {
+			origin, _ := req.Headers.Get("Origin")
+			return origin == "" || origin == "http://localhost"
+		},
+	})
+	valid := "Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+
+	// Test: Missing upgrade headers
+	resp := rawHandshake(t, address, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"))
+
+	// Test: Unsupported version
+	resp = rawHandshake(t, address, "GET / HTTP/1.1\r\n"+valid+"Sec-WebSocket-Version: 8\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 426 Upgrade Required\r\n"))
+	assert.Contains(t, resp, "Sec-WebSocket-Version: 13\r\n")
+
+	// Test: Invalid key
+	resp = rawHandshake(t, address, "GET / HTTP/1.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: short\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"))
+
+	// Test: Origin rejected
+	resp = rawHandshake(t, address, "GET / HTTP/1.1\r\n"+valid+"Sec-WebSocket-Version: 13\r\nOrigin: http://evil.example\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 403 Forbidden\r\n"))
+
+	// Test: Valid handshake switches protocols
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n" + valid + "Sec-WebSocket-Version: 13\r\n\r\n"))
+	require.NoError(t, err)
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	resp = string(buf[:n])
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 101 Switching Protocols\r\n"))
+	assert.Contains(t, resp, "Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n")
+	assert.Contains(t, resp, "Connection: Upgrade\r\n")
+	assert.NotContains(t, resp, "Content-Length")
+}
+
+func TestFrameSentWithHandshake(t *testing.T) {
+	address := startEchoServer(t, Upgrader{})
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Masked "hi" text frame written in the same packet as handshake request
+	handshake := "GET / HTTP/1.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"
+	frame := []byte{0x81, 0x82, 1, 2, 3, 4, 'h' ^ 1, 'i' ^ 2}
+	_, err = conn.Write(append([]byte(handshake), frame...))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+	client := newConn(conn, reader, false, 0, 0)
+	_, data, err := client.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "hi", string(data))
+}